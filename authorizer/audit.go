@@ -0,0 +1,165 @@
+package authorizer
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// AuditOpt is an option for configuring the audit logger
+type AuditOpt func(a *auditLogger)
+
+// WithAuditSampler sets a function that decides whether a decision is logged.
+// By default every decision is logged
+func WithAuditSampler(sampler func(d *Decision) bool) AuditOpt {
+	return func(a *auditLogger) {
+		a.sampler = sampler
+	}
+}
+
+// WithAuditSampleRate logs only the given fraction (0-1) of allowed decisions.
+// Denied decisions and errors are always logged
+func WithAuditSampleRate(rate float64) AuditOpt {
+	return WithAuditSampler(func(d *Decision) bool {
+		if !d.Allowed || d.Err != nil {
+			return true
+		}
+		return rand.Float64() < rate
+	})
+}
+
+// WithAuditUserIdentity sets the function used to derive the logged user identity from the extracted user.
+// By default the result of the user's GetId() method is logged (if it has one)
+func WithAuditUserIdentity(identity func(user any) string) AuditOpt {
+	return func(a *auditLogger) {
+		a.userIdentity = identity
+	}
+}
+
+// WithAuditRequest enables logging of the request object. The redact function is called with the request before it
+// is logged and should return a copy with sensitive fields removed (see RedactFields)
+func WithAuditRequest(redact func(request any) any) AuditOpt {
+	return func(a *auditLogger) {
+		a.redactRequest = redact
+	}
+}
+
+// WithAuditMetadata enables logging of the given metadata keys
+func WithAuditMetadata(keys ...string) AuditOpt {
+	return func(a *auditLogger) {
+		a.metadataKeys = append(a.metadataKeys, keys...)
+	}
+}
+
+// RedactFields returns a redaction function for WithAuditRequest that clears the given fields (by proto name) from a
+// copy of protobuf requests. Requests that are not protobuf messages are dropped entirely
+func RedactFields(fields ...string) func(request any) any {
+	return func(request any) any {
+		msg, ok := request.(proto.Message)
+		if !ok {
+			return nil
+		}
+		msg = proto.Clone(msg)
+		m := msg.ProtoReflect()
+		for _, f := range fields {
+			if fd := m.Descriptor().Fields().ByName(protoreflect.Name(f)); fd != nil {
+				m.Clear(fd)
+			}
+		}
+		return msg
+	}
+}
+
+type auditLogger struct {
+	logger        *slog.Logger
+	sampler       func(d *Decision) bool
+	userIdentity  func(user any) string
+	redactRequest func(request any) any
+	metadataKeys  []string
+}
+
+// NewAuditHook returns a DecisionHook that writes an access log entry for every decision to the given logger.
+// Allowed decisions are logged at Info level, denied decisions at Warn level and errors at Error level
+func NewAuditHook(logger *slog.Logger, opts ...AuditOpt) DecisionHook {
+	a := &auditLogger{
+		logger:       logger,
+		userIdentity: defaultUserIdentity,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a.log
+}
+
+// WithAuditLogger sets a decision hook that writes an access log entry for every decision to the given logger
+func WithAuditLogger(logger *slog.Logger, opts ...AuditOpt) Opt {
+	return WithDecisionHook(NewAuditHook(logger, opts...))
+}
+
+func (a *auditLogger) log(ctx context.Context, d *Decision) {
+	if a.sampler != nil && !a.sampler(d) {
+		return
+	}
+	var (
+		level = slog.LevelInfo
+		msg   = "authorizer: allowed"
+	)
+	switch {
+	case d.Err != nil:
+		level, msg = slog.LevelError, "authorizer: error"
+	case !d.Allowed:
+		level, msg = slog.LevelWarn, "authorizer: denied"
+	}
+	if !a.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("method", d.Method),
+		slog.Bool("allowed", d.Allowed),
+		slog.Bool("is_stream", d.IsStream),
+		slog.Duration("latency", d.Latency),
+	}
+	if d.DryRun {
+		attrs = append(attrs, slog.Bool("dry_run", true))
+	}
+	if d.Peer != "" {
+		attrs = append(attrs, slog.String("peer", d.Peer))
+	}
+	if d.User != nil && a.userIdentity != nil {
+		if id := a.userIdentity(d.User); id != "" {
+			attrs = append(attrs, slog.String("user", id))
+		}
+	}
+	if d.MatchedRule >= 0 {
+		attrs = append(attrs, slog.Int("matched_rule", d.MatchedRule))
+	}
+	if d.Err != nil {
+		attrs = append(attrs, slog.String("error", d.Err.Error()))
+	}
+	for _, k := range a.metadataKeys {
+		if v := d.Metadata.Get(k); len(v) > 0 {
+			attrs = append(attrs, slog.Any("metadata."+k, v))
+		}
+	}
+	if a.redactRequest != nil && d.Request != nil {
+		if req := a.redactRequest(d.Request); req != nil {
+			if msg, ok := req.(proto.Message); ok {
+				attrs = append(attrs, slog.String("request", protojson.Format(msg)))
+			} else {
+				attrs = append(attrs, slog.Any("request", req))
+			}
+		}
+	}
+	a.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func defaultUserIdentity(user any) string {
+	if u, ok := user.(interface{ GetId() string }); ok {
+		return u.GetId()
+	}
+	return ""
+}
@@ -2,13 +2,15 @@ package authorizer
 
 import (
 	"context"
+	"regexp"
+	"time"
 
 	`github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors`
 	`github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/selector`
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/peer"
 )
 
 // ExpressionVar is a global variable injected into a Javascript/CEL authorization expression
@@ -25,6 +27,16 @@ const (
 	ExpressionVarIsStream ExpressionVar = "is_stream"
 	// ExpressionVarMethod is the grpc method
 	ExpressionVarMethod ExpressionVar = "method"
+	// ExpressionVarPeer is the client of the request (see RuleExecutionParams.PeerMap)
+	ExpressionVarPeer ExpressionVar = "peer"
+	// ExpressionVarAuthority is the :authority (host) the request was sent to
+	ExpressionVarAuthority ExpressionVar = "authority"
+	// ExpressionVarDeadlineRemaining is the time left until the deadline of the request (0 if there is no deadline)
+	ExpressionVarDeadlineRemaining ExpressionVar = "deadline_remaining"
+	// ExpressionVarNow is the time the request is evaluated
+	ExpressionVarNow ExpressionVar = "now"
+	// ExpressionVarResource is the resource loaded by the rule's resource loader (see ResourceLoaders)
+	ExpressionVarResource ExpressionVar = "resource"
 )
 
 // RuleExecutionParams is the set of parameters passed to the Authorizer.ExecuteRule function
@@ -37,6 +49,14 @@ type RuleExecutionParams struct {
 	Metadata metadata.MD
 	// IsStream is true if the grpc handler is a streaming handler
 	IsStream bool
+	// Peer is the client of the request (nil if unknown)
+	Peer *Peer
+	// Authority is the :authority (host) the request was sent to
+	Authority string
+	// Deadline is the deadline of the request (zero if there is no deadline)
+	Deadline time.Time
+	// Now is the time the request is evaluated (time.Now() is used if zero)
+	Now time.Time
 }
 
 // UserExtractor is a function that extracts a user from a context so it's attributes can be used in rule expression evaluation
@@ -47,11 +67,12 @@ type ctxKey string
 // DefaultUserExtractorKey is the default key used to extract a user from the context
 var DefaultUserExtractorKey ctxKey = "user"
 
-// DefaultUserExtractor is the default user extractor function that extracts a user from the context using the DefaultUserExtractorKey
+// DefaultUserExtractor is the default user extractor function that extracts a user from the context using the DefaultUserExtractorKey.
+// It returns an Unauthenticated error if there is no user in the context
 func DefaultUserExtractor(ctx context.Context) (any, error) {
 	user := ctx.Value(DefaultUserExtractorKey)
 	if user == nil {
-		return nil, status.Errorf(codes.PermissionDenied, "authorizer: permission denied")
+		return nil, Unauthenticated("")
 	}
 	return user, nil
 }
@@ -73,12 +94,32 @@ type Authorizer interface {
 type options struct {
 	userExtractor    UserExtractor
 	whiteListMethods []string
+	whiteListRegexps []*regexp.Regexp
+	whiteList        *methodMatcher
 	selectors        []selector.Matcher
+	decisionHook     DecisionHook
+	tracerProvider   trace.TracerProvider
+	dryRun           bool
+	shadowAuthorizer Authorizer
+	shadowHook       ShadowHook
+	shadowTimeout    time.Duration
+	errorHandler     ErrorHandler
 }
 
 // Opt is an option for configuring the interceptor
 type Opt func(o *options)
 
+func newOptions(opts []Opt) *options {
+	o := &options{
+		errorHandler: DefaultErrorHandler,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.whiteList = newMethodMatcher(o.whiteListMethods, o.whiteListRegexps)
+	return o
+}
+
 // WithUserExtractor sets the user extractor function that will be used by the interceptor
 // to extract a user from the context so it's attributes can be used in rule expression evaluation.
 // It is injected into the expression vm as the "user" variable
@@ -88,10 +129,29 @@ func WithUserExtractor(extractor UserExtractor) Opt {
 	}
 }
 
-// WithWhiteListMethods sets the list of methods that will be allowed without authorization
+// WithWhiteListMethods sets the list of methods that will be allowed without authorization, replacing methods set by
+// previous WithWhiteListMethods or AddWhiteListMethods options.
+// Methods may be full method names or glob patterns where * matches any characters except / -
+// for example "/grpc.health.v1.Health/*" or "/my.pkg.*/*". See HealthCheckMethods and ReflectionMethods for presets
 func WithWhiteListMethods(methods []string) Opt {
 	return func(o *options) {
-		o.whiteListMethods = methods
+		o.whiteListMethods = append([]string{}, methods...)
+	}
+}
+
+// AddWhiteListMethods adds methods (or glob patterns, see WithWhiteListMethods) that will be allowed without
+// authorization to the methods that are already whitelisted - for example
+// AddWhiteListMethods(HealthCheckMethods...)
+func AddWhiteListMethods(methods ...string) Opt {
+	return func(o *options) {
+		o.whiteListMethods = append(o.whiteListMethods, methods...)
+	}
+}
+
+// WithWhiteListRegexps adds regular expressions matching methods that will be allowed without authorization
+func WithWhiteListRegexps(exprs ...*regexp.Regexp) Opt {
+	return func(o *options) {
+		o.whiteListRegexps = append(o.whiteListRegexps, exprs...)
 	}
 }
 
@@ -102,13 +162,28 @@ func WithSelectors(selectors ...selector.Matcher) Opt {
 	}
 }
 
+// WithDecisionHook sets a hook that is called with the outcome of every authorization decision made by the interceptor.
+// The hook receives the original user extractor/authorizer errors - clients only receive sanitized errors.
+// Whitelisted methods and requests skipped by the selectors are not reported.
+// Calling it multiple times registers multiple hooks that are called in order
+func WithDecisionHook(hook DecisionHook) Opt {
+	return func(o *options) {
+		if o.decisionHook == nil {
+			o.decisionHook = hook
+			return
+		}
+		prev := o.decisionHook
+		o.decisionHook = func(ctx context.Context, d *Decision) {
+			prev(ctx, d)
+			hook(ctx, d)
+		}
+	}
+}
+
 // UnaryServerInterceptor uses the given authorizer to authorize unary grpc requests.
 // JavascriptAuthorizer/CELAuthorizer are implementations of Authorizer that use javascript/CEL expressions to authorize requests
 func UnaryServerInterceptor(authorizer Authorizer, opts ...Opt) grpc.UnaryServerInterceptor {
-	o := &options{}
-	for _, opt := range opts {
-		opt(o)
-	}
+	o := newOptions(opts)
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
 		if len(o.selectors) > 0 {
 			meta := interceptors.NewServerCallMeta(info.FullMethod, nil, req)
@@ -126,35 +201,13 @@ func UnaryServerInterceptor(authorizer Authorizer, opts ...Opt) grpc.UnaryServer
 func unaryServerInterceptor(authorizer Authorizer, o *options) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
 
-		for _, m := range o.whiteListMethods {
-			if m == info.FullMethod {
-				return handler(ctx, req)
-			}
-		}
-		var (
-			usr any
-			err error
-		)
-		if o.userExtractor != nil {
-			usr, err = o.userExtractor(ctx)
-			if err != nil {
-				return nil, err
-			}
+		if o.whiteList.Match(info.FullMethod) {
+			return handler(ctx, req)
 		}
-		md, _ := metadata.FromIncomingContext(ctx)
-		authorized, err := authorizer.AuthorizeMethod(ctx, info.FullMethod, &RuleExecutionParams{
-			User:     usr,
-			Request:  req,
-			Metadata: md,
-		})
-		if err != nil {
+		if err := o.authorize(ctx, authorizer, info.FullMethod, req, false); err != nil {
 			return nil, err
 		}
-		if authorized {
-			return handler(ctx, req)
-		}
-
-		return nil, status.Errorf(codes.PermissionDenied, "authorizer: permission denied")
+		return handler(ctx, req)
 	}
 }
 
@@ -162,10 +215,7 @@ func unaryServerInterceptor(authorizer Authorizer, o *options) grpc.UnaryServerI
 // JavascriptAuthorizer/CELAuthorizer are implementations of Authorizer that use javascript/CEL expressions to authorize requests
 // the request object in the expression evaluation is nil because it is not available in the context for streaming requests
 func StreamServerInterceptor(authorizer Authorizer, opts ...Opt) grpc.StreamServerInterceptor {
-	o := &options{}
-	for _, opt := range opts {
-		opt(o)
-	}
+	o := newOptions(opts)
 	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 		if len(o.selectors) > 0 {
 			meta := interceptors.NewServerCallMeta(info.FullMethod, info, nil)
@@ -182,35 +232,125 @@ func StreamServerInterceptor(authorizer Authorizer, opts ...Opt) grpc.StreamServ
 
 func streamServerInterceptor(authorizer Authorizer, o *options) grpc.StreamServerInterceptor {
 	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		for _, m := range o.whiteListMethods {
-			if m == info.FullMethod {
-				return handler(srv, ss)
-			}
-		}
-		var (
-			usr any
-			err error
-		)
-		if o.userExtractor != nil {
-			usr, err = o.userExtractor(ss.Context())
-			if err != nil {
-				return err
-			}
+		if o.whiteList.Match(info.FullMethod) {
+			return handler(srv, ss)
 		}
-		md, _ := metadata.FromIncomingContext(ss.Context())
-		authorized, err := authorizer.AuthorizeMethod(ss.Context(), info.FullMethod, &RuleExecutionParams{
-			User:     usr,
-			Metadata: md,
-			IsStream: true,
-		})
-		if err != nil {
+		if err := o.authorize(ss.Context(), authorizer, info.FullMethod, nil, true); err != nil {
 			return err
 		}
-		if authorized {
-			return handler(srv, ss)
+		return handler(srv, ss)
+	}
+}
+
+// authorize returns nil if the request is authorized, the error returned by the error handler if it is denied or
+// a sanitized error if the user extractor/authorizer failed (see classifyError)
+func (o *options) authorize(ctx context.Context, authorizer Authorizer, method string, req any, isStream bool) error {
+	// the error handler can read the evaluation (for example the rule that denied the request) from the context
+	ctx, eval := WithEvaluation(ctx)
+	authorized, params, err := o.decide(ctx, eval, authorizer, method, req, isStream)
+	if err != nil {
+		// params are only nil if the user extractor failed
+		return classifyError(err, params == nil)
+	}
+	if !authorized {
+		return o.errorHandler(ctx, method, params)
+	}
+	return nil
+}
+
+// decide extracts the user from the context and runs the authorizer against the request. Authorizers report to the
+// evaluation carried by the context. The decision is reported to the decision hook (if any) before it is returned
+func (o *options) decide(ctx context.Context, eval *Evaluation, authorizer Authorizer, method string, req any, isStream bool) (authorized bool, params *RuleExecutionParams, err error) {
+	var (
+		start      = time.Now()
+		usr        any
+		extractErr error
+	)
+	if o.dryRun {
+		// registered first so that it runs after the decision hook has seen the real decision
+		defer func() {
+			if extractErr == nil && (!authorized || err != nil) {
+				authorized, err = true, nil
+			}
+		}()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if o.decisionHook != nil {
+		defer func() {
+			d := &Decision{
+				Method:      method,
+				User:        usr,
+				Request:     req,
+				Metadata:    md,
+				IsStream:    isStream,
+				Backend:     eval.Backend,
+				Allowed:     authorized && err == nil,
+				DryRun:      o.dryRun,
+				MatchedRule: eval.MatchedRule,
+				Latency:     time.Since(start),
+				Err:         err,
+			}
+			if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+				d.Peer = p.Addr.String()
+			}
+			o.decisionHook(ctx, d)
+		}()
+	}
+	if o.userExtractor != nil {
+		usr, err = o.userExtractor(ctx)
+		if err != nil {
+			extractErr = err
+			return false, nil, err
 		}
-		return status.Errorf(codes.PermissionDenied, "authorizer: permission denied")
 	}
+	params = &RuleExecutionParams{
+		User:      usr,
+		Request:   req,
+		Metadata:  md,
+		IsStream:  isStream,
+		Peer:      PeerFromContext(ctx),
+		Authority: authority(md),
+		Now:       start,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		params.Deadline = deadline
+	}
+	if o.tracerProvider != nil {
+		authorized, err = TraceEvaluation(ctx, o.tracerProvider, "", method, func(ctx context.Context) (bool, error) {
+			return authorizer.AuthorizeMethod(ctx, method, params)
+		})
+	} else {
+		authorized, err = authorizer.AuthorizeMethod(ctx, method, params)
+	}
+	if o.shadowAuthorizer != nil {
+		o.shadow(ctx, method, params, authorized, err)
+	}
+	return authorized, params, err
+}
+
+// Enforcer applies an Authorizer with the same options as the interceptors to requests that are not served by a grpc
+// server (http handlers, connect handlers, envoy ext_authz checks). Selectors are not applied
+type Enforcer struct {
+	authorizer Authorizer
+	o          *options
+}
+
+// NewEnforcer returns a new Enforcer that uses the given authorizer and interceptor options
+func NewEnforcer(authorizer Authorizer, opts ...Opt) *Enforcer {
+	return &Enforcer{
+		authorizer: authorizer,
+		o:          newOptions(opts),
+	}
+}
+
+// Authorize authorizes a request to the given full grpc method. The user is extracted from the context and the
+// metadata is read from the incoming context (see metadata.NewIncomingContext).
+// It returns nil if the request is authorized and a grpc status error otherwise
+func (e *Enforcer) Authorize(ctx context.Context, method string, req any, isStream bool) error {
+	if e.o.whiteList.Match(method) {
+		return nil
+	}
+	return e.o.authorize(ctx, e.authorizer, method, req, isStream)
 }
 
 // Chain chains multiple authorizers together - if any authorizer returns true, the request is authorized
@@ -0,0 +1,613 @@
+package authorizer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+type testUser struct {
+	Id string
+}
+
+func (u *testUser) GetId() string {
+	return u.Id
+}
+
+func testContext() context.Context {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-account-id", "123"))
+	return peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}})
+}
+
+func testHandler(ctx context.Context, req any) (any, error) {
+	return "ok", nil
+}
+
+// matchSecondRule allows the request if the second (fake) rule matches
+var matchSecondRule = authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	authorizer.RecordRuleEvaluated(ctx)
+	authorizer.RecordRuleEvaluated(ctx)
+	if params.Metadata.Get("x-account-id")[0] == "123" {
+		authorizer.RecordRuleMatched(ctx, 1)
+		return true, nil
+	}
+	return false, nil
+})
+
+func TestWithDecisionHook(t *testing.T) {
+	type fixture struct {
+		name          string
+		authz         authorizer.Authorizer
+		extractor     authorizer.UserExtractor
+		expectCode    codes.Code
+		expectAllowed bool
+		expectMatched int
+		expectErr     bool
+	}
+	fixtures := []fixture{
+		{
+			name:          "allow",
+			authz:         matchSecondRule,
+			expectCode:    codes.OK,
+			expectAllowed: true,
+			expectMatched: 1,
+		},
+		{
+			name: "deny",
+			authz: authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+				return false, nil
+			}),
+			expectCode:    codes.PermissionDenied,
+			expectMatched: -1,
+		},
+		{
+			name: "authorizer error",
+			authz: authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+				return true, errors.New("boom")
+			}),
+			expectCode:    codes.Internal,
+			expectMatched: -1,
+			expectErr:     true,
+		},
+		{
+			name:  "extractor error",
+			authz: matchSecondRule,
+			extractor: func(ctx context.Context) (any, error) {
+				return nil, status.Error(codes.Unauthenticated, "no token")
+			},
+			expectCode:    codes.Unauthenticated,
+			expectMatched: -1,
+			expectErr:     true,
+		},
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			var decisions []*authorizer.Decision
+			extractor := f.extractor
+			if extractor == nil {
+				extractor = func(ctx context.Context) (any, error) {
+					return &testUser{Id: "u1"}, nil
+				}
+			}
+			interceptor := authorizer.UnaryServerInterceptor(f.authz,
+				authorizer.WithUserExtractor(extractor),
+				authorizer.WithDecisionHook(func(ctx context.Context, d *authorizer.Decision) {
+					decisions = append(decisions, d)
+				}),
+			)
+			_, err := interceptor(testContext(), "request", &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}, testHandler)
+			if status.Code(err) != f.expectCode {
+				t.Fatalf("expected code %v, got %v", f.expectCode, status.Code(err))
+			}
+			if len(decisions) != 1 {
+				t.Fatalf("expected 1 decision, got %d", len(decisions))
+			}
+			d := decisions[0]
+			if d.Method != "/example.Service/Method" {
+				t.Fatalf("unexpected method: %s", d.Method)
+			}
+			if d.Peer != "10.0.0.1:1234" {
+				t.Fatalf("unexpected peer: %s", d.Peer)
+			}
+			if d.Allowed != f.expectAllowed {
+				t.Fatalf("expected allowed=%v, got %v", f.expectAllowed, d.Allowed)
+			}
+			if d.MatchedRule != f.expectMatched {
+				t.Fatalf("expected matched rule %d, got %d", f.expectMatched, d.MatchedRule)
+			}
+			if (d.Err != nil) != f.expectErr {
+				t.Fatalf("expected error=%v, got %v", f.expectErr, d.Err)
+			}
+		})
+	}
+}
+
+func TestWithAuditLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	interceptor := authorizer.UnaryServerInterceptor(matchSecondRule,
+		authorizer.WithUserExtractor(func(ctx context.Context) (any, error) {
+			return &testUser{Id: "u1"}, nil
+		}),
+		authorizer.WithAuditLogger(logger,
+			authorizer.WithAuditMetadata("x-account-id"),
+			authorizer.WithAuditRequest(authorizer.RedactFields("expression")),
+		),
+	)
+	req := &authorize.Rule{Expression: "secret"}
+	if _, err := interceptor(testContext(), req, &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}, testHandler); err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode log entry %q: %v", buf.String(), err)
+	}
+	expect := map[string]any{
+		"msg":          "authorizer: allowed",
+		"method":       "/example.Service/Method",
+		"allowed":      true,
+		"user":         "u1",
+		"peer":         "10.0.0.1:1234",
+		"matched_rule": float64(1),
+		"request":      "{}",
+	}
+	for k, v := range expect {
+		if entry[k] != v {
+			t.Fatalf("expected %s=%v, got %v", k, v, entry[k])
+		}
+	}
+	if req.Expression != "secret" {
+		t.Fatal("expected the original request to be left untouched")
+	}
+	if _, ok := entry["metadata.x-account-id"]; !ok {
+		t.Fatal("expected metadata to be logged")
+	}
+}
+
+func TestWithAuditSampleRate(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, nil))
+	interceptor := authorizer.UnaryServerInterceptor(authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+		return params.Request == "allow", nil
+	}), authorizer.WithAuditLogger(logger, authorizer.WithAuditSampleRate(0)))
+	info := &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}
+	if _, err := interceptor(testContext(), "allow", info, testHandler); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected allowed decision to be sampled out, got %s", buf.String())
+	}
+	if _, err := interceptor(testContext(), "deny", info, testHandler); err == nil {
+		t.Fatal("expected permission denied")
+	}
+	if buf.Len() == 0 {
+		t.Fatal("expected denied decision to be logged")
+	}
+}
+
+func TestWithTracerProvider(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	interceptor := authorizer.UnaryServerInterceptor(matchSecondRule, authorizer.WithTracerProvider(tp))
+	if _, err := interceptor(testContext(), "request", &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}, testHandler); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Name != authorizer.SpanName {
+		t.Fatalf("unexpected span name: %s", spans[0].Name)
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, a := range spans[0].Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if attrs["authorize.method"].AsString() != "/example.Service/Method" {
+		t.Fatalf("unexpected method attribute: %v", attrs["authorize.method"])
+	}
+	if attrs["authorize.decision"].AsString() != "allow" {
+		t.Fatalf("unexpected decision attribute: %v", attrs["authorize.decision"])
+	}
+	if attrs["authorize.rules_evaluated"].AsInt64() != 2 {
+		t.Fatalf("unexpected rules evaluated attribute: %v", attrs["authorize.rules_evaluated"])
+	}
+	if attrs["authorize.matched_rule"].AsInt64() != 1 {
+		t.Fatalf("unexpected matched rule attribute: %v", attrs["authorize.matched_rule"])
+	}
+
+	exporter.Reset()
+	interceptor = authorizer.UnaryServerInterceptor(authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+		return false, errors.New("boom")
+	}), authorizer.WithTracerProvider(tp))
+	if _, err := interceptor(testContext(), "request", &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}, testHandler); err == nil {
+		t.Fatal("expected error")
+	}
+	spans = exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	if spans[0].Status.Code != otelcodes.Error || len(spans[0].Events) == 0 {
+		t.Fatalf("expected error to be recorded on the span, got %v", spans[0].Status)
+	}
+}
+
+func TestWithShadowAuthorizer(t *testing.T) {
+	allowAll := authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+		return true, nil
+	})
+	type fixture struct {
+		name            string
+		candidate       authorizer.Authorizer
+		expectDisagreed bool
+	}
+	fixtures := []fixture{
+		{
+			name:      "agree",
+			candidate: allowAll,
+		},
+		{
+			name: "deny",
+			candidate: authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+				return false, nil
+			}),
+			expectDisagreed: true,
+		},
+		{
+			name: "error",
+			candidate: authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+				return false, errors.New("boom")
+			}),
+			expectDisagreed: true,
+		},
+		{
+			name: "panic",
+			candidate: authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+				panic("boom")
+			}),
+			expectDisagreed: true,
+		},
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			results := make(chan *authorizer.ShadowResult, 1)
+			var decisions []*authorizer.Decision
+			interceptor := authorizer.UnaryServerInterceptor(matchSecondRule,
+				authorizer.WithShadowAuthorizer(f.candidate, func(ctx context.Context, r *authorizer.ShadowResult) {
+					results <- r
+				}),
+				authorizer.WithDecisionHook(func(ctx context.Context, d *authorizer.Decision) {
+					decisions = append(decisions, d)
+				}),
+			)
+			if _, err := interceptor(testContext(), "request", &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}, testHandler); err != nil {
+				t.Fatalf("expected the shadow authorizer not to affect the response, got %v", err)
+			}
+			// the candidate is evaluated in the background
+			var disagreed bool
+			select {
+			case <-results:
+				disagreed = true
+			case <-time.After(100 * time.Millisecond):
+			}
+			if disagreed != f.expectDisagreed {
+				t.Fatalf("expected disagreement=%v, got %v", f.expectDisagreed, disagreed)
+			}
+			if decisions[0].MatchedRule != 1 {
+				t.Fatalf("expected the enforced matched rule to be reported, got %d", decisions[0].MatchedRule)
+			}
+		})
+	}
+}
+
+func TestWithShadowAuthorizer_RequestModified(t *testing.T) {
+	modified := make(chan struct{})
+	candidate := authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+		<-modified
+		return params.Request.(*wrapperspb.StringValue).GetValue() == "original", nil
+	})
+	results := make(chan *authorizer.ShadowResult, 1)
+	interceptor := authorizer.UnaryServerInterceptor(matchSecondRule,
+		authorizer.WithShadowAuthorizer(candidate, func(ctx context.Context, r *authorizer.ShadowResult) {
+			results <- r
+		}),
+	)
+	handler := func(ctx context.Context, req any) (any, error) {
+		// the handler modifies its request while the candidate runs
+		req.(*wrapperspb.StringValue).Value = "modified"
+		close(modified)
+		return "ok", nil
+	}
+	if _, err := interceptor(testContext(), wrapperspb.String("original"), &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}, handler); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-results:
+		t.Fatalf("expected the candidate to see the original request, got %v", r.CandidateErr)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWithShadowTimeout(t *testing.T) {
+	slow := authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+		<-ctx.Done()
+		return true, nil
+	})
+	results := make(chan *authorizer.ShadowResult, 1)
+	interceptor := authorizer.UnaryServerInterceptor(matchSecondRule,
+		authorizer.WithShadowAuthorizer(slow, func(ctx context.Context, r *authorizer.ShadowResult) {
+			results <- r
+		}),
+		authorizer.WithShadowTimeout(50*time.Millisecond),
+	)
+	start := time.Now()
+	if _, err := interceptor(testContext(), "request", &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}, testHandler); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 40*time.Millisecond {
+		t.Fatal("expected the shadow authorizer not to delay the response")
+	}
+	select {
+	case r := <-results:
+		if r.CandidateErr == nil {
+			t.Fatal("expected a timeout error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the timed out candidate to be reported")
+	}
+}
+
+func TestWithDryRun(t *testing.T) {
+	var decisions []*authorizer.Decision
+	hook := authorizer.WithDecisionHook(func(ctx context.Context, d *authorizer.Decision) {
+		decisions = append(decisions, d)
+	})
+	deny := authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+		return false, nil
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}
+
+	interceptor := authorizer.UnaryServerInterceptor(deny, authorizer.WithDryRun(), hook)
+	if _, err := interceptor(testContext(), "request", info, testHandler); err != nil {
+		t.Fatalf("expected dry run to allow the request, got %v", err)
+	}
+	if len(decisions) != 1 || decisions[0].Allowed || !decisions[0].DryRun {
+		t.Fatalf("expected the hook to see the real decision, got %+v", decisions[0])
+	}
+
+	interceptor = authorizer.UnaryServerInterceptor(deny, authorizer.WithDryRun(), authorizer.WithUserExtractor(func(ctx context.Context) (any, error) {
+		return nil, status.Error(codes.Unauthenticated, "no token")
+	}))
+	if _, err := interceptor(testContext(), "request", info, testHandler); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected user extractor errors to be returned in dry run mode, got %v", err)
+	}
+}
+
+func TestWithErrorHandler(t *testing.T) {
+	deny := authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+		return false, nil
+	})
+	info := &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}
+
+	_, err := authorizer.UnaryServerInterceptor(deny)(testContext(), "request", info, testHandler)
+	st := status.Convert(err)
+	if st.Code() != codes.PermissionDenied {
+		t.Fatalf("expected permission denied, got %v", st.Code())
+	}
+	if len(st.Details()) != 1 {
+		t.Fatalf("expected 1 error detail, got %d", len(st.Details()))
+	}
+	info1, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	if !ok {
+		t.Fatalf("expected ErrorInfo detail, got %T", st.Details()[0])
+	}
+	if info1.Reason != authorizer.ReasonPermissionDenied || info1.Domain != authorizer.ErrorDomain || info1.Metadata["method"] != info.FullMethod {
+		t.Fatalf("unexpected error info: %v", info1)
+	}
+
+	interceptor := authorizer.UnaryServerInterceptor(deny,
+		authorizer.WithUserExtractor(func(ctx context.Context) (any, error) {
+			return &testUser{Id: "u1"}, nil
+		}),
+		authorizer.WithErrorHandler(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) error {
+			return authorizer.Error(codes.PermissionDenied, "MISSING_ROLE", "admin role required", map[string]string{
+				"user": params.User.(*testUser).Id,
+			})
+		}),
+	)
+	_, err = interceptor(testContext(), "request", info, testHandler)
+	st = status.Convert(err)
+	if st.Message() != "admin role required" {
+		t.Fatalf("unexpected message: %s", st.Message())
+	}
+	if info2 := st.Details()[0].(*errdetails.ErrorInfo); info2.Reason != "MISSING_ROLE" || info2.Metadata["user"] != "u1" {
+		t.Fatalf("unexpected error info: %v", info2)
+	}
+
+	// the error handler sees the rule that was evaluated last
+	denyBoth := authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+		authorizer.RecordRuleEvaluated(ctx)
+		authorizer.RecordRuleEvaluated(ctx)
+		return false, nil
+	})
+	messages := []string{"owner required", "admin role required"}
+	interceptor = authorizer.UnaryServerInterceptor(denyBoth,
+		authorizer.WithUserExtractor(func(ctx context.Context) (any, error) {
+			return &testUser{Id: "u1"}, nil
+		}),
+		authorizer.WithErrorHandler(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) error {
+			eval, ok := authorizer.EvaluationFromContext(ctx)
+			if !ok || eval.LastRule() < 0 {
+				return authorizer.DefaultErrorHandler(ctx, method, params)
+			}
+			return authorizer.PermissionDenied(method, messages[eval.LastRule()])
+		}),
+	)
+	_, err = interceptor(testContext(), "request", info, testHandler)
+	if st := status.Convert(err); st.Message() != "admin role required" {
+		t.Fatalf("expected the message of the last evaluated rule, got %s", st.Message())
+	}
+}
+
+func TestDefaultUserExtractor(t *testing.T) {
+	if _, err := authorizer.DefaultUserExtractor(context.Background()); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated, got %v", err)
+	}
+	ctx := context.WithValue(context.Background(), authorizer.DefaultUserExtractorKey, &testUser{Id: "u1"})
+	usr, err := authorizer.DefaultUserExtractor(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usr.(*testUser).Id != "u1" {
+		t.Fatalf("unexpected user: %v", usr)
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}
+	type fixture struct {
+		name       string
+		authz      authorizer.Authorizer
+		extractor  authorizer.UserExtractor
+		expectCode codes.Code
+	}
+	fixtures := []fixture{
+		{
+			name:  "extractor error",
+			authz: matchSecondRule,
+			extractor: func(ctx context.Context) (any, error) {
+				return nil, errors.New("invalid token signature for key abc")
+			},
+			expectCode: codes.Unauthenticated,
+		},
+		{
+			name:  "extractor status error",
+			authz: matchSecondRule,
+			extractor: func(ctx context.Context) (any, error) {
+				return nil, status.Error(codes.Unavailable, "identity service unavailable")
+			},
+			expectCode: codes.Unavailable,
+		},
+		{
+			name: "authorizer error",
+			authz: authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+				return false, errors.New("authorizer: failed to parse expression: user.Roles.includes(")
+			}),
+			expectCode: codes.Internal,
+		},
+		{
+			name: "authorizer status error",
+			authz: authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+				return false, status.Error(codes.FailedPrecondition, "account suspended")
+			}),
+			expectCode: codes.FailedPrecondition,
+		},
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			var hookErr error
+			opts := []authorizer.Opt{
+				authorizer.WithDecisionHook(func(ctx context.Context, d *authorizer.Decision) {
+					hookErr = d.Err
+				}),
+			}
+			if f.extractor != nil {
+				opts = append(opts, authorizer.WithUserExtractor(f.extractor))
+			}
+			_, err := authorizer.UnaryServerInterceptor(f.authz, opts...)(testContext(), "request", info, testHandler)
+			if status.Code(err) != f.expectCode {
+				t.Fatalf("expected code %v, got %v", f.expectCode, status.Code(err))
+			}
+			if hookErr == nil {
+				t.Fatal("expected the original error to be reported to the hook")
+			}
+			if f.expectCode == codes.Unauthenticated || f.expectCode == codes.Internal {
+				if status.Convert(err).Message() == hookErr.Error() {
+					t.Fatalf("expected the error returned to the client to be sanitized, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestWithWhiteListMethods(t *testing.T) {
+	deny := authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+		return false, nil
+	})
+	interceptor := authorizer.UnaryServerInterceptor(deny,
+		// replaced by the next option
+		authorizer.WithWhiteListMethods([]string{"/example.Service/Private"}),
+		authorizer.WithWhiteListMethods([]string{"/example.Service/Public", "/my.pkg.*/*"}),
+		authorizer.AddWhiteListMethods(authorizer.HealthCheckMethods...),
+		authorizer.AddWhiteListMethods(authorizer.ReflectionMethods...),
+		authorizer.WithWhiteListRegexps(regexp.MustCompile(`^/example\.Service/Get[A-Z]\w*$`)),
+	)
+	methods := map[string]bool{
+		"/example.Service/Public":                                        true,
+		"/example.Service/Private":                                       false,
+		"/my.pkg.v1.Service/Method":                                      true,
+		"/my.pkg/Method":                                                 false,
+		"/other.pkg.v1.Service/Method":                                   false,
+		"/grpc.health.v1.Health/Check":                                   true,
+		"/grpc.health.v1.Health/Watch":                                   true,
+		"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo":      true,
+		"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": true,
+		"/example.Service/GetAccount":                                    true,
+		"/example.Service/Getaccount":                                    false,
+	}
+	for method, allowed := range methods {
+		_, err := interceptor(testContext(), "request", &grpc.UnaryServerInfo{FullMethod: method}, testHandler)
+		if (err == nil) != allowed {
+			t.Fatalf("expected %s allowed=%v, got %v", method, allowed, err)
+		}
+	}
+}
+
+func TestRuleExecutionParams_Peer(t *testing.T) {
+	var params *authorizer.RuleExecutionParams
+	interceptor := authorizer.UnaryServerInterceptor(authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, p *authorizer.RuleExecutionParams) (bool, error) {
+		params = p
+		return true, nil
+	}))
+	ctx := metadata.NewIncomingContext(testContext(), metadata.Pairs(":authority", "api.internal"))
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if _, err := interceptor(ctx, "request", &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}, testHandler); err != nil {
+		t.Fatal(err)
+	}
+	if params.Peer == nil || params.Peer.IP != "10.0.0.1" || params.Peer.Port != 1234 {
+		t.Fatalf("unexpected peer: %+v", params.Peer)
+	}
+	if params.Authority != "api.internal" {
+		t.Fatalf("unexpected authority: %s", params.Authority)
+	}
+	if remaining := params.DeadlineRemaining(); remaining <= 0 || remaining > time.Minute {
+		t.Fatalf("unexpected deadline remaining: %v", remaining)
+	}
+	peerMap := params.PeerMap()
+	if peerMap["address"] != "10.0.0.1:1234" || peerMap["tls"].(map[string]any)["verified"] != false {
+		t.Fatalf("unexpected peer map: %v", peerMap)
+	}
+}
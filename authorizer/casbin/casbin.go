@@ -0,0 +1,282 @@
+package casbin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+// DefaultModel is the RBAC model used if no model is configured. Roles are assigned and inherited with g policies,
+// objects are matched with keyMatch2 (/accounts/:id, /accounts/*) and the action "*" grants every action:
+//
+//	p, admin, accounts, *
+//	p, viewer, accounts, read
+//	g, alice, admin
+//	g, admin, viewer
+const DefaultModel = `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && keyMatch2(r.obj, p.obj) && (r.act == p.act || p.act == "*")
+`
+
+// SubjectFunc returns the subjects of a request. The request is allowed if any subject is allowed
+type SubjectFunc func(ctx context.Context, params *authorizer.RuleExecutionParams) ([]string, error)
+
+// SubjectFromField returns a SubjectFunc that reads the subjects from a dot separated field path of the user
+// (see authorizer.LookupField). If the field is a list every element is a subject - for example the roles of a user
+func SubjectFromField(path string) SubjectFunc {
+	return func(ctx context.Context, params *authorizer.RuleExecutionParams) ([]string, error) {
+		value, ok := authorizer.LookupField(params.User, path)
+		if !ok || value == nil {
+			return nil, nil
+		}
+		switch value := value.(type) {
+		case []string:
+			return value, nil
+		case []any:
+			subjects := make([]string, 0, len(value))
+			for _, v := range value {
+				subjects = append(subjects, fmt.Sprint(v))
+			}
+			return subjects, nil
+		default:
+			return []string{fmt.Sprint(value)}, nil
+		}
+	}
+}
+
+// DefaultSubject returns the "id" (or "sub") field of the user
+func DefaultSubject(ctx context.Context, params *authorizer.RuleExecutionParams) ([]string, error) {
+	for _, field := range []string{"id", "sub"} {
+		subjects, err := SubjectFromField(field)(ctx, params)
+		if err != nil || len(subjects) > 0 {
+			return subjects, err
+		}
+	}
+	return nil, nil
+}
+
+// Opt is a functional option for configuring a CasbinAuthorizer
+type Opt func(*CasbinAuthorizer)
+
+// WithModelFile loads the casbin model from a file (default DefaultModel)
+func WithModelFile(path string) Opt {
+	return func(a *CasbinAuthorizer) {
+		a.modelFile = path
+	}
+}
+
+// WithModelText sets the casbin model (default DefaultModel)
+func WithModelText(text string) Opt {
+	return func(a *CasbinAuthorizer) {
+		a.modelText = text
+	}
+}
+
+// WithPolicyFile loads the policies and role assignments from a casbin csv policy file
+func WithPolicyFile(path string) Opt {
+	return func(a *CasbinAuthorizer) {
+		a.policyFile = path
+	}
+}
+
+// WithEnforcer uses an existing enforcer (for example with a database adapter) instead of creating one from the model
+// and policy files
+func WithEnforcer(enforcer casbin.IEnforcer) Opt {
+	return func(a *CasbinAuthorizer) {
+		a.enforcer = enforcer
+	}
+}
+
+// WithSubject sets the function that returns the subjects of a request (default DefaultSubject)
+func WithSubject(fn SubjectFunc) Opt {
+	return func(a *CasbinAuthorizer) {
+		a.subject = fn
+	}
+}
+
+// WithTracerProvider enables tracing - a span named "authorize.evaluate" is created for every call to AuthorizeMethod
+func WithTracerProvider(tp trace.TracerProvider) Opt {
+	return func(a *CasbinAuthorizer) {
+		a.tracerProvider = tp
+	}
+}
+
+// CasbinAuthorizer authorizes grpc requests with a casbin enforcer. Every rule expression is a comma separated list of
+// the request values after the subject - with DefaultModel the object and action:
+//
+//	option (authorize.rules) = {
+//	  rules: [{expression: "accounts, read"}]
+//	};
+//
+// The request is allowed if the enforcer allows any subject of the user for any rule of the method
+type CasbinAuthorizer struct {
+	rules          map[string]*authorize.RuleSet
+	requests       map[string][]any
+	modelFile      string
+	modelText      string
+	policyFile     string
+	enforcer       casbin.IEnforcer
+	subject        SubjectFunc
+	tracerProvider trace.TracerProvider
+}
+
+// NewCasbinAuthorizer returns a new CasbinAuthorizer. The rules map is a map of method names to RuleSets. The RuleSets are used to
+// authorize the method. The RuleSets are evaluated in order and the first rule that evaluates to true will authorize
+// the request. The mapping can be generated with the protoc-gen-authorize plugin.
+// An error is returned if the model or policies can't be loaded or if a rule doesn't match the request definition
+func NewCasbinAuthorizer(rules map[string]*authorize.RuleSet, opts ...Opt) (*CasbinAuthorizer, error) {
+	a := &CasbinAuthorizer{
+		rules:    rules,
+		requests: map[string][]any{},
+		subject:  DefaultSubject,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.enforcer == nil {
+		enforcer, err := a.newEnforcer()
+		if err != nil {
+			return nil, err
+		}
+		a.enforcer = enforcer
+	}
+	var tokens int
+	if assertion, ok := a.enforcer.GetModel()["r"]["r"]; ok {
+		tokens = len(assertion.Tokens)
+	}
+	for method, ruleSet := range rules {
+		if len(ruleSet.GetRules()) == 1 && ruleSet.Rules[0].Expression == "*" {
+			continue
+		}
+		for _, rule := range ruleSet.GetRules() {
+			values := parseRule(rule.Expression)
+			if len(values)+1 != tokens {
+				return nil, fmt.Errorf("authorizer: rule %q of %s has %d values but the casbin request definition expects %d after the subject", rule.Expression, method, len(values), tokens-1)
+			}
+			a.requests[rule.Expression] = values
+		}
+	}
+	return a, nil
+}
+
+func (a *CasbinAuthorizer) newEnforcer() (*casbin.SyncedEnforcer, error) {
+	var (
+		m   model.Model
+		err error
+	)
+	switch {
+	case a.modelFile != "":
+		m, err = model.NewModelFromFile(a.modelFile)
+	case a.modelText != "":
+		m, err = model.NewModelFromString(a.modelText)
+	default:
+		m, err = model.NewModelFromString(DefaultModel)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("authorizer: failed to load casbin model: %v", err.Error())
+	}
+	var enforcer *casbin.SyncedEnforcer
+	if a.policyFile != "" {
+		enforcer, err = casbin.NewSyncedEnforcer(m, fileadapter.NewAdapter(a.policyFile))
+	} else {
+		enforcer, err = casbin.NewSyncedEnforcer(m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("authorizer: failed to create casbin enforcer: %v", err.Error())
+	}
+	return enforcer, nil
+}
+
+// parseRule splits a rule expression into the request values after the subject
+func parseRule(expression string) []any {
+	parts := strings.Split(expression, ",")
+	values := make([]any, len(parts))
+	for i, part := range parts {
+		values[i] = strings.TrimSpace(part)
+	}
+	return values
+}
+
+// Enforcer returns the casbin enforcer - for example to add policies or role assignments at runtime
+func (a *CasbinAuthorizer) Enforcer() casbin.IEnforcer {
+	return a.enforcer
+}
+
+// AuthorizeMethod authorizes a gRPC method the RuleExecutionParams and returns a boolean representing whether the
+// request is authorized or not.
+func (a *CasbinAuthorizer) AuthorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	if a.tracerProvider != nil {
+		return authorizer.TraceEvaluation(ctx, a.tracerProvider, "casbin", method, func(ctx context.Context) (bool, error) {
+			return a.authorizeMethod(ctx, method, params)
+		})
+	}
+	return a.authorizeMethod(ctx, method, params)
+}
+
+func (a *CasbinAuthorizer) authorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	authorizer.RecordBackend(ctx, "casbin")
+	// return false if no rules exist for the method
+	rules, ok := a.rules[method]
+	if !ok {
+		// malformed methods (not /service/method) are denied
+		parts := strings.Split(method, "/")
+		if len(parts) < 2 || parts[1] == "" {
+			return false, nil
+		}
+		svc := parts[1]
+		for k := range a.rules {
+			if strings.HasPrefix(k, "/"+svc) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	// allow all
+	if len(rules.Rules) == 1 && rules.Rules[0].Expression == "*" {
+		authorizer.RecordRuleMatched(ctx, 0)
+		return true, nil
+	}
+	subjects, err := a.subject(ctx, params)
+	if err != nil {
+		return false, fmt.Errorf("authorizer: failed to get subjects: %v", err.Error())
+	}
+	for i, rule := range rules.Rules {
+		authorizer.RecordRuleEvaluated(ctx)
+		values, ok := a.requests[rule.Expression]
+		if !ok {
+			return false, fmt.Errorf("authorizer: rule not parsed: %s", rule.Expression)
+		}
+		for _, subject := range subjects {
+			allowed, err := a.enforcer.Enforce(append([]any{subject}, values...)...)
+			if err != nil {
+				return false, fmt.Errorf("authorizer: failed to enforce casbin policy: %v", err.Error())
+			}
+			if allowed {
+				authorizer.RecordRuleMatched(ctx, i)
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
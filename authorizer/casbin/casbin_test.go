@@ -0,0 +1,105 @@
+package casbin_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/casbin"
+)
+
+const policies = `
+p, admin, accounts, *
+p, viewer, accounts, read
+p, viewer, /documents/:id, read
+g, alice, admin
+g, admin, viewer
+g, bob, viewer
+`
+
+var rules = map[string]*authorize.RuleSet{
+	"/example.Accounts/Get":       {Rules: []*authorize.Rule{{Expression: "accounts, read"}}},
+	"/example.Accounts/Delete":    {Rules: []*authorize.Rule{{Expression: "accounts, delete"}}},
+	"/example.Documents/Get":      {Rules: []*authorize.Rule{{Expression: "/documents/123, read"}}},
+	"/example.Documents/Update":   {Rules: []*authorize.Rule{{Expression: "/documents/123, write"}, {Expression: "accounts, write"}}},
+	"/example.Documents/Download": {Rules: []*authorize.Rule{{Expression: "*"}}},
+}
+
+type fixture struct {
+	name        string
+	method      string
+	user        any
+	expectAllow bool
+}
+
+func TestCasbinAuthorizer(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.csv")
+	if err := os.WriteFile(policyFile, []byte(policies), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	authz, err := casbin.NewCasbinAuthorizer(rules, casbin.WithPolicyFile(policyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures := []fixture{
+		{name: "direct role (allow)", method: "/example.Accounts/Get", user: map[string]any{"id": "bob"}, expectAllow: true},
+		{name: "direct role (deny)", method: "/example.Accounts/Delete", user: map[string]any{"id": "bob"}, expectAllow: false},
+		{name: "wildcard action (allow)", method: "/example.Accounts/Delete", user: map[string]any{"id": "alice"}, expectAllow: true},
+		{name: "inherited role (allow)", method: "/example.Documents/Get", user: map[string]any{"sub": "alice"}, expectAllow: true},
+		{name: "second rule (allow)", method: "/example.Documents/Update", user: map[string]any{"id": "alice"}, expectAllow: true},
+		{name: "second rule (deny)", method: "/example.Documents/Update", user: map[string]any{"id": "bob"}, expectAllow: false},
+		{name: "unknown user (deny)", method: "/example.Accounts/Get", user: map[string]any{"id": "mallory"}, expectAllow: false},
+		{name: "no user (deny)", method: "/example.Accounts/Get", user: nil, expectAllow: false},
+		{name: "allow all", method: "/example.Documents/Download", user: nil, expectAllow: true},
+		{name: "malformed method (deny)", method: "example.Documents", user: map[string]any{"id": "alice"}, expectAllow: false},
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			allow, err := authz.AuthorizeMethod(context.Background(), f.method, &authorizer.RuleExecutionParams{
+				User: f.user,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allow != f.expectAllow {
+				t.Fatalf("expected allow=%v, got %v", f.expectAllow, allow)
+			}
+		})
+	}
+}
+
+func TestCasbinAuthorizer_SubjectFromField(t *testing.T) {
+	type User struct {
+		Roles []string
+	}
+	authz, err := casbin.NewCasbinAuthorizer(rules, casbin.WithSubject(casbin.SubjectFromField("roles")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// role assignments can be managed at runtime
+	if _, err := authz.Enforcer().AddPolicy("editor", "accounts", "write"); err != nil {
+		t.Fatal(err)
+	}
+	allow, err := authz.AuthorizeMethod(context.Background(), "/example.Documents/Update", &authorizer.RuleExecutionParams{
+		User: &User{Roles: []string{"viewer", "editor"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allow {
+		t.Fatal("expected the editor role to be allowed")
+	}
+}
+
+func TestNewCasbinAuthorizer_InvalidRule(t *testing.T) {
+	_, err := casbin.NewCasbinAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Accounts/Get": {Rules: []*authorize.Rule{{Expression: "accounts"}}},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a rule without an action")
+	}
+}
@@ -0,0 +1,465 @@
+package cedar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cedar-policy/cedar-go"
+	"github.com/cedar-policy/cedar-go/ast"
+	xast "github.com/cedar-policy/cedar-go/x/exp/ast"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+// EntityFunc returns the principal or resource entity of a request
+type EntityFunc func(ctx context.Context, params *authorizer.RuleExecutionParams) (cedar.Entity, error)
+
+// Opt is a functional option for configuring a CedarAuthorizer
+type Opt func(*CedarAuthorizer)
+
+// WithPrincipal sets the function that returns the principal entity. By default the principal is User::"<id>" where
+// the id is the "id" (or "sub") field of the user and the attributes are the fields of the user
+func WithPrincipal(fn EntityFunc) Opt {
+	return func(a *CedarAuthorizer) {
+		a.principal = fn
+	}
+}
+
+// WithResource sets the function that returns the resource entity. By default the type of the resource is the full
+// name of the request message with "::" separators (example::v1::GetAccountRequest), the id is the "id" field of the
+// request and the attributes are the fields of the request
+func WithResource(fn EntityFunc) Opt {
+	return func(a *CedarAuthorizer) {
+		a.resource = fn
+	}
+}
+
+// WithEntities adds entities that are available to every request - for example groups and roles the principal is a
+// member of
+func WithEntities(entities cedar.EntityMap) Opt {
+	return func(a *CedarAuthorizer) {
+		for uid, entity := range entities {
+			a.entities[uid] = entity
+		}
+	}
+}
+
+// WithActionType sets the entity type of the actions (default "Action"). The action id is the full grpc method name
+func WithActionType(typ string) Opt {
+	return func(a *CedarAuthorizer) {
+		a.actionType = cedar.EntityType(typ)
+	}
+}
+
+// WithTracerProvider enables tracing - a span named "authorize.evaluate" is created for every call to AuthorizeMethod
+func WithTracerProvider(tp trace.TracerProvider) Opt {
+	return func(a *CedarAuthorizer) {
+		a.tracerProvider = tp
+	}
+}
+
+// CedarAuthorizer uses Cedar policies to authorize grpc requests. Every rule expression is one or more Cedar policies,
+// for example:
+//
+//	permit (principal, action, resource) when { principal.roles.contains("admin") };
+//	forbid (principal, action, resource) unless { context.metadata has "x-request-id" };
+//
+// The grpc method is the action (Action::"/example.Service/Method"), the user is the principal and the request is
+// the resource. The context contains metadata, is_stream, authority, peer, deadline_remaining (duration) and
+// now (datetime). As in Cedar, requests are denied unless a permit policy is satisfied and any satisfied forbid
+// policy denies the request. Policies that fail to evaluate are skipped, so users and requests with values that can't
+// be represented in Cedar (see Value) fail with an error instead of being evaluated without them
+type CedarAuthorizer struct {
+	rules          map[string]*authorize.RuleSet
+	policies       map[string]*cedar.PolicySet
+	ruleIndex      map[cedar.PolicyID]int
+	principal      EntityFunc
+	resource       EntityFunc
+	entities       cedar.EntityMap
+	actionType     cedar.EntityType
+	tracerProvider trace.TracerProvider
+}
+
+// NewCedarAuthorizer returns a new CedarAuthorizer. The rules map is a map of method names to RuleSets. The RuleSets are used to
+// authorize the method. The policies of all rules of a method are evaluated together as a Cedar policy set.
+// The mapping can be generated with the protoc-gen-authorize plugin.
+// The policies are parsed upfront - an error is returned if any of them is invalid
+func NewCedarAuthorizer(rules map[string]*authorize.RuleSet, opts ...Opt) (*CedarAuthorizer, error) {
+	a := &CedarAuthorizer{
+		rules:      rules,
+		policies:   map[string]*cedar.PolicySet{},
+		ruleIndex:  map[cedar.PolicyID]int{},
+		principal:  DefaultPrincipal,
+		resource:   DefaultResource,
+		entities:   cedar.EntityMap{},
+		actionType: "Action",
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	for method, ruleSet := range rules {
+		if len(ruleSet.GetRules()) == 1 && ruleSet.Rules[0].Expression == "*" {
+			continue
+		}
+		policySet := cedar.NewPolicySet()
+		for i, rule := range ruleSet.GetRules() {
+			parsed, err := parseRule(method, i, rule.Expression)
+			if err != nil {
+				return nil, err
+			}
+			for id, policy := range parsed {
+				policySet.Add(id, policy)
+				a.ruleIndex[id] = i
+			}
+		}
+		a.policies[method] = policySet
+	}
+	return a, nil
+}
+
+// parseRule parses the policies of a rule. The policies are identified by <method>#<rule index>.<policy index>
+func parseRule(method string, index int, expression string) (cedar.PolicyMap, error) {
+	list, err := cedar.NewPolicyListFromBytes(fmt.Sprintf("%s#%d", method, index), []byte(expression))
+	if err != nil {
+		return nil, fmt.Errorf("authorizer: failed to parse cedar policy for %s: %v", method, err.Error())
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("authorizer: no cedar policy in rule %d of %s", index, method)
+	}
+	policies := cedar.PolicyMap{}
+	for i, policy := range list {
+		policies[cedar.PolicyID(fmt.Sprintf("%s#%d.%d", method, index, i))] = policy
+	}
+	return policies, nil
+}
+
+// NewPolicySet returns the policies of all rules as one Cedar policy set - for example to analyze them with the Cedar
+// tooling. Policies without an action scope are scoped to the action of their method and every policy is annotated
+// with @id("<method>#<rule index>.<policy index>")
+func NewPolicySet(rules map[string]*authorize.RuleSet) (*cedar.PolicySet, error) {
+	methods := make([]string, 0, len(rules))
+	for method := range rules {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	policySet := cedar.NewPolicySet()
+	for _, method := range methods {
+		ruleSet := rules[method]
+		if len(ruleSet.GetRules()) == 1 && ruleSet.Rules[0].Expression == "*" {
+			policySet.Add(cedar.PolicyID(method+"#0.0"), cedar.NewPolicyFromAST(
+				ast.Permit().ActionEq(cedar.NewEntityUID("Action", cedar.String(method))).Annotate("id", cedar.String(method+"#0.0")),
+			))
+			continue
+		}
+		for i, rule := range ruleSet.GetRules() {
+			parsed, err := parseRule(method, i, rule.Expression)
+			if err != nil {
+				return nil, err
+			}
+			ids := make([]string, 0, len(parsed))
+			for id := range parsed {
+				ids = append(ids, string(id))
+			}
+			sort.Strings(ids)
+			for _, id := range ids {
+				// the policies were parsed for this policy set only, so their ast can be modified
+				policy := parsed[cedar.PolicyID(id)].AST()
+				if _, ok := (*xast.Policy)(policy).Action.(xast.ScopeTypeAll); ok {
+					policy = policy.ActionEq(cedar.NewEntityUID("Action", cedar.String(method)))
+				}
+				policy = policy.Annotate("id", cedar.String(id))
+				policySet.Add(cedar.PolicyID(id), cedar.NewPolicyFromAST(policy))
+			}
+		}
+	}
+	return policySet, nil
+}
+
+// AuthorizeMethod authorizes a gRPC method the RuleExecutionParams and returns a boolean representing whether the
+// request is authorized or not.
+func (a *CedarAuthorizer) AuthorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	if a.tracerProvider != nil {
+		return authorizer.TraceEvaluation(ctx, a.tracerProvider, "cedar", method, func(ctx context.Context) (bool, error) {
+			return a.authorizeMethod(ctx, method, params)
+		})
+	}
+	return a.authorizeMethod(ctx, method, params)
+}
+
+func (a *CedarAuthorizer) authorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	authorizer.RecordBackend(ctx, "cedar")
+	// return false if no rules exist for the method
+	rules, ok := a.rules[method]
+	if !ok {
+		// malformed methods (not /service/method) are denied
+		parts := strings.Split(method, "/")
+		if len(parts) < 2 || parts[1] == "" {
+			return false, nil
+		}
+		svc := parts[1]
+		for k := range a.rules {
+			if strings.HasPrefix(k, "/"+svc) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	// allow all
+	if len(rules.Rules) == 1 && rules.Rules[0].Expression == "*" {
+		authorizer.RecordRuleMatched(ctx, 0)
+		return true, nil
+	}
+	principal, err := a.principal(ctx, params)
+	if err != nil {
+		return false, fmt.Errorf("authorizer: failed to get principal: %v", err.Error())
+	}
+	resource, err := a.resource(ctx, params)
+	if err != nil {
+		return false, fmt.Errorf("authorizer: failed to get resource: %v", err.Error())
+	}
+	entities := make(cedar.EntityMap, len(a.entities)+2)
+	for uid, entity := range a.entities {
+		entities[uid] = entity
+	}
+	entities[principal.UID] = principal
+	entities[resource.UID] = resource
+	reqContext, err := a.context(params)
+	if err != nil {
+		return false, fmt.Errorf("authorizer: failed to get context: %v", err.Error())
+	}
+	for range rules.Rules {
+		authorizer.RecordRuleEvaluated(ctx)
+	}
+	decision, diagnostic := a.policies[method].IsAuthorized(entities, cedar.Request{
+		Principal: principal.UID,
+		Action:    cedar.NewEntityUID(a.actionType, cedar.String(method)),
+		Resource:  resource.UID,
+		Context:   reqContext,
+	})
+	if decision != cedar.Allow {
+		return false, nil
+	}
+	if len(diagnostic.Reasons) > 0 {
+		authorizer.RecordRuleMatched(ctx, a.ruleIndex[diagnostic.Reasons[0].PolicyID])
+	}
+	return true, nil
+}
+
+func (a *CedarAuthorizer) context(params *authorizer.RuleExecutionParams) (cedar.Record, error) {
+	metadata := cedar.RecordMap{}
+	for k, v := range params.Metadata {
+		metadata[cedar.String(k)] = cedar.String(strings.Join(v, ","))
+	}
+	peer, err := Value(params.PeerMap())
+	if err != nil {
+		return cedar.Record{}, err
+	}
+	return cedar.NewRecord(cedar.RecordMap{
+		cedar.String(authorizer.ExpressionVarMetadata):          cedar.NewRecord(metadata),
+		cedar.String(authorizer.ExpressionVarIsStream):          cedar.Boolean(params.IsStream),
+		cedar.String(authorizer.ExpressionVarAuthority):         cedar.String(params.Authority),
+		cedar.String(authorizer.ExpressionVarPeer):              peer,
+		cedar.String(authorizer.ExpressionVarDeadlineRemaining): cedar.NewDuration(params.DeadlineRemaining()),
+		cedar.String(authorizer.ExpressionVarNow):               cedar.NewDatetime(params.EvaluationTime()),
+	}), nil
+}
+
+// DefaultPrincipal returns User::"<id>" with the fields of the user as attributes. The id is the "id" or "sub"
+// field of the user
+func DefaultPrincipal(ctx context.Context, params *authorizer.RuleExecutionParams) (cedar.Entity, error) {
+	attrs, err := attributes(params.User)
+	if err != nil {
+		return cedar.Entity{}, err
+	}
+	id, ok := attrs.Get("id")
+	if !ok {
+		id, _ = attrs.Get("sub")
+	}
+	return cedar.Entity{
+		UID:        cedar.NewEntityUID("User", cedar.String(idString(id))),
+		Attributes: attrs,
+	}, nil
+}
+
+// DefaultResource returns the request as an entity. The type is the full name of the request message with "::"
+// separators, the id is the "id" field of the request and the attributes are the fields of the request
+func DefaultResource(ctx context.Context, params *authorizer.RuleExecutionParams) (cedar.Entity, error) {
+	typ := "Request"
+	if msg, ok := params.Request.(proto.Message); ok && msg.ProtoReflect().IsValid() {
+		typ = strings.ReplaceAll(string(msg.ProtoReflect().Descriptor().FullName()), ".", "::")
+	}
+	attrs, err := attributes(params.Request)
+	if err != nil {
+		return cedar.Entity{}, err
+	}
+	id, _ := attrs.Get("id")
+	return cedar.Entity{
+		UID:        cedar.NewEntityUID(cedar.EntityType(typ), cedar.String(idString(id))),
+		Attributes: attrs,
+	}, nil
+}
+
+func idString(v cedar.Value) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case cedar.String:
+		return string(v)
+	default:
+		return v.String()
+	}
+}
+
+// attributes converts a user or request to a Cedar record. Proto messages use their json field names
+func attributes(v any) (cedar.Record, error) {
+	var m map[string]any
+	switch v := v.(type) {
+	case nil:
+		return cedar.NewRecord(nil), nil
+	case map[string]any:
+		m = v
+	case proto.Message:
+		if !v.ProtoReflect().IsValid() {
+			return cedar.NewRecord(nil), nil
+		}
+		m = authorizer.ProtoToMap(v, authorizer.JSONFieldName)
+	default:
+		// other types are converted with their json encoding
+		bits, err := json.Marshal(v)
+		if err != nil {
+			return cedar.Record{}, err
+		}
+		if err := json.Unmarshal(bits, &m); err != nil {
+			return cedar.NewRecord(nil), nil
+		}
+	}
+	value, err := Value(m)
+	if err != nil {
+		return cedar.Record{}, err
+	}
+	return value.(cedar.Record), nil
+}
+
+// Value converts a Go value to a Cedar value. Maps become records, slices become sets, integers become longs, floats
+// become decimals, times become datetimes, durations become durations and structs are converted with their json
+// encoding. Nil values are returned as nil and omitted from records and sets. An error is returned for values that
+// can't be represented (unsigned integers above math.MaxInt64, floats out of the decimal range and unsupported types)
+// since omitting them would skip the policies that reference them - including forbid policies
+func Value(v any) (cedar.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case cedar.Value:
+		return v, nil
+	case string:
+		return cedar.String(v), nil
+	case bool:
+		return cedar.Boolean(v), nil
+	case time.Time:
+		return cedar.NewDatetime(v), nil
+	case time.Duration:
+		return cedar.NewDuration(v), nil
+	case []byte:
+		return cedar.String(v), nil
+	case map[string]any:
+		record := cedar.RecordMap{}
+		for k, e := range v {
+			value, err := Value(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			if value != nil {
+				record[cedar.String(k)] = value
+			}
+		}
+		return cedar.NewRecord(record), nil
+	case map[any]any:
+		record := cedar.RecordMap{}
+		for k, e := range v {
+			value, err := Value(e)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", k, err)
+			}
+			if value != nil {
+				record[cedar.String(fmt.Sprint(k))] = value
+			}
+		}
+		return cedar.NewRecord(record), nil
+	case []any:
+		var values []cedar.Value
+		for i, e := range v {
+			value, err := Value(e)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			if value != nil {
+				values = append(values, value)
+			}
+		}
+		return cedar.NewSet(values...), nil
+	}
+	// scalars are converted by their kind so that named types (for example enums) are supported
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return cedar.String(rv.String()), nil
+	case reflect.Bool:
+		return cedar.Boolean(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cedar.Long(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		// longs are signed 64 bit integers - larger values can't be represented
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows a cedar long", rv.Uint())
+		}
+		return cedar.Long(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		d, err := cedar.NewDecimalFromFloat(rv.Float())
+		if err != nil {
+			return nil, fmt.Errorf("%v can't be represented as a cedar decimal: %v", rv.Float(), err.Error())
+		}
+		return d, nil
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return Value(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		values := make([]any, rv.Len())
+		for i := range values {
+			values[i] = rv.Index(i).Interface()
+		}
+		return Value(values)
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", rv.Type().Key())
+		}
+		values := make(map[string]any, rv.Len())
+		for _, k := range rv.MapKeys() {
+			values[k.String()] = rv.MapIndex(k).Interface()
+		}
+		return Value(values)
+	case reflect.Struct:
+		// structs are converted with their json encoding
+		bits, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var decoded any
+		if err := json.Unmarshal(bits, &decoded); err != nil {
+			return nil, err
+		}
+		return Value(decoded)
+	}
+	return nil, fmt.Errorf("unsupported type %T", v)
+}
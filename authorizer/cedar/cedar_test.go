@@ -0,0 +1,287 @@
+package cedar_test
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	cedarpolicy "github.com/cedar-policy/cedar-go"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cedar"
+)
+
+type fixture struct {
+	name        string
+	method      string
+	rules       map[string]*authorize.RuleSet
+	opts        []cedar.Opt
+	params      *authorizer.RuleExecutionParams
+	expectError bool
+	expectAllow bool
+}
+
+const method = "/example.Service/Method"
+
+func ruleSet(expressions ...string) *authorize.RuleSet {
+	rs := &authorize.RuleSet{}
+	for _, expression := range expressions {
+		rs.Rules = append(rs.Rules, &authorize.Rule{Expression: expression})
+	}
+	return rs
+}
+
+var fixtures = []fixture{
+	{
+		name:   "principal attribute (allow)",
+		method: method,
+		rules: map[string]*authorize.RuleSet{
+			method: ruleSet(`permit (principal, action, resource) when { principal.roles.contains("admin") };`),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"id": "alice", "roles": []string{"admin"}},
+		},
+		expectAllow: true,
+	},
+	{
+		name:   "principal attribute (deny)",
+		method: method,
+		rules: map[string]*authorize.RuleSet{
+			method: ruleSet(`permit (principal, action, resource) when { principal.roles.contains("admin") };`),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"id": "bob", "roles": []string{"viewer"}},
+		},
+		expectAllow: false,
+	},
+	{
+		name:   "principal and action scope (allow)",
+		method: method,
+		rules: map[string]*authorize.RuleSet{
+			method: ruleSet(`permit (principal == User::"alice", action == Action::"/example.Service/Method", resource);`),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"sub": "alice"},
+		},
+		expectAllow: true,
+	},
+	{
+		name:   "forbid overrides permit (deny)",
+		method: method,
+		rules: map[string]*authorize.RuleSet{
+			method: ruleSet(
+				`permit (principal, action, resource);`,
+				`forbid (principal, action, resource) unless { context.metadata has "x-request-id" };`,
+			),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"id": "alice"},
+		},
+		expectAllow: false,
+	},
+	{
+		name:   "forbid not satisfied (allow)",
+		method: method,
+		rules: map[string]*authorize.RuleSet{
+			method: ruleSet(
+				`permit (principal, action, resource);`,
+				`forbid (principal, action, resource) unless { context.metadata has "x-request-id" };`,
+			),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User:     map[string]any{"id": "alice"},
+			Metadata: metadata.Pairs("x-request-id", "1"),
+		},
+		expectAllow: true,
+	},
+	{
+		name:   "group membership with entities (allow)",
+		method: method,
+		rules: map[string]*authorize.RuleSet{
+			method: ruleSet(`permit (principal in Group::"admins", action, resource);`),
+		},
+		opts: []cedar.Opt{
+			cedar.WithPrincipal(func(ctx context.Context, params *authorizer.RuleExecutionParams) (cedarpolicy.Entity, error) {
+				return cedarpolicy.Entity{
+					UID:     cedarpolicy.NewEntityUID("User", "alice"),
+					Parents: cedarpolicy.NewEntityUIDSet(cedarpolicy.NewEntityUID("Group", "admins")),
+				}, nil
+			}),
+			cedar.WithEntities(cedarpolicy.EntityMap{
+				cedarpolicy.NewEntityUID("Group", "admins"): {UID: cedarpolicy.NewEntityUID("Group", "admins")},
+			}),
+		},
+		params:      &authorizer.RuleExecutionParams{},
+		expectAllow: true,
+	},
+	{
+		name:   "proto resource (allow)",
+		method: "/envoy.service.auth.v3.Authorization/Check",
+		rules: map[string]*authorize.RuleSet{
+			"/envoy.service.auth.v3.Authorization/Check": ruleSet(
+				`permit (principal, action, resource is envoy::service::auth::v3::CheckRequest) when { resource.attributes.request.http.method == "GET" };`,
+			),
+		},
+		params: &authorizer.RuleExecutionParams{
+			Request: &authv3.CheckRequest{
+				Attributes: &authv3.AttributeContext{
+					Request: &authv3.AttributeContext_Request{
+						Http: &authv3.AttributeContext_HttpRequest{Method: "GET"},
+					},
+				},
+			},
+		},
+		expectAllow: true,
+	},
+	{
+		name:   "allow all",
+		method: method,
+		rules: map[string]*authorize.RuleSet{
+			method: ruleSet("*"),
+		},
+		params:      &authorizer.RuleExecutionParams{},
+		expectAllow: true,
+	},
+	{
+		name:   "malformed method (deny)",
+		method: "example.Service",
+		rules: map[string]*authorize.RuleSet{
+			method: ruleSet("*"),
+		},
+		params:      &authorizer.RuleExecutionParams{},
+		expectAllow: false,
+	},
+	{
+		// an omitted quota would make the forbid policy fail to evaluate and be skipped
+		name:   "unrepresentable attribute (error)",
+		method: method,
+		rules: map[string]*authorize.RuleSet{
+			method: ruleSet(
+				`permit (principal, action, resource);`,
+				`forbid (principal, action, resource) when { principal.quota > 100 };`,
+			),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"id": "alice", "quota": uint64(math.MaxInt64) + 1},
+		},
+		expectError: true,
+	},
+	{
+		name:   "small integer attributes (deny)",
+		method: method,
+		rules: map[string]*authorize.RuleSet{
+			method: ruleSet(
+				`permit (principal, action, resource);`,
+				`forbid (principal, action, resource) when { principal.quota > 100 || principal.level < 0 };`,
+			),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"id": "alice", "quota": uint16(200), "level": int8(1)},
+		},
+		expectAllow: false,
+	},
+	{
+		name:   "invalid policy",
+		method: method,
+		rules: map[string]*authorize.RuleSet{
+			method: ruleSet(`permit (principal, action, resource) when { principal.role == };`),
+		},
+		params:      &authorizer.RuleExecutionParams{},
+		expectError: true,
+	},
+}
+
+func TestCedarAuthorizer(t *testing.T) {
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			authz, err := cedar.NewCedarAuthorizer(f.rules, f.opts...)
+			if err != nil {
+				if f.expectError {
+					return
+				}
+				t.Fatal(err)
+			}
+			allow, err := authz.AuthorizeMethod(context.Background(), f.method, f.params)
+			if err != nil {
+				if f.expectError {
+					return
+				}
+				t.Fatal(err)
+			}
+			if f.expectError {
+				t.Fatal("expected an error")
+			}
+			if allow != f.expectAllow {
+				t.Fatalf("expected allow=%v, got %v", f.expectAllow, allow)
+			}
+		})
+	}
+}
+
+func TestValue(t *testing.T) {
+	value, err := cedar.Value(map[string]any{
+		"small": uint64(math.MaxInt64),
+		"uint":  uint(1),
+		"int8":  int8(-1),
+		"int16": int16(2),
+		"uint8": uint8(3),
+		"nil":   nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, ok := value.(cedarpolicy.Record)
+	if !ok {
+		t.Fatal("expected a record")
+	}
+	for key, expected := range map[string]cedarpolicy.Long{"small": math.MaxInt64, "uint": 1, "int8": -1, "int16": 2, "uint8": 3} {
+		if v, ok := record.Get(cedarpolicy.String(key)); !ok || v != expected {
+			t.Fatalf("expected %s to be %v, got %v", key, expected, v)
+		}
+	}
+	if v, ok := record.Get("nil"); ok {
+		t.Fatalf("expected nil to be omitted, got %v", v)
+	}
+	// values that can't be represented are errors instead of being omitted
+	for _, v := range []any{
+		map[string]any{"large": uint64(math.MaxInt64) + 1},
+		[]any{math.Inf(1)},
+		map[string]any{"func": func() {}},
+	} {
+		if _, err := cedar.Value(v); err == nil {
+			t.Fatalf("expected an error for %v", v)
+		}
+	}
+}
+
+func TestNewPolicySet(t *testing.T) {
+	policySet, err := cedar.NewPolicySet(map[string]*authorize.RuleSet{
+		method:                    ruleSet(`permit (principal, action, resource) when { principal.roles.contains("admin") };`),
+		"/example.Service/Public": ruleSet("*"),
+		"/example.Service/Scoped": ruleSet(`forbid (principal, action == Action::"/example.Service/Other", resource);`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(policySet.MarshalCedar())
+	for _, expected := range []string{
+		`@id("/example.Service/Method#0.0")`,
+		`action == Action::"/example.Service/Method"`,
+		`action == Action::"/example.Service/Public"`,
+		// explicit action scopes are kept
+		`action == Action::"/example.Service/Other"`,
+	} {
+		if !strings.Contains(text, expected) {
+			t.Fatalf("expected policy set to contain %s:\n%s", expected, text)
+		}
+	}
+	// the generated policy set can be parsed again
+	if _, err := cedarpolicy.NewPolicySetFromBytes("policies.cedar", []byte(text)); err != nil {
+		t.Fatal(err)
+	}
+}
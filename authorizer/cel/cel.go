@@ -10,6 +10,7 @@ import (
 
 	"github.com/google/cel-go/cel"
 	"github.com/mitchellh/mapstructure"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/autom8ter/proto/gen/authorize"
 
@@ -27,34 +28,116 @@ func WithMacros(macros ...cel.Macro) Opt {
 	}
 }
 
+// WithEnvOptions sets additional options (variables, functions, libraries...) for the cel environment
+func WithEnvOptions(opts ...cel.EnvOption) Opt {
+	return func(c *CelAuthorizer) {
+		c.envOptions = append(c.envOptions, opts...)
+	}
+}
+
+// WithFunctions sets additional functions (declared with cel.Function) that will be available to the cel vm.
+// Please note that the functions of Library are already included
+func WithFunctions(functions ...cel.EnvOption) Opt {
+	return WithEnvOptions(functions...)
+}
+
+// ProtoMode controls how protobuf requests and users are passed to expressions
+type ProtoMode int
+
+const (
+	// ProtoModeStruct decodes messages like any other struct into a map keyed by the Go field names
+	// (request.AccountId). It is the default for compatibility with existing rules
+	ProtoModeStruct ProtoMode = iota
+	// ProtoModeNative passes messages to CEL as native, type-checked proto values keyed by the proto field names
+	// (request.account_id). Timestamps and durations are CEL timestamps and durations, enums are ints and oneof
+	// members can be tested with has()
+	ProtoModeNative
+	// ProtoModeJSON converts messages to maps keyed by the json field names (request.accountId) with
+	// authorizer.ProtoToMap. Timestamps and durations are CEL timestamps and durations and enums are their names
+	ProtoModeJSON
+)
+
+// WithProtoMode sets how protobuf requests and users are passed to expressions (default ProtoModeStruct).
+// Values that are not protobuf messages are always decoded into a map with mapstructure
+func WithProtoMode(mode ProtoMode) Opt {
+	return func(c *CelAuthorizer) {
+		c.protoMode = mode
+	}
+}
+
+// WithResourceLoaders sets the registry of the loaders that rules select with a @resource(name) prefix. The loaded
+// resource is available to the rule as the dynamically typed "resource" variable. Rules whose resource fails to load
+// are skipped (they don't match)
+func WithResourceLoaders(loaders *authorizer.ResourceLoaders) Opt {
+	return func(c *CelAuthorizer) {
+		c.resourceLoaders = loaders
+	}
+}
+
+// WithTracerProvider enables tracing - a span named "authorize.evaluate" is created for every call to AuthorizeMethod
+func WithTracerProvider(tp trace.TracerProvider) Opt {
+	return func(c *CelAuthorizer) {
+		c.tracerProvider = tp
+	}
+}
+
 // CelAuthorizer is a Common Expression Language vm that uses CEL expressions to authorize grpc requests
 type CelAuthorizer struct {
-	rules          map[string]*authorize.RuleSet
-	cachedPrograms sync.Map
-	macros         []cel.Macro
+	rules           map[string]*authorize.RuleSet
+	cachedPrograms  sync.Map
+	macros          []cel.Macro
+	envOptions      []cel.EnvOption
+	env             *cel.Env
+	protoEnvs       sync.Map
+	protoMode       ProtoMode
+	resourceLoaders *authorizer.ResourceLoaders
+	tracerProvider  trace.TracerProvider
 }
 
+var mapType = cel.MapType(cel.StringType, cel.DynType)
+
 // NewCelAuthorizer returns a new CelAuthorizer. The rules map is a map of method names to RuleSets. The RuleSets are used to
 // authorize the method. The RuleSets are evaluated in order and the first rule that evaluates to true will authorize
 // the request. The mapping can be generated with the protoc-gen-authorize plugin.
 func NewCelAuthorizer(rules map[string]*authorize.RuleSet, opts ...Opt) (*CelAuthorizer, error) {
 	c := &CelAuthorizer{
-		rules:          rules,
-		cachedPrograms: sync.Map{},
+		rules:           rules,
+		cachedPrograms:  sync.Map{},
+		resourceLoaders: authorizer.NewResourceLoaders(),
 	}
 	for _, opt := range opts {
 		opt(c)
 	}
 	c.macros = append(c.macros, cel.StandardMacros...)
+	env, err := c.newEnv(mapType, mapType)
+	if err != nil {
+		return nil, err
+	}
+	c.env = env
 	return c, nil
 }
 
 // AuthorizeMethod authorizes a gRPC method the RuleExecutionParams and returns a boolean representing whether the
 // request is authorized or not.
-func (c *CelAuthorizer) AuthorizeMethod(_ context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+func (c *CelAuthorizer) AuthorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	if c.tracerProvider != nil {
+		return authorizer.TraceEvaluation(ctx, c.tracerProvider, "cel", method, func(ctx context.Context) (bool, error) {
+			return c.authorizeMethod(ctx, method, params)
+		})
+	}
+	return c.authorizeMethod(ctx, method, params)
+}
+
+func (c *CelAuthorizer) authorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	authorizer.RecordBackend(ctx, "cel")
 	rules, ok := c.rules[method]
 	if !ok {
-		svc := strings.Split(method, "/")[1]
+		// malformed methods (not /service/method) are denied
+		parts := strings.Split(method, "/")
+		if len(parts) < 2 || parts[1] == "" {
+			return false, nil
+		}
+		svc := parts[1]
 		for k := range c.rules {
 			if strings.HasPrefix(k, "/"+svc) {
 				return true, nil
@@ -65,21 +148,22 @@ func (c *CelAuthorizer) AuthorizeMethod(_ context.Context, method string, params
 
 	rules = proto.Clone(rules).(*authorize.RuleSet)
 	if len(rules.Rules) == 1 && rules.Rules[0].Expression == "*" {
+		authorizer.RecordRuleMatched(ctx, 0)
 		return true, nil
 	}
 
 	var (
 		metaMap = map[string]string{}
-		request = map[string]interface{}{}
-		user    = map[string]interface{}{}
 	)
 	for k, v := range params.Metadata {
 		metaMap[k] = strings.Join(v, ",")
 	}
-	if err := mapstructure.Decode(params.Request, &request); err != nil {
+	request, requestType, err := c.convert(params.Request)
+	if err != nil {
 		return false, fmt.Errorf("authorizer: failed to decode request: %v", err.Error())
 	}
-	if err := mapstructure.Decode(params.User, &user); err != nil {
+	user, userType, err := c.convert(params.User)
+	if err != nil {
 		return false, fmt.Errorf("authorizer: failed to decode user: %v", err.Error())
 	}
 
@@ -88,18 +172,42 @@ func (c *CelAuthorizer) AuthorizeMethod(_ context.Context, method string, params
 		"request": request,
 	}
 	preprocess(rules, data)
-	programs, err := c.getMethodPrograms(rules)
+	peer := params.PeerMap()
+	env, envKey, err := c.getEnv(request, requestType, user, userType)
+	if err != nil {
+		return false, err
+	}
+	programs, err := c.getMethodPrograms(env, envKey, rules)
 	if err != nil {
 		return false, err
 	}
 
-	for _, program := range programs {
+	resources := c.resourceLoaders.Resources(params)
+	for i, program := range programs {
+		authorizer.RecordRuleEvaluated(ctx)
+		var resource any
+		if name := c.resourceLoaders.LoaderName(method, rules.Rules[i].Expression); name != "" {
+			loaded, err := resources.Load(ctx, name)
+			if err != nil {
+				// a rule can't match without its resource - the other rules may still allow the request
+				continue
+			}
+			if resource, err = c.convertResource(loaded); err != nil {
+				return false, fmt.Errorf("authorizer: failed to decode resource: %v", err.Error())
+			}
+		}
 		v, _, err := program.Eval(map[string]interface{}{
-			string(authorizer.ExpressionVarMetadata): metaMap,
-			string(authorizer.ExpressionVarRequest):  request,
-			string(authorizer.ExpressionVarUser):     user,
-			string(authorizer.ExpressionVarIsStream): params.IsStream,
-			string(authorizer.ExpressionVarMethod):   method,
+			string(authorizer.ExpressionVarMetadata):          metaMap,
+			string(authorizer.ExpressionVarRequest):           request,
+			string(authorizer.ExpressionVarUser):              user,
+			string(authorizer.ExpressionVarIsStream):          params.IsStream,
+			string(authorizer.ExpressionVarMethod):            method,
+			string(authorizer.ExpressionVarPeer):              peer,
+			string(authorizer.ExpressionVarAuthority):         params.Authority,
+			string(authorizer.ExpressionVarDeadlineRemaining): params.DeadlineRemaining(),
+			string(authorizer.ExpressionVarNow):               params.EvaluationTime(),
+			string(authorizer.ExpressionVarResource):          resource,
+			contextVar:                                        contextValue{ctx: ctx},
 		})
 		if err != nil {
 			return false, fmt.Errorf("authorizer: failed to run expression: %v", err.Error())
@@ -109,12 +217,34 @@ func (c *CelAuthorizer) AuthorizeMethod(_ context.Context, method string, params
 			return false, fmt.Errorf("authorizer: expression did not return a boolean")
 		}
 		if pass {
+			authorizer.RecordRuleMatched(ctx, i)
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
+// Validate compiles the rule expressions of every method and returns the first error.
+// Expressions are type-checked with untyped (map) requests and users. Templated expressions (${...}) are rendered
+// for every request and can't be validated upfront. Resource loaders that are selected by a rule or bound to a method
+// must be registered
+func (c *CelAuthorizer) Validate() error {
+	for method, rules := range c.rules {
+		for _, rule := range rules.GetRules() {
+			if err := c.resourceLoaders.Validate(rule.Expression); err != nil {
+				return fmt.Errorf("%s: %w", method, err)
+			}
+			if rule.Expression == "*" || strings.Contains(rule.Expression, "${") {
+				continue
+			}
+			if _, err := compile(c.env, rule.Expression, true); err != nil {
+				return fmt.Errorf("%s: %w", method, err)
+			}
+		}
+	}
+	return c.resourceLoaders.Validate()
+}
+
 func preprocess(rules *authorize.RuleSet, data map[string]interface{}) {
 	for _, rule := range rules.Rules {
 		expr := rule.Expression
@@ -124,32 +254,132 @@ func preprocess(rules *authorize.RuleSet, data map[string]interface{}) {
 	}
 }
 
-func (c *CelAuthorizer) getMethodPrograms(rules *authorize.RuleSet) ([]cel.Program, error) {
+// newEnv returns a cel environment with the expression variables, the macros, the function library and the
+// configured options. Native proto messages are registered with cel.Types
+func (c *CelAuthorizer) newEnv(requestType, userType *cel.Type, messages ...proto.Message) (*cel.Env, error) {
+	opts := []cel.EnvOption{
+		cel.Variable(string(authorizer.ExpressionVarMetadata), cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable(string(authorizer.ExpressionVarRequest), requestType),
+		cel.Variable(string(authorizer.ExpressionVarUser), userType),
+		cel.Variable(string(authorizer.ExpressionVarIsStream), cel.BoolType),
+		cel.Variable(string(authorizer.ExpressionVarMethod), cel.StringType),
+		cel.Variable(string(authorizer.ExpressionVarPeer), cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable(string(authorizer.ExpressionVarAuthority), cel.StringType),
+		cel.Variable(string(authorizer.ExpressionVarDeadlineRemaining), cel.DurationType),
+		cel.Variable(string(authorizer.ExpressionVarNow), cel.TimestampType),
+		cel.Variable(string(authorizer.ExpressionVarResource), cel.DynType),
+		cel.Variable(contextVar, contextType),
+		cel.Macros(c.macros...),
+		Library(),
+	}
+	if len(messages) > 0 {
+		types := make([]any, 0, len(messages))
+		for _, msg := range messages {
+			types = append(types, msg)
+		}
+		opts = append(opts, cel.Types(types...))
+	}
+	env, err := cel.NewEnv(append(opts, c.envOptions...)...)
+	if err != nil {
+		return nil, fmt.Errorf("authorizer: failed to create cel env: %v", err.Error())
+	}
+	return env, nil
+}
+
+// convert converts a request or user to the value passed to the expressions according to the proto mode
+func (c *CelAuthorizer) convert(v any) (any, *cel.Type, error) {
+	if msg, ok := v.(proto.Message); ok && msg.ProtoReflect().IsValid() {
+		switch c.protoMode {
+		case ProtoModeNative:
+			return msg, cel.ObjectType(string(msg.ProtoReflect().Descriptor().FullName())), nil
+		case ProtoModeJSON:
+			return authorizer.ProtoToMap(msg, authorizer.JSONFieldName), mapType, nil
+		}
+	}
+	out := map[string]interface{}{}
+	if err := mapstructure.Decode(v, &out); err != nil {
+		return nil, nil, err
+	}
+	return out, mapType, nil
+}
+
+// convertResource converts a loaded resource to a map. Proto messages are keyed by their proto field names in
+// ProtoModeNative since the resource variable isn't type-checked. Nil resources are passed as null
+func (c *CelAuthorizer) convertResource(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if msg, ok := v.(proto.Message); ok && msg.ProtoReflect().IsValid() && c.protoMode == ProtoModeNative {
+		return authorizer.ProtoToMap(msg, authorizer.ProtoFieldName), nil
+	}
+	resource, _, err := c.convert(v)
+	return resource, err
+}
+
+// getEnv returns the cel environment for the request and user types. Environments declaring native proto types are
+// created once per type and cached - the key is used to cache their programs
+func (c *CelAuthorizer) getEnv(request any, requestType *cel.Type, user any, userType *cel.Type) (*cel.Env, string, error) {
+	var messages []proto.Message
+	for _, v := range []any{request, user} {
+		if msg, ok := v.(proto.Message); ok {
+			messages = append(messages, msg)
+		}
+	}
+	if len(messages) == 0 {
+		return c.env, "", nil
+	}
+	key := requestType.String() + "|" + userType.String()
+	if env, ok := c.protoEnvs.Load(key); ok {
+		return env.(*cel.Env), key, nil
+	}
+	env, err := c.newEnv(requestType, userType, messages...)
+	if err != nil {
+		return nil, "", err
+	}
+	c.protoEnvs.Store(key, env)
+	return env, key, nil
+}
+
+func (c *CelAuthorizer) getMethodPrograms(env *cel.Env, envKey string, rules *authorize.RuleSet) ([]cel.Program, error) {
 	var programs []cel.Program
 	for _, rule := range rules.Rules {
-		program, ok := c.cachedPrograms.Load(rule.Expression)
+		cacheKey := rule.Expression
+		if envKey != "" {
+			cacheKey = envKey + "\x00" + rule.Expression
+		}
+		program, ok := c.cachedPrograms.Load(cacheKey)
 		if !ok {
-			vm, err := cel.NewEnv(
-				cel.Variable(string(authorizer.ExpressionVarMetadata), cel.MapType(cel.StringType, cel.StringType)),
-				cel.Variable(string(authorizer.ExpressionVarRequest), cel.MapType(cel.StringType, cel.DynType)),
-				cel.Variable(string(authorizer.ExpressionVarUser), cel.MapType(cel.StringType, cel.DynType)),
-				cel.Variable(string(authorizer.ExpressionVarIsStream), cel.BoolType),
-				cel.Macros(c.macros...),
-			)
-			if err != nil {
-				return nil, fmt.Errorf("authorizer: failed to create cel env: %v", err.Error())
-			}
-			parsed, issues := vm.Parse(rule.Expression)
-			if issues != nil && issues.Err() != nil {
-				return nil, fmt.Errorf("authorizer: failed to parse expression: %v", issues.Err().Error())
-			}
-			program, err = vm.Program(parsed)
+			var err error
+			program, err = compile(env, rule.Expression, false)
 			if err != nil {
-				return nil, fmt.Errorf("authorizer: failed to compile expression: %v", err.Error())
+				return nil, err
 			}
-			c.cachedPrograms.Store(rule.Expression, program)
+			c.cachedPrograms.Store(cacheKey, program)
 		}
 		programs = append(programs, program.(cel.Program))
 	}
 	return programs, nil
 }
+
+// compile returns the program of a rule expression. Expressions are only parsed for evaluation - like earlier
+// versions, type errors are reported when the expression is evaluated. Validate also type-checks them
+func compile(env *cel.Env, expression string, check bool) (cel.Program, error) {
+	_, expression = authorizer.SplitResourceLoader(expression)
+	var (
+		ast    *cel.Ast
+		issues *cel.Issues
+	)
+	if check {
+		ast, issues = env.Compile(expression)
+	} else {
+		ast, issues = env.Parse(expression)
+	}
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("authorizer: failed to compile expression: %v", issues.Err().Error())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("authorizer: failed to compile expression: %v", err.Error())
+	}
+	return program, nil
+}
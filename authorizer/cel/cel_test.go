@@ -2,7 +2,13 @@ package cel_test
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
 	"github.com/autom8ter/proto/gen/authorize"
 
@@ -282,6 +288,44 @@ var fixtures = []fixture{
 		},
 		expectAllow: true,
 	},
+	{
+		name:   "peer, authority and time rule (allow)",
+		method: "testing",
+		params: &authorizer.RuleExecutionParams{
+			Peer:      &authorizer.Peer{Address: "10.0.0.1:1234", IP: "10.0.0.1", Port: 1234},
+			Authority: "api.internal",
+			Now:       time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+			Deadline:  time.Date(2024, 1, 2, 10, 0, 5, 0, time.UTC),
+		},
+		rules: map[string]*authorize.RuleSet{
+			"testing": {
+				Rules: []*authorize.Rule{
+					{
+						Expression: "peer.ip.startsWith('10.') && authority == 'api.internal' && now.getHours() >= 9 && now.getHours() < 17 && deadline_remaining > duration('1s') && method == 'testing'",
+					},
+				},
+			},
+		},
+		expectAllow: true,
+	},
+	{
+		name:   "peer, authority and time rule (deny)",
+		method: "testing",
+		params: &authorizer.RuleExecutionParams{
+			Authority: "api.internal",
+			Now:       time.Date(2024, 1, 2, 20, 0, 0, 0, time.UTC),
+		},
+		rules: map[string]*authorize.RuleSet{
+			"testing": {
+				Rules: []*authorize.Rule{
+					{
+						Expression: "!peer.tls.verified && (peer.ip.startsWith('10.') || (now.getHours() >= 9 && now.getHours() < 17) || deadline_remaining > duration('0s'))",
+					},
+				},
+			},
+		},
+		expectAllow: false,
+	},
 }
 
 func TestCelAuthorizer_AuthorizeMethod(t *testing.T) {
@@ -325,6 +369,50 @@ func TestCelAuthorizer_AuthorizeMethod(t *testing.T) {
 	}
 }
 
+func TestCelAuthorizer_WithTracerProvider(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Service/Method": {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "user.IsSuperUser",
+				},
+				{
+					Expression: "'admin' in user.Roles",
+				},
+			},
+		},
+	}, cel.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	allow, err := authz.AuthorizeMethod(context.Background(), "/example.Service/Method", &authorizer.RuleExecutionParams{
+		User: &User{
+			Roles: []string{"admin"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !allow {
+		t.Fatalf("expected allow")
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, a := range spans[0].Attributes {
+		attrs[a.Key] = a.Value
+	}
+	if attrs["authorize.backend"].AsString() != "cel" {
+		t.Fatalf("unexpected backend attribute: %v", attrs["authorize.backend"])
+	}
+	if attrs["authorize.rules_evaluated"].AsInt64() != 2 || attrs["authorize.matched_rule"].AsInt64() != 1 {
+		t.Fatalf("unexpected span attributes: %v", spans[0].Attributes)
+	}
+}
+
 /*
 BenchmarkCelAuthorizer_AuthorizeMethod
 BenchmarkCelAuthorizer_AuthorizeMethod/basic_request_field_rule_1_(allow)
@@ -391,3 +479,143 @@ func BenchmarkCelAuthorizer_AuthorizeMethod(b *testing.B) {
 		})
 	}
 }
+
+func TestCelAuthorizer_MalformedMethod(t *testing.T) {
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Orders/Get": {Rules: []*authorize.Rule{{Expression: "*"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"", "example.Orders", "/", "//Get"} {
+		allow, err := authz.AuthorizeMethod(context.Background(), method, &authorizer.RuleExecutionParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow {
+			t.Fatalf("%q: expected deny", method)
+		}
+	}
+}
+
+func TestCelAuthorizer_ParseOnly(t *testing.T) {
+	// rules are only parsed for evaluation, so expressions that don't type-check keep working like in earlier versions
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Orders/Get": {Rules: []*authorize.Rule{{Expression: "size(user.roles) == 2.0"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow, err := authz.AuthorizeMethod(context.Background(), "/example.Orders/Get", &authorizer.RuleExecutionParams{
+		User: map[string]any{"roles": []string{"admin", "billing"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allow {
+		t.Fatal("expected allow")
+	}
+	// Validate type-checks them
+	if err := authz.Validate(); err == nil {
+		t.Fatal("expected a type error")
+	}
+}
+
+func TestWithResourceLoaders(t *testing.T) {
+	orders := map[string]map[string]any{
+		"1": {"owner": "alice", "status": "open"},
+		"2": {"owner": "bob", "status": "closed"},
+	}
+	var calls int
+	loaders := authorizer.NewResourceLoaders().
+		Register("order", func(ctx context.Context, params *authorizer.RuleExecutionParams) (any, error) {
+			calls++
+			return orders[params.Request.(map[string]any)["id"].(string)], nil
+		}).
+		Bind("/example.Orders/Cancel", "order")
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Orders/Get": {Rules: []*authorize.Rule{
+			{Expression: "'admin' in user.roles"},
+			{Expression: "@resource(order) resource.owner == user.id"},
+		}},
+		"/example.Orders/Cancel": {Rules: []*authorize.Rule{
+			{Expression: "resource.status == 'open' && resource.owner == user.id"},
+			{Expression: "resource.status == 'closed' && 'admin' in user.roles"},
+		}},
+	}, cel.WithResourceLoaders(loaders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []struct {
+		method      string
+		user        string
+		roles       []string
+		order       string
+		expectAllow bool
+		expectCalls int
+	}{
+		{method: "/example.Orders/Get", user: "alice", order: "1", expectAllow: true, expectCalls: 1},
+		{method: "/example.Orders/Get", user: "alice", order: "2", expectAllow: false, expectCalls: 1},
+		// the resource isn't loaded if an earlier rule matches
+		{method: "/example.Orders/Get", user: "carol", roles: []string{"admin"}, order: "2", expectAllow: true, expectCalls: 0},
+		{method: "/example.Orders/Cancel", user: "alice", order: "1", expectAllow: true, expectCalls: 1},
+		// the resource is loaded once per request
+		{method: "/example.Orders/Cancel", user: "bob", order: "2", expectAllow: false, expectCalls: 1},
+	} {
+		calls = 0
+		allow, err := authz.AuthorizeMethod(context.Background(), f.method, &authorizer.RuleExecutionParams{
+			User:    map[string]any{"id": f.user, "roles": append([]string{}, f.roles...)},
+			Request: map[string]any{"id": f.order},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow != f.expectAllow {
+			t.Fatalf("%s %s %s: expected allow=%v, got %v", f.method, f.user, f.order, f.expectAllow, allow)
+		}
+		if calls != f.expectCalls {
+			t.Fatalf("%s %s %s: expected %d loader calls, got %d", f.method, f.user, f.order, f.expectCalls, calls)
+		}
+	}
+	// unknown loaders are rejected by Validate
+	authz, err = cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Orders/Get": {Rules: []*authorize.Rule{{Expression: "@resource(invoice) resource.owner == user.id"}}},
+	}, cel.WithResourceLoaders(loaders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authz.Validate(); err == nil {
+		t.Fatal("expected an error for an unknown resource loader")
+	}
+	// rules whose resource fails to load are skipped
+	loaders.Register("failing", func(ctx context.Context, params *authorizer.RuleExecutionParams) (any, error) {
+		return nil, errors.New("unavailable")
+	})
+	authz, err = cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Orders/Get": {Rules: []*authorize.Rule{
+			{Expression: "@resource(failing) resource.owner == user.id"},
+			{Expression: "'admin' in user.roles"},
+		}},
+	}, cel.WithResourceLoaders(loaders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []struct {
+		roles       []string
+		expectAllow bool
+	}{
+		{roles: nil, expectAllow: false},
+		{roles: []string{"admin"}, expectAllow: true},
+	} {
+		allow, err := authz.AuthorizeMethod(context.Background(), "/example.Orders/Get", &authorizer.RuleExecutionParams{
+			User:    map[string]any{"id": "alice", "roles": append([]string{}, f.roles...)},
+			Request: map[string]any{"id": "1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow != f.expectAllow {
+			t.Fatalf("%v: expected allow=%v, got %v", f.roles, f.expectAllow, allow)
+		}
+	}
+}
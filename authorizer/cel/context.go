@@ -0,0 +1,121 @@
+package cel
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// contextVar is the hidden variable that holds the context of the request being authorized. Expressions can't
+// reference it since identifiers can't start with @
+const contextVar = "@context"
+
+var contextType = cel.OpaqueType("authorizer.Context")
+
+// contextValue passes the request context through the cel runtime unchanged
+type contextValue struct {
+	ctx context.Context
+}
+
+// ConvertToNative implements ref.Val
+func (v contextValue) ConvertToNative(typeDesc reflect.Type) (any, error) {
+	if reflect.TypeOf(v.ctx).AssignableTo(typeDesc) {
+		return v.ctx, nil
+	}
+	return nil, fmt.Errorf("unsupported type conversion from context to %v", typeDesc)
+}
+
+// ConvertToType implements ref.Val
+func (v contextValue) ConvertToType(typeVal ref.Type) ref.Val {
+	if typeVal == types.TypeType {
+		return contextType
+	}
+	return types.NewErr("unsupported type conversion from context to %v", typeVal)
+}
+
+// Equal implements ref.Val
+func (v contextValue) Equal(other ref.Val) ref.Val {
+	o, ok := other.(contextValue)
+	return types.Bool(ok && o.ctx == v.ctx)
+}
+
+// Type implements ref.Val
+func (v contextValue) Type() ref.Type {
+	return contextType
+}
+
+// Value implements ref.Val
+func (v contextValue) Value() any {
+	return v.ctx
+}
+
+// ContextFunction declares a function whose binding receives the context of the request being authorized, so that
+// it is canceled with the request - for example a check against a remote service:
+//
+//	cel.WithFunctions(cel.ContextFunction("acl.check", "acl_check_string", []*celgo.Type{celgo.StringType}, celgo.BoolType,
+//		func(ctx context.Context, args ...ref.Val) ref.Val { ... }))
+//
+// Calls are rewritten by a macro that passes the context as a hidden last argument, so the function can't have other
+// overloads with the same number of arguments. The name may have a namespace (acl.check)
+func ContextFunction(name string, overload string, args []*cel.Type, result *cel.Type, fn func(ctx context.Context, args ...ref.Val) ref.Val) cel.EnvOption {
+	namespace, function := "", name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		namespace, function = name[:i], name[i+1:]
+	}
+	expand := func(eh cel.MacroExprHelper, target *exprpb.Expr, args []*exprpb.Expr) (*exprpb.Expr, *cel.Error) {
+		if namespace != "" && qualifiedName(target) != namespace {
+			// not a call of this function (for example a member function with the same name)
+			return nil, nil
+		}
+		return eh.GlobalCall(name, append(args, eh.Ident(contextVar))...), nil
+	}
+	macro := cel.NewGlobalMacro(function, len(args), expand)
+	if namespace != "" {
+		macro = cel.NewReceiverMacro(function, len(args), expand)
+	}
+	return cel.Lib(contextLibrary{
+		cel.Macros(macro),
+		cel.Function(name,
+			cel.Overload(overload, append(append([]*cel.Type{}, args...), contextType), result,
+				cel.FunctionBinding(func(values ...ref.Val) ref.Val {
+					ctx, ok := values[len(values)-1].(contextValue)
+					if !ok {
+						return types.NewErr("%s: missing request context", name)
+					}
+					return fn(ctx.ctx, values[:len(values)-1]...)
+				})),
+		),
+	})
+}
+
+// contextLibrary is the macro and function declaration of a ContextFunction
+type contextLibrary []cel.EnvOption
+
+// CompileOptions implements cel.Library
+func (l contextLibrary) CompileOptions() []cel.EnvOption {
+	return l
+}
+
+// ProgramOptions implements cel.Library
+func (l contextLibrary) ProgramOptions() []cel.ProgramOption {
+	return nil
+}
+
+// qualifiedName returns the dotted name of an identifier or a select expression (rebac.check -> rebac)
+func qualifiedName(e *exprpb.Expr) string {
+	switch {
+	case e.GetIdentExpr() != nil:
+		return e.GetIdentExpr().GetName()
+	case e.GetSelectExpr() != nil && !e.GetSelectExpr().GetTestOnly():
+		if operand := qualifiedName(e.GetSelectExpr().GetOperand()); operand != "" {
+			return operand + "." + e.GetSelectExpr().GetField()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,48 @@
+package cel_test
+
+import (
+	"context"
+	"testing"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+)
+
+type tenantKey struct{}
+
+func TestContextFunction(t *testing.T) {
+	// tenant.is(name) and isTenant(name) compare the name with the tenant of the request context
+	isTenant := func(ctx context.Context, args ...ref.Val) ref.Val {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return types.Bool(tenant == args[0].Value())
+	}
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Service/GetAccount": {Rules: []*authorize.Rule{{Expression: "tenant.is('acme') && isTenant('acme')"}}},
+		"/example.Service/Member":     {Rules: []*authorize.Rule{{Expression: "'acme'.is('acme')"}}},
+	}, cel.WithFunctions(
+		cel.ContextFunction("tenant.is", "tenant_is_string", []*celgo.Type{celgo.StringType}, celgo.BoolType, isTenant),
+		cel.ContextFunction("isTenant", "is_tenant_string", []*celgo.Type{celgo.StringType}, celgo.BoolType, isTenant),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authz.Validate(); err == nil {
+		t.Fatal("expected an error for a member call that isn't a call of tenant.is")
+	}
+	for tenant, expectAllow := range map[string]bool{"acme": true, "globex": false} {
+		ctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+		allow, err := authz.AuthorizeMethod(ctx, "/example.Service/GetAccount", &authorizer.RuleExecutionParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow != expectAllow {
+			t.Fatalf("%s: expected allow=%v, got %v", tenant, expectAllow, allow)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package cel
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	"golang.org/x/mod/semver"
+)
+
+// Library returns the authorization helper functions that are available to every CelAuthorizer expression:
+//
+//	ip.inCidr(cidr) / ip.inCidr([cidrs])   - the ip address is in one of the cidr ranges ('10.0.0.1'.inCidr('10.0.0.0/8'))
+//	str.glob(pattern)                      - path.Match glob matching (* doesn't match /)
+//	hasAny(list, list)                     - the first list contains at least one element of the second list
+//	hasAll(list, list)                     - the first list contains every element of the second list
+//	inTimeRange(ts, 'HH:MM', 'HH:MM'[, tz]) - the time of day of ts is in [start, end) - ranges may span midnight
+//	isWeekday(ts[, tz])                    - ts is monday to friday
+//	semverCompare(a, b)                    - -1, 0 or 1 ("v" prefixes are optional)
+//	version.semverAtLeast(min)             - version >= min
+//
+// Invalid cidrs, time ranges, timezones and versions result in evaluation errors
+func Library() cel.EnvOption {
+	return cel.Lib(library{})
+}
+
+type library struct{}
+
+// LibraryName implements cel.SingletonLibrary
+func (library) LibraryName() string {
+	return "protoc-gen-authorize"
+}
+
+// CompileOptions implements cel.Library
+func (library) CompileOptions() []cel.EnvOption {
+	listType := cel.ListType(cel.DynType)
+	return []cel.EnvOption{
+		cel.Function("inCidr",
+			cel.MemberOverload("string_in_cidr_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(inCidr)),
+			cel.MemberOverload("string_in_cidr_list", []*cel.Type{cel.StringType, cel.ListType(cel.StringType)}, cel.BoolType,
+				cel.BinaryBinding(inCidr)),
+		),
+		cel.Function("glob",
+			cel.MemberOverload("string_glob_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(glob)),
+		),
+		cel.Function("hasAny",
+			cel.Overload("has_any_list_list", []*cel.Type{listType, listType}, cel.BoolType,
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					return containsElements(lhs, rhs, true)
+				})),
+		),
+		cel.Function("hasAll",
+			cel.Overload("has_all_list_list", []*cel.Type{listType, listType}, cel.BoolType,
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					return containsElements(lhs, rhs, false)
+				})),
+		),
+		cel.Function("inTimeRange",
+			cel.Overload("in_time_range_timestamp_string_string", []*cel.Type{cel.TimestampType, cel.StringType, cel.StringType}, cel.BoolType,
+				cel.FunctionBinding(inTimeRange)),
+			cel.Overload("in_time_range_timestamp_string_string_string", []*cel.Type{cel.TimestampType, cel.StringType, cel.StringType, cel.StringType}, cel.BoolType,
+				cel.FunctionBinding(inTimeRange)),
+		),
+		cel.Function("isWeekday",
+			cel.Overload("is_weekday_timestamp", []*cel.Type{cel.TimestampType}, cel.BoolType,
+				cel.FunctionBinding(isWeekday)),
+			cel.Overload("is_weekday_timestamp_string", []*cel.Type{cel.TimestampType, cel.StringType}, cel.BoolType,
+				cel.FunctionBinding(isWeekday)),
+		),
+		cel.Function("semverCompare",
+			cel.Overload("semver_compare_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.IntType,
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					cmp, err := semverCompare(lhs, rhs)
+					if err != nil {
+						return types.NewErr("%v", err)
+					}
+					return types.Int(cmp)
+				})),
+		),
+		cel.Function("semverAtLeast",
+			cel.MemberOverload("string_semver_at_least_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+				cel.BinaryBinding(func(lhs, rhs ref.Val) ref.Val {
+					cmp, err := semverCompare(lhs, rhs)
+					if err != nil {
+						return types.NewErr("%v", err)
+					}
+					return types.Bool(cmp >= 0)
+				})),
+		),
+	}
+}
+
+// ProgramOptions implements cel.Library
+func (library) ProgramOptions() []cel.ProgramOption {
+	return nil
+}
+
+func inCidr(lhs, rhs ref.Val) ref.Val {
+	addr, err := netip.ParseAddr(string(lhs.(types.String)))
+	if err != nil {
+		// not an ip address (unknown peers have an empty ip)
+		return types.False
+	}
+	var cidrs []string
+	switch rhs := rhs.(type) {
+	case types.String:
+		cidrs = []string{string(rhs)}
+	case traits.Lister:
+		for it := rhs.Iterator(); it.HasNext() == types.True; {
+			s, ok := it.Next().(types.String)
+			if !ok {
+				return types.NewErr("inCidr: cidrs must be strings")
+			}
+			cidrs = append(cidrs, string(s))
+		}
+	}
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return types.NewErr("inCidr: invalid cidr %q", cidr)
+		}
+		if prefix.Contains(addr.Unmap()) {
+			return types.True
+		}
+	}
+	return types.False
+}
+
+func glob(lhs, rhs ref.Val) ref.Val {
+	match, err := path.Match(string(rhs.(types.String)), string(lhs.(types.String)))
+	if err != nil {
+		return types.NewErr("glob: invalid pattern %q", string(rhs.(types.String)))
+	}
+	return types.Bool(match)
+}
+
+// containsElements returns true if lhs contains any (or all) of the elements of rhs
+func containsElements(lhs, rhs ref.Val, anyOf bool) ref.Val {
+	list, ok := lhs.(traits.Lister)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(lhs)
+	}
+	elements, ok := rhs.(traits.Lister)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(rhs)
+	}
+	for it := elements.Iterator(); it.HasNext() == types.True; {
+		contains := list.Contains(it.Next())
+		if types.IsError(contains) {
+			return contains
+		}
+		if anyOf && contains == types.True {
+			return types.True
+		}
+		if !anyOf && contains != types.True {
+			return types.False
+		}
+	}
+	return types.Bool(!anyOf)
+}
+
+// localTime converts the timestamp argument to the optional timezone argument
+func localTime(args []ref.Val, tzIndex int) (time.Time, error) {
+	t := args[0].(types.Timestamp).Time
+	if len(args) > tzIndex {
+		loc, err := time.LoadLocation(string(args[tzIndex].(types.String)))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid timezone %q", string(args[tzIndex].(types.String)))
+		}
+		t = t.In(loc)
+	}
+	return t, nil
+}
+
+func inTimeRange(args ...ref.Val) ref.Val {
+	t, err := localTime(args, 3)
+	if err != nil {
+		return types.NewErr("inTimeRange: %v", err)
+	}
+	start, err := minuteOfDay(string(args[1].(types.String)))
+	if err != nil {
+		return types.NewErr("inTimeRange: %v", err)
+	}
+	end, err := minuteOfDay(string(args[2].(types.String)))
+	if err != nil {
+		return types.NewErr("inTimeRange: %v", err)
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return types.Bool(minute >= start && minute < end)
+	}
+	// the range spans midnight
+	return types.Bool(minute >= start || minute < end)
+}
+
+func minuteOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func isWeekday(args ...ref.Val) ref.Val {
+	t, err := localTime(args, 1)
+	if err != nil {
+		return types.NewErr("isWeekday: %v", err)
+	}
+	return types.Bool(t.Weekday() != time.Saturday && t.Weekday() != time.Sunday)
+}
+
+func semverCompare(lhs, rhs ref.Val) (int, error) {
+	a, b := canonicalVersion(string(lhs.(types.String))), canonicalVersion(string(rhs.(types.String)))
+	if !semver.IsValid(a) {
+		return 0, fmt.Errorf("semver: invalid version %q", string(lhs.(types.String)))
+	}
+	if !semver.IsValid(b) {
+		return 0, fmt.Errorf("semver: invalid version %q", string(rhs.(types.String)))
+	}
+	return semver.Compare(a, b), nil
+}
+
+func canonicalVersion(v string) string {
+	if !strings.HasPrefix(v, "v") {
+		return "v" + v
+	}
+	return v
+}
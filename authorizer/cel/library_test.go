@@ -0,0 +1,115 @@
+package cel_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	celgo "github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+)
+
+func TestLibrary(t *testing.T) {
+	type libraryFixture struct {
+		expression  string
+		expectAllow bool
+		expectError bool
+	}
+	fixtures := []libraryFixture{
+		{expression: "peer.ip.inCidr('10.0.0.0/8')", expectAllow: true},
+		{expression: "peer.ip.inCidr('192.168.0.0/16')", expectAllow: false},
+		{expression: "peer.ip.inCidr(['192.168.0.0/16', '10.0.0.0/24'])", expectAllow: true},
+		{expression: "'::ffff:10.0.0.1'.inCidr('10.0.0.0/8')", expectAllow: true},
+		{expression: "''.inCidr('10.0.0.0/8')", expectAllow: false},
+		{expression: "peer.ip.inCidr('10.0.0.0/33')", expectError: true},
+		{expression: "method.glob('/example.*/Get*')", expectAllow: true},
+		{expression: "method.glob('/example.*/Delete*')", expectAllow: false},
+		{expression: "method.glob('[')", expectError: true},
+		{expression: "hasAny(user.roles, ['admin', 'owner'])", expectAllow: true},
+		{expression: "hasAny(user.roles, ['owner'])", expectAllow: false},
+		{expression: "hasAll(user.roles, ['admin', 'billing'])", expectAllow: true},
+		{expression: "hasAll(user.roles, ['admin', 'owner'])", expectAllow: false},
+		{expression: "hasAll(user.roles, [])", expectAllow: true},
+		// now is Tuesday 2024-01-02 16:30 UTC (17:30 in Berlin)
+		{expression: "inTimeRange(now, '09:00', '17:00')", expectAllow: true},
+		{expression: "inTimeRange(now, '09:00', '17:00', 'Europe/Berlin')", expectAllow: false},
+		{expression: "inTimeRange(now, '22:00', '17:00')", expectAllow: true},
+		{expression: "inTimeRange(now, '9am', '5pm')", expectError: true},
+		{expression: "isWeekday(now)", expectAllow: true},
+		{expression: "isWeekday(now + duration('96h'), 'America/New_York')", expectAllow: false},
+		{expression: "isWeekday(now, 'Mars/Olympus_Mons')", expectError: true},
+		{expression: "semverCompare(metadata['x-client-version'], '1.10.0') == 1", expectAllow: true},
+		{expression: "metadata['x-client-version'].semverAtLeast('v1.2.0')", expectAllow: true},
+		{expression: "metadata['x-client-version'].semverAtLeast('1.11')", expectAllow: false},
+		{expression: "'latest'.semverAtLeast('1.0.0')", expectError: true},
+		// unknown overloads are reported when the expression is evaluated
+		{expression: "hasAny('admin', ['admin'])", expectError: true},
+		{expression: "isWeekday('monday')", expectError: true},
+	}
+	params := &authorizer.RuleExecutionParams{
+		User: map[string]any{
+			"roles": []string{"admin", "billing"},
+		},
+		Metadata: map[string][]string{
+			"x-client-version": {"1.10.2"},
+		},
+		Peer: &authorizer.Peer{Address: "10.0.0.1:1234", IP: "10.0.0.1", Port: 1234},
+		Now:  time.Date(2024, 1, 2, 16, 30, 0, 0, time.UTC),
+	}
+	for _, f := range fixtures {
+		t.Run(f.expression, func(t *testing.T) {
+			authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+				"/example.Service/GetAccount": {
+					Rules: []*authorize.Rule{{Expression: f.expression}},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			allow, err := authz.AuthorizeMethod(context.Background(), "/example.Service/GetAccount", params)
+			if f.expectError {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if allow != f.expectAllow {
+				t.Fatalf("expected allow=%v, got %v", f.expectAllow, allow)
+			}
+		})
+	}
+}
+
+func TestWithFunctions(t *testing.T) {
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Service/GetAccount": {
+			Rules: []*authorize.Rule{{Expression: "isEven(size(user.roles))"}},
+		},
+	}, cel.WithFunctions(celgo.Function("isEven",
+		celgo.Overload("is_even_int", []*celgo.Type{celgo.IntType}, celgo.BoolType,
+			celgo.UnaryBinding(func(v ref.Val) ref.Val {
+				return types.Bool(v.(types.Int)%2 == 0)
+			})),
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow, err := authz.AuthorizeMethod(context.Background(), "/example.Service/GetAccount", &authorizer.RuleExecutionParams{
+		User: map[string]any{"roles": []string{"admin", "billing"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allow {
+		t.Fatal("expected allow")
+	}
+}
@@ -0,0 +1,152 @@
+package cel_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+)
+
+// protoRequest has a timestamp, a oneof, an enum, maps of strings and messages and a struct
+func protoRequest(t *testing.T) *authv3.CheckRequest {
+	attrs, err := structpb.NewStruct(map[string]any{"tier": "gold", "limit": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Address: &corev3.Address{
+					Address: &corev3.Address_SocketAddress{
+						SocketAddress: &corev3.SocketAddress{
+							Protocol:      corev3.SocketAddress_UDP,
+							Address:       "10.0.0.1",
+							PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 1234},
+						},
+					},
+				},
+			},
+			Request: &authv3.AttributeContext_Request{
+				Time: timestamppb.New(time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)),
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{"x-account-id": "123"},
+				},
+			},
+			MetadataContext: &corev3.Metadata{
+				FilterMetadata: map[string]*structpb.Struct{"accounts": attrs},
+			},
+		},
+	}
+}
+
+func TestWithProtoMode(t *testing.T) {
+	type protoFixture struct {
+		name        string
+		mode        cel.ProtoMode
+		expression  string
+		expectAllow bool
+		expectError bool
+	}
+	fixtures := []protoFixture{
+		{
+			name:        "native timestamp",
+			mode:        cel.ProtoModeNative,
+			expression:  "request.attributes.request.time < now && request.attributes.request.time.getHours() == 10",
+			expectAllow: true,
+		},
+		{
+			name:        "native oneof",
+			mode:        cel.ProtoModeNative,
+			expression:  "has(request.attributes.source.address.socket_address) && !has(request.attributes.source.address.pipe) && request.attributes.source.address.socket_address.port_value == 1234u",
+			expectAllow: true,
+		},
+		{
+			name:        "native enum",
+			mode:        cel.ProtoModeNative,
+			expression:  "request.attributes.source.address.socket_address.protocol == 1",
+			expectAllow: true,
+		},
+		{
+			name:        "native maps and struct",
+			mode:        cel.ProtoModeNative,
+			expression:  "request.attributes.request.http.headers['x-account-id'] == '123' && request.attributes.metadata_context.filter_metadata['accounts'].tier == 'gold'",
+			expectAllow: true,
+		},
+		{
+			name:        "native unknown field is a type error",
+			mode:        cel.ProtoModeNative,
+			expression:  "request.attributes.unknown == 'x'",
+			expectError: true,
+		},
+		{
+			name:        "json timestamp",
+			mode:        cel.ProtoModeJSON,
+			expression:  "request.attributes.request.time == timestamp('2024-01-02T10:00:00Z')",
+			expectAllow: true,
+		},
+		{
+			name:        "json oneof",
+			mode:        cel.ProtoModeJSON,
+			expression:  "'socketAddress' in request.attributes.source.address && !('pipe' in request.attributes.source.address) && request.attributes.source.address.socketAddress.portValue == 1234u",
+			expectAllow: true,
+		},
+		{
+			name:        "json enum",
+			mode:        cel.ProtoModeJSON,
+			expression:  "request.attributes.source.address.socketAddress.protocol == 'UDP'",
+			expectAllow: true,
+		},
+		{
+			name:        "json maps and struct",
+			mode:        cel.ProtoModeJSON,
+			expression:  "request.attributes.request.http.headers['x-account-id'] == '123' && request.attributes.metadataContext.filterMetadata['accounts'].limit == 10.0",
+			expectAllow: true,
+		},
+		{
+			name:        "struct mode keeps go field names",
+			mode:        cel.ProtoModeStruct,
+			expression:  "'Attributes' in request",
+			expectAllow: true,
+		},
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+				"/example.Service/Method": {
+					Rules: []*authorize.Rule{{Expression: f.expression}},
+				},
+			}, cel.WithProtoMode(f.mode))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// evaluate twice to exercise the cached environment and programs
+			for i := 0; i < 2; i++ {
+				allow, err := authz.AuthorizeMethod(context.Background(), "/example.Service/Method", &authorizer.RuleExecutionParams{
+					Request: protoRequest(t),
+					User:    map[string]any{"id": "alice"},
+				})
+				if f.expectError {
+					if err == nil {
+						t.Fatal("expected error")
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if allow != f.expectAllow {
+					t.Fatalf("expected allow=%v, got %v", f.expectAllow, allow)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package connectauth
+
+import (
+	"context"
+	"errors"
+
+	"connectrpc.com/connect"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/httpauth"
+)
+
+// Interceptor is a connect.Interceptor that uses an Authorizer to authorize unary and streaming connect handlers.
+// Connect procedures ("/package.Service/Method") match grpc full method names, so the maps generated by
+// protoc-gen-authorize work unchanged. Request headers are passed to the rules as metadata (lower-cased keys).
+// Client calls are not intercepted
+type Interceptor struct {
+	enforcer *authorizer.Enforcer
+}
+
+// NewInterceptor returns a new Interceptor that uses the given authorizer and interceptor options
+// (user extractor, whitelist, hooks...). The user extractor can read the headers with metadata.FromIncomingContext
+func NewInterceptor(authz authorizer.Authorizer, opts ...authorizer.Opt) *Interceptor {
+	return &Interceptor{
+		enforcer: authorizer.NewEnforcer(authz, opts...),
+	}
+}
+
+// WrapUnary implements connect.Interceptor
+func (i *Interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		if err := i.authorize(ctx, req.Spec().Procedure, req.Peer(), req.Header(), req.Any(), false); err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+// WrapStreamingClient implements connect.Interceptor
+func (i *Interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+// WrapStreamingHandler implements connect.Interceptor.
+// The request object in the expression evaluation is nil because messages are received after authorization
+func (i *Interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if err := i.authorize(ctx, conn.Spec().Procedure, conn.Peer(), conn.RequestHeader(), nil, true); err != nil {
+			return err
+		}
+		return next(ctx, conn)
+	}
+}
+
+func (i *Interceptor) authorize(ctx context.Context, procedure string, p connect.Peer, header map[string][]string, req any, isStream bool) error {
+	ctx = metadata.NewIncomingContext(ctx, httpauth.HeaderToMetadata(header))
+	if p.Addr != "" {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: peerAddr{protocol: p.Protocol, addr: p.Addr}})
+	}
+	if err := i.enforcer.Authorize(ctx, procedure, req, isStream); err != nil {
+		return ToConnectError(err)
+	}
+	return nil
+}
+
+// ToConnectError converts a grpc status error to a connect error with the same code, message and details
+func ToConnectError(err error) *connect.Error {
+	st := status.Convert(err)
+	cerr := connect.NewError(connect.Code(st.Code()), errors.New(st.Message()))
+	for _, d := range st.Details() {
+		msg, ok := d.(proto.Message)
+		if !ok {
+			continue
+		}
+		if detail, err := connect.NewErrorDetail(msg); err == nil {
+			cerr.AddDetail(detail)
+		}
+	}
+	return cerr
+}
+
+// peerAddr is a net.Addr for the peer address reported by connect
+type peerAddr struct {
+	protocol string
+	addr     string
+}
+
+func (a peerAddr) Network() string {
+	return a.protocol
+}
+
+func (a peerAddr) String() string {
+	return a.addr
+}
@@ -0,0 +1,124 @@
+package connectauth_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/connectauth"
+)
+
+func newServer(t *testing.T) *httptest.Server {
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		healthpb.Health_Check_FullMethodName: {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "request.Service == 'example' && 'admin' in user.roles",
+				},
+			},
+		},
+		healthpb.Health_Watch_FullMethodName: {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "is_stream && 'x-watch' in metadata && metadata['x-watch'] == 'true'",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	interceptor := connectauth.NewInterceptor(authz, authorizer.WithUserExtractor(func(ctx context.Context) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		return map[string]any{"roles": md.Get("x-roles")}, nil
+	}))
+	mux := http.NewServeMux()
+	mux.Handle(healthpb.Health_Check_FullMethodName, connect.NewUnaryHandler(
+		healthpb.Health_Check_FullMethodName,
+		func(ctx context.Context, req *connect.Request[healthpb.HealthCheckRequest]) (*connect.Response[healthpb.HealthCheckResponse], error) {
+			return connect.NewResponse(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}), nil
+		},
+		connect.WithInterceptors(interceptor),
+	))
+	mux.Handle(healthpb.Health_Watch_FullMethodName, connect.NewServerStreamHandler(
+		healthpb.Health_Watch_FullMethodName,
+		func(ctx context.Context, req *connect.Request[healthpb.HealthCheckRequest], stream *connect.ServerStream[healthpb.HealthCheckResponse]) error {
+			return stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+		},
+		connect.WithInterceptors(interceptor),
+	))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestInterceptor_WrapUnary(t *testing.T) {
+	srv := newServer(t)
+	client := connect.NewClient[healthpb.HealthCheckRequest, healthpb.HealthCheckResponse](
+		srv.Client(), srv.URL+healthpb.Health_Check_FullMethodName,
+	)
+
+	req := connect.NewRequest(&healthpb.HealthCheckRequest{Service: "example"})
+	req.Header().Set("X-Roles", "admin")
+	if _, err := client.CallUnary(context.Background(), req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req = connect.NewRequest(&healthpb.HealthCheckRequest{Service: "example"})
+	req.Header().Set("X-Roles", "guest")
+	_, err := client.CallUnary(context.Background(), req)
+	if connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+	var cerr *connect.Error
+	if !errors.As(err, &cerr) || len(cerr.Details()) != 1 {
+		t.Fatalf("expected an error detail, got %v", err)
+	}
+	detail, err := cerr.Details()[0].Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := detail.(*errdetails.ErrorInfo); !ok || info.Reason != authorizer.ReasonPermissionDenied {
+		t.Fatalf("unexpected error detail: %v", detail)
+	}
+}
+
+func TestInterceptor_WrapStreamingHandler(t *testing.T) {
+	srv := newServer(t)
+	client := connect.NewClient[healthpb.HealthCheckRequest, healthpb.HealthCheckResponse](
+		srv.Client(), srv.URL+healthpb.Health_Watch_FullMethodName,
+	)
+
+	req := connect.NewRequest(&healthpb.HealthCheckRequest{})
+	req.Header().Set("X-Watch", "true")
+	stream, err := client.CallServerStream(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stream.Receive() {
+		t.Fatalf("expected a message, got %v", stream.Err())
+	}
+	_ = stream.Close()
+
+	stream, err = client.CallServerStream(context.Background(), connect.NewRequest(&healthpb.HealthCheckRequest{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.Receive() {
+		t.Fatal("expected the stream to be denied")
+	}
+	if connect.CodeOf(stream.Err()) != connect.CodePermissionDenied {
+		t.Fatalf("expected permission denied, got %v", stream.Err())
+	}
+}
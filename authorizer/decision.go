@@ -0,0 +1,93 @@
+package authorizer
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Decision is the outcome of a single authorization check made by the interceptors
+type Decision struct {
+	// Method is the full grpc method name
+	Method string
+	// User is the user extracted from the context (nil if no user extractor is configured or extraction failed)
+	User any
+	// Request is the request object passed to the grpc handler (nil for streaming handlers)
+	Request any
+	// Metadata is the metadata passed to the grpc handler
+	Metadata metadata.MD
+	// IsStream is true if the grpc handler is a streaming handler
+	IsStream bool
+	// Backend is the name of the backend that evaluated the rules (empty if the authorizer does not report it)
+	Backend string
+	// Peer is the address of the client (empty if unknown)
+	Peer string
+	// Allowed is true if the request was authorized
+	Allowed bool
+	// DryRun is true if the interceptor is in dry run mode - the request is allowed regardless of the decision
+	DryRun bool
+	// MatchedRule is the index of the rule that authorized the request, -1 if no rule matched or the authorizer
+	// does not report matched rules
+	MatchedRule int
+	// Latency is the time it took to extract the user and evaluate the rules
+	Latency time.Duration
+	// Err is the error returned by the user extractor or the authorizer (if any)
+	Err error
+}
+
+// DecisionHook is a function that is called with the outcome of every authorization decision
+type DecisionHook func(ctx context.Context, d *Decision)
+
+// Evaluation records details about how an Authorizer reached its decision.
+// Authorizers report to it with RecordRuleEvaluated/RecordRuleMatched
+type Evaluation struct {
+	// Backend is the name of the backend that evaluated the rules (cel, javascript, match)
+	Backend string
+	// RulesEvaluated is the number of rules that were evaluated
+	RulesEvaluated int
+	// MatchedRule is the index of the rule that authorized the request, -1 if no rule matched
+	MatchedRule int
+}
+
+// LastRule returns the index of the rule that was evaluated last, -1 if no rule was evaluated. Backends evaluate the
+// rules of a method in order and stop at the first match, so for denied requests it is the last rule of the method
+// that was checked
+func (e *Evaluation) LastRule() int {
+	return e.RulesEvaluated - 1
+}
+
+type evaluationCtxKey struct{}
+
+// WithEvaluation returns a context carrying a new Evaluation that authorizers evaluating rules with the context will report to
+func WithEvaluation(ctx context.Context) (context.Context, *Evaluation) {
+	eval := &Evaluation{MatchedRule: -1}
+	return context.WithValue(ctx, evaluationCtxKey{}, eval), eval
+}
+
+// EvaluationFromContext returns the Evaluation carried by the context (if any)
+func EvaluationFromContext(ctx context.Context) (*Evaluation, bool) {
+	eval, ok := ctx.Value(evaluationCtxKey{}).(*Evaluation)
+	return eval, ok
+}
+
+// RecordBackend records the name of the backend evaluating the rules in the Evaluation carried by the context (if any)
+func RecordBackend(ctx context.Context, backend string) {
+	if eval, ok := EvaluationFromContext(ctx); ok {
+		eval.Backend = backend
+	}
+}
+
+// RecordRuleEvaluated records that a rule was evaluated in the Evaluation carried by the context (if any)
+func RecordRuleEvaluated(ctx context.Context) {
+	if eval, ok := EvaluationFromContext(ctx); ok {
+		eval.RulesEvaluated++
+	}
+}
+
+// RecordRuleMatched records the index of the rule that authorized the request in the Evaluation carried by the context (if any)
+func RecordRuleMatched(ctx context.Context, index int) {
+	if eval, ok := EvaluationFromContext(ctx); ok {
+		eval.MatchedRule = index
+	}
+}
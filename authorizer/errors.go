@@ -0,0 +1,94 @@
+package authorizer
+
+import (
+	"context"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain is the domain of the errdetails.ErrorInfo attached to errors returned by the interceptors
+const ErrorDomain = "authorizer"
+
+const (
+	// ReasonPermissionDenied is the errdetails.ErrorInfo reason of requests denied by the authorizer
+	ReasonPermissionDenied = "PERMISSION_DENIED"
+	// ReasonUnauthenticated is the errdetails.ErrorInfo reason of requests without a user
+	ReasonUnauthenticated = "UNAUTHENTICATED"
+	// ReasonInternal is the errdetails.ErrorInfo reason of requests whose rules failed to evaluate
+	ReasonInternal = "INTERNAL"
+)
+
+// ErrorHandler is a function that returns the error sent to the client when a request is denied. The Evaluation of
+// the decision is available with EvaluationFromContext - its LastRule is the index of the rule of the method that was
+// evaluated last (the last rule that denied the request), which can be mapped to a per-rule message:
+//
+//	authorizer.WithErrorHandler(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) error {
+//		if eval, ok := authorizer.EvaluationFromContext(ctx); ok && method == "/example.Service/Delete" && eval.LastRule() == 1 {
+//			return authorizer.Error(codes.PermissionDenied, "MISSING_ROLE", "admin role required", nil)
+//		}
+//		return authorizer.DefaultErrorHandler(ctx, method, params)
+//	})
+type ErrorHandler func(ctx context.Context, method string, params *RuleExecutionParams) error
+
+// WithErrorHandler sets the function that builds the error returned to the client when a request is denied.
+// By default DefaultErrorHandler is used
+func WithErrorHandler(handler ErrorHandler) Opt {
+	return func(o *options) {
+		o.errorHandler = handler
+	}
+}
+
+// DefaultErrorHandler returns a PermissionDenied error with an errdetails.ErrorInfo carrying the method
+func DefaultErrorHandler(_ context.Context, method string, _ *RuleExecutionParams) error {
+	return PermissionDenied(method, "")
+}
+
+// Error returns a grpc status error with the given code and message and an errdetails.ErrorInfo detail with the
+// given reason and metadata
+func Error(code codes.Code, reason string, message string, metadata map[string]string) error {
+	st, err := status.New(code, message).WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   ErrorDomain,
+		Metadata: metadata,
+	})
+	if err != nil {
+		return status.Error(code, message)
+	}
+	return st.Err()
+}
+
+// PermissionDenied returns a PermissionDenied error for the given method.
+// If message is empty, "authorizer: permission denied" is used
+func PermissionDenied(method string, message string) error {
+	if message == "" {
+		message = "authorizer: permission denied"
+	}
+	return Error(codes.PermissionDenied, ReasonPermissionDenied, message, map[string]string{
+		"method": method,
+	})
+}
+
+// Unauthenticated returns an Unauthenticated error.
+// If message is empty, "authorizer: unauthenticated" is used
+func Unauthenticated(message string) error {
+	if message == "" {
+		message = "authorizer: unauthenticated"
+	}
+	return Error(codes.Unauthenticated, ReasonUnauthenticated, message, nil)
+}
+
+// classifyError converts errors returned by the user extractor or the authorizer into errors that are safe to return
+// to clients. Errors that already carry a grpc status code are returned as-is. Other user extractor errors become
+// Unauthenticated and other authorizer errors (bad expressions, decode failures) become Internal - the original error
+// is only reported to the decision hook so expression details are not leaked to clients
+func classifyError(err error, extracting bool) error {
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Unknown {
+		return err
+	}
+	if extracting {
+		return Unauthenticated("")
+	}
+	return Error(codes.Internal, ReasonInternal, "authorizer: failed to evaluate authorization rules", nil)
+}
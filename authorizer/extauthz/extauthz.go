@@ -0,0 +1,200 @@
+package extauthz
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	rpcstatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/httpauth"
+)
+
+// Opt is a functional option for configuring a Server
+type Opt func(*Server)
+
+// WithAuthorizerOpts sets the interceptor options (user extractor, whitelist, hooks...) applied to checks
+func WithAuthorizerOpts(opts ...authorizer.Opt) Opt {
+	return func(s *Server) {
+		s.authzOpts = append(s.authzOpts, opts...)
+	}
+}
+
+// Server implements Envoy's envoy.service.auth.v3.Authorization service (ext_authz) with an Authorizer so that the
+// rules generated by protoc-gen-authorize can be enforced at the mesh edge.
+// The :path of grpc requests proxied through Envoy is the full grpc method name. Request headers are passed to the
+// rules as metadata. If Envoy is configured to send the request body (with_request_body with pack_as_bytes) and
+// the request type of the method is registered, the first message of the body is decoded into the request object
+type Server struct {
+	authv3.UnimplementedAuthorizationServer
+	authzOpts []authorizer.Opt
+	enforcer  *authorizer.Enforcer
+}
+
+// NewServer returns a new Server that uses the given authorizer
+func NewServer(authz authorizer.Authorizer, opts ...Opt) *Server {
+	s := &Server{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.enforcer = authorizer.NewEnforcer(authz, s.authzOpts...)
+	return s
+}
+
+// Register registers the server with a grpc server
+func (s *Server) Register(srv *grpc.Server) {
+	authv3.RegisterAuthorizationServer(srv, s)
+}
+
+// Check implements authv3.AuthorizationServer
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	httpReq := req.GetAttributes().GetRequest().GetHttp()
+	method, _, _ := strings.Cut(httpReq.GetPath(), "?")
+	md := metadata.MD{}
+	for k, v := range httpReq.GetHeaders() {
+		md.Append(strings.ToLower(k), v)
+	}
+	if !isGrpcMethod(method) {
+		return deniedResponse(authorizer.PermissionDenied(method, "authorizer: path is not a grpc method"), isGrpc(md)), nil
+	}
+	ctx = metadata.NewIncomingContext(ctx, md)
+	if addr := req.GetAttributes().GetSource().GetAddress().GetSocketAddress(); addr != nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: socketAddr{addr}})
+	}
+	var request any
+	if body := httpReq.GetRawBody(); len(body) > 0 {
+		if msg, ok := decodeGrpcMessage(method, body); ok {
+			request = msg
+		}
+	}
+	if err := s.enforcer.Authorize(ctx, method, request, false); err != nil {
+		return deniedResponse(err, isGrpc(md)), nil
+	}
+	return &authv3.CheckResponse{
+		Status: &rpcstatus.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{
+			OkResponse: &authv3.OkHttpResponse{},
+		},
+	}, nil
+}
+
+// isGrpcMethod returns true if the path is a full grpc method name (/package.Service/Method)
+func isGrpcMethod(path string) bool {
+	service, method, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return strings.HasPrefix(path, "/") && ok && service != "" && method != "" && !strings.Contains(method, "/")
+}
+
+func isGrpc(md metadata.MD) bool {
+	contentType := md.Get("content-type")
+	return len(contentType) > 0 && strings.HasPrefix(contentType[0], "application/grpc")
+}
+
+// deniedResponse converts an authorization error to a denied CheckResponse. grpc clients receive the status in the
+// grpc-status/grpc-message headers, other clients a json error body
+func deniedResponse(err error, grpcRequest bool) *authv3.CheckResponse {
+	st := status.Convert(err)
+	denied := &authv3.DeniedHttpResponse{
+		Status: &typev3.HttpStatus{Code: typev3.StatusCode(httpauth.HTTPStatusFromCode(st.Code()))},
+	}
+	if grpcRequest {
+		denied.Headers = []*corev3.HeaderValueOption{
+			{Header: &corev3.HeaderValue{Key: "grpc-status", Value: strconv.Itoa(int(st.Code()))}},
+			{Header: &corev3.HeaderValue{Key: "grpc-message", Value: st.Message()}},
+		}
+	} else {
+		body, _ := json.Marshal(map[string]any{
+			"code":    int(st.Code()),
+			"message": st.Message(),
+		})
+		denied.Body = string(body)
+		denied.Headers = []*corev3.HeaderValueOption{
+			{Header: &corev3.HeaderValue{Key: "content-type", Value: "application/json"}},
+		}
+	}
+	return &authv3.CheckResponse{
+		Status: st.Proto(),
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: denied,
+		},
+	}
+}
+
+// decodeGrpcMessage decodes the first length-prefixed message of a grpc request body into the request type of the method
+func decodeGrpcMessage(method string, body []byte) (proto.Message, bool) {
+	if len(body) < 5 || body[0] != 0 {
+		// too short or compressed
+		return nil, false
+	}
+	size := binary.BigEndian.Uint32(body[1:5])
+	if uint32(len(body)-5) < size {
+		return nil, false
+	}
+	msgType, ok := authorizer.RequestType(method)
+	if !ok {
+		return nil, false
+	}
+	msg := msgType.New().Interface()
+	if err := proto.Unmarshal(body[5:5+size], msg); err != nil {
+		return nil, false
+	}
+	return msg, true
+}
+
+// HeaderUserExtractor returns a UserExtractor that decodes the user from a json header - for example the
+// x-jwt-payload header set by Envoy's jwt_authn filter with forward_payload_header. The header may be plain or
+// base64url encoded json. It returns an Unauthenticated error if the header is missing or invalid.
+//
+// The header is trusted as is: the proxy must strip (or overwrite) the header on every incoming request, otherwise
+// any client can claim any identity by setting it. Prefer verifying the token itself with the jwtauth package when the
+// proxy doesn't guarantee this
+func HeaderUserExtractor(header string) authorizer.UserExtractor {
+	header = strings.ToLower(header)
+	return func(ctx context.Context) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(header)
+		if len(values) == 0 || values[0] == "" {
+			return nil, authorizer.Unauthenticated("")
+		}
+		data := []byte(values[0])
+		if !json.Valid(data) {
+			decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(values[0], "="))
+			if err != nil {
+				return nil, authorizer.Unauthenticated(fmt.Sprintf("authorizer: invalid %s header", header))
+			}
+			data = decoded
+		}
+		var user map[string]any
+		if err := json.Unmarshal(data, &user); err != nil {
+			return nil, authorizer.Unauthenticated(fmt.Sprintf("authorizer: invalid %s header", header))
+		}
+		return user, nil
+	}
+}
+
+// socketAddr is a net.Addr for the source address reported by Envoy
+type socketAddr struct {
+	addr *corev3.SocketAddress
+}
+
+func (a socketAddr) Network() string {
+	return strings.ToLower(a.addr.GetProtocol().String())
+}
+
+func (a socketAddr) String() string {
+	return net.JoinHostPort(a.addr.GetAddress(), strconv.Itoa(int(a.addr.GetPortValue())))
+}
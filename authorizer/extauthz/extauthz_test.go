@@ -0,0 +1,222 @@
+package extauthz_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/extauthz"
+)
+
+func newClient(t *testing.T) authv3.AuthorizationClient {
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		healthpb.Health_Check_FullMethodName: {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "request.Service == 'example' && 'admin' in user.roles",
+				},
+			},
+		},
+		"/example.AccountService/GetAccount": {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "metadata['x-account-id'] == user.account_id",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	extauthz.NewServer(authz, extauthz.WithAuthorizerOpts(
+		authorizer.WithUserExtractor(extauthz.HeaderUserExtractor("x-jwt-payload")),
+	)).Register(srv)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return authv3.NewAuthorizationClient(conn)
+}
+
+func grpcFrame(t *testing.T, msg proto.Message) []byte {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	return append(frame, data...)
+}
+
+func checkRequest(path string, headers map[string]string, body []byte) *authv3.CheckRequest {
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Address: &corev3.Address{
+					Address: &corev3.Address_SocketAddress{
+						SocketAddress: &corev3.SocketAddress{
+							Address:       "10.0.0.1",
+							PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 1234},
+						},
+					},
+				},
+			},
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method:  "POST",
+					Path:    path,
+					Headers: headers,
+					RawBody: body,
+				},
+			},
+		},
+	}
+}
+
+type fixture struct {
+	name         string
+	request      func(t *testing.T) *authv3.CheckRequest
+	expectCode   codes.Code
+	expectStatus typev3.StatusCode
+}
+
+var (
+	admin   = base64.RawURLEncoding.EncodeToString([]byte(`{"roles": ["admin"], "account_id": "123"}`))
+	guest   = `{"roles": ["guest"], "account_id": "456"}`
+	grpcCTs = map[string]string{"content-type": "application/grpc"}
+)
+
+func withHeaders(headers ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, h := range headers {
+		for k, v := range h {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+var fixtures = []fixture{
+	{
+		name: "raw body rule (allow)",
+		request: func(t *testing.T) *authv3.CheckRequest {
+			return checkRequest(healthpb.Health_Check_FullMethodName, withHeaders(grpcCTs, map[string]string{"x-jwt-payload": admin}),
+				grpcFrame(t, &healthpb.HealthCheckRequest{Service: "example"}))
+		},
+		expectCode: codes.OK,
+	},
+	{
+		name: "raw body rule (deny)",
+		request: func(t *testing.T) *authv3.CheckRequest {
+			return checkRequest(healthpb.Health_Check_FullMethodName, withHeaders(grpcCTs, map[string]string{"x-jwt-payload": guest}),
+				grpcFrame(t, &healthpb.HealthCheckRequest{Service: "example"}))
+		},
+		expectCode:   codes.PermissionDenied,
+		expectStatus: typev3.StatusCode_Forbidden,
+	},
+	{
+		name: "metadata rule (allow)",
+		request: func(t *testing.T) *authv3.CheckRequest {
+			return checkRequest("/example.AccountService/GetAccount?x=1", withHeaders(grpcCTs, map[string]string{"x-jwt-payload": guest, "x-account-id": "456"}), nil)
+		},
+		expectCode: codes.OK,
+	},
+	{
+		name: "metadata rule (deny)",
+		request: func(t *testing.T) *authv3.CheckRequest {
+			return checkRequest("/example.AccountService/GetAccount", withHeaders(grpcCTs, map[string]string{"x-jwt-payload": admin, "x-account-id": "456"}), nil)
+		},
+		expectCode:   codes.PermissionDenied,
+		expectStatus: typev3.StatusCode_Forbidden,
+	},
+	{
+		name: "unauthenticated",
+		request: func(t *testing.T) *authv3.CheckRequest {
+			return checkRequest("/example.AccountService/GetAccount", map[string]string{"x-account-id": "123"}, nil)
+		},
+		expectCode:   codes.Unauthenticated,
+		expectStatus: typev3.StatusCode_Unauthorized,
+	},
+	{
+		name: "empty path",
+		request: func(t *testing.T) *authv3.CheckRequest {
+			return checkRequest("", withHeaders(grpcCTs, map[string]string{"x-jwt-payload": admin}), nil)
+		},
+		expectCode:   codes.PermissionDenied,
+		expectStatus: typev3.StatusCode_Forbidden,
+	},
+	{
+		name: "path without method",
+		request: func(t *testing.T) *authv3.CheckRequest {
+			return checkRequest("healthz", map[string]string{"x-jwt-payload": admin}, nil)
+		},
+		expectCode:   codes.PermissionDenied,
+		expectStatus: typev3.StatusCode_Forbidden,
+	},
+	{
+		name: "nested path",
+		request: func(t *testing.T) *authv3.CheckRequest {
+			return checkRequest("/example.AccountService/GetAccount/123", withHeaders(grpcCTs, map[string]string{"x-jwt-payload": admin}), nil)
+		},
+		expectCode:   codes.PermissionDenied,
+		expectStatus: typev3.StatusCode_Forbidden,
+	},
+}
+
+func TestServer_Check(t *testing.T) {
+	client := newClient(t)
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			resp, err := client.Check(context.Background(), f.request(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codes.Code(resp.GetStatus().GetCode()) != f.expectCode {
+				t.Fatalf("expected code %v, got %v: %s", f.expectCode, codes.Code(resp.GetStatus().GetCode()), resp.GetStatus().GetMessage())
+			}
+			if f.expectCode == codes.OK {
+				if resp.GetOkResponse() == nil {
+					t.Fatal("expected an ok response")
+				}
+				return
+			}
+			denied := resp.GetDeniedResponse()
+			if denied == nil {
+				t.Fatal("expected a denied response")
+			}
+			if denied.GetStatus().GetCode() != f.expectStatus {
+				t.Fatalf("expected http status %v, got %v", f.expectStatus, denied.GetStatus().GetCode())
+			}
+		})
+	}
+}
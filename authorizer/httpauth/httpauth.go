@@ -0,0 +1,279 @@
+package httpauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+// MethodResolver maps an http request to the full grpc method name (/package.Service/Method) used to look up rules
+type MethodResolver func(r *http.Request) (method string, ok bool)
+
+// RequestDecoder decodes the body of an http request into the request object passed to the authorizer
+type RequestDecoder func(r *http.Request, method string) (any, error)
+
+// Opt is a functional option for configuring the middleware
+type Opt func(*options)
+
+// resolver maps an http request to a grpc method and the path parameters of its route (nil if unknown)
+type resolver func(r *http.Request) (method string, params map[string]string, ok bool)
+
+type options struct {
+	resolvers   []resolver
+	decoder     RequestDecoder
+	authzOpts   []authorizer.Opt
+	passthrough bool
+	maxBodySize int64
+}
+
+// WithMethodResolver adds a function that maps http requests to grpc methods. Resolvers are tried in order.
+// Only the body is decoded into the request of methods resolved this way - use WithRoutes to add path parameters
+func WithMethodResolver(fn MethodResolver) Opt {
+	return func(o *options) {
+		o.resolvers = append(o.resolvers, func(r *http.Request) (string, map[string]string, bool) {
+			method, ok := fn(r)
+			return method, nil, ok
+		})
+	}
+}
+
+// WithRoutes adds a route table that maps http.ServeMux patterns ("GET /v1/accounts/{id}") to grpc methods. It is the
+// way to map grpc-gateway routes to methods in middleware that wraps the gateway mux: use the paths of the
+// google.api.http annotations with {field} wildcards. Like the gateway, the (unescaped) values of the wildcards are set
+// on the decoded request - dotted names ({account.id}) set nested fields - and override the values of the body
+func WithRoutes(routes map[string]string) Opt {
+	mux := http.NewServeMux()
+	for pattern := range routes {
+		mux.Handle(pattern, http.NotFoundHandler())
+	}
+	return func(o *options) {
+		o.resolvers = append(o.resolvers, func(r *http.Request) (string, map[string]string, bool) {
+			_, pattern := mux.Handler(r)
+			method, ok := routes[pattern]
+			if !ok {
+				return "", nil, false
+			}
+			return method, pathParams(pattern, r.URL.EscapedPath()), true
+		})
+	}
+}
+
+// WithMethodFromContext adds a resolver that reads the grpc method from the request context. Note that grpc-gateway
+// only sets runtime.RPCMethod inside the gateway mux (for handlers and middlewares registered with the mux) - it
+// isn't set in http middleware that wraps the mux, use WithRoutes there
+func WithMethodFromContext(fn func(ctx context.Context) (string, bool)) Opt {
+	return WithMethodResolver(func(r *http.Request) (string, bool) {
+		return fn(r.Context())
+	})
+}
+
+// WithRequestDecoder sets the function used to decode the request body. By default DefaultRequestDecoder is used
+func WithRequestDecoder(decoder RequestDecoder) Opt {
+	return func(o *options) {
+		o.decoder = decoder
+	}
+}
+
+// WithAuthorizerOpts sets the interceptor options (user extractor, whitelist, hooks...) applied to http requests
+func WithAuthorizerOpts(opts ...authorizer.Opt) Opt {
+	return func(o *options) {
+		o.authzOpts = append(o.authzOpts, opts...)
+	}
+}
+
+// WithPassthroughUnmatched lets requests that can't be mapped to a grpc method through without authorization.
+// By default they are denied
+func WithPassthroughUnmatched() Opt {
+	return func(o *options) {
+		o.passthrough = true
+	}
+}
+
+// WithMaxBodySize sets the maximum size of request bodies (default 4MB). Larger requests are rejected with 413
+func WithMaxBodySize(size int64) Opt {
+	return func(o *options) {
+		o.maxBodySize = size
+	}
+}
+
+// Middleware returns http middleware that authorizes requests with the given authorizer so that the same rules can be
+// enforced for grpc and http transports. Headers are passed to the rules as metadata (lower-cased keys), the host as
+// the authority, the remote address as the peer and the decoded body as the request. Like grpc-gateway, path
+// parameters (see WithRoutes) and query parameters are set on the request; query parameters that would set fields of
+// a request with a body are rejected with 400 since the gateway ignores them. Requests are rejected with 401/403 and
+// a json error body
+func Middleware(authz authorizer.Authorizer, opts ...Opt) func(http.Handler) http.Handler {
+	o := &options{
+		decoder:     DefaultRequestDecoder,
+		maxBodySize: 4 << 20,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	enforcer := authorizer.NewEnforcer(authz, o.authzOpts...)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method, params, ok := o.resolve(r)
+			if !ok {
+				if o.passthrough {
+					next.ServeHTTP(w, r)
+					return
+				}
+				WriteError(w, authorizer.PermissionDenied(r.URL.Path, ""))
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, o.maxBodySize))
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					writeError(w, http.StatusRequestEntityTooLarge, status.Errorf(codes.InvalidArgument, "authorizer: request body too large"))
+					return
+				}
+				WriteError(w, status.Errorf(codes.InvalidArgument, "authorizer: failed to read request body"))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			req, err := o.decoder(r, method)
+			if err != nil {
+				WriteError(w, status.Errorf(codes.InvalidArgument, "authorizer: failed to decode request body"))
+				return
+			}
+			if req, err = setPathParams(req, method, params); err != nil {
+				WriteError(w, status.Errorf(codes.InvalidArgument, "authorizer: invalid path parameter: %v", err.Error()))
+				return
+			}
+			if req, err = setQueryParams(req, method, r.URL.Query(), params, len(bytes.TrimSpace(body)) > 0); err != nil {
+				WriteError(w, status.Errorf(codes.InvalidArgument, "authorizer: invalid query parameter: %v", err.Error()))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			md := HeaderToMetadata(r.Header)
+			md.Set(":authority", r.Host)
+			ctx := metadata.NewIncomingContext(r.Context(), md)
+			if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+				ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+			}
+			if err := enforcer.Authorize(ctx, method, req, false); err != nil {
+				WriteError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (o *options) resolve(r *http.Request) (string, map[string]string, bool) {
+	for _, resolver := range o.resolvers {
+		if method, params, ok := resolver(r); ok {
+			return method, params, true
+		}
+	}
+	return "", nil, false
+}
+
+// HeaderToMetadata converts http headers to grpc metadata
+func HeaderToMetadata(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for k, v := range header {
+		md.Append(strings.ToLower(k), v...)
+	}
+	return md
+}
+
+// DefaultRequestDecoder decodes json bodies. If the input message of the method is registered in
+// protoregistry.GlobalFiles (the generated code of the service is linked in), the body is decoded into it with
+// protojson so that rules see the same request object as over grpc. Otherwise the body is decoded into a map.
+// Empty bodies decode to nil
+func DefaultRequestDecoder(r *http.Request, method string) (any, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	if msgType, ok := authorizer.RequestType(method); ok {
+		msg := msgType.New().Interface()
+		if err := protojson.Unmarshal(body, msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+	var req map[string]any
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// errorBody is the json body written by WriteError
+type errorBody struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// WriteError writes a grpc status error as a json error body with the matching http status code
+func WriteError(w http.ResponseWriter, err error) {
+	writeError(w, HTTPStatusFromCode(status.Code(err)), err)
+}
+
+func writeError(w http.ResponseWriter, httpStatus int, err error) {
+	st := status.Convert(err)
+	body := errorBody{
+		Code:    int(st.Code()),
+		Message: st.Message(),
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			body.Reason = info.Reason
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// HTTPStatusFromCode converts a grpc status code to the corresponding http status code
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		return 499
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
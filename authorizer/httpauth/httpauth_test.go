@@ -0,0 +1,275 @@
+package httpauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/httpauth"
+)
+
+type fixture struct {
+	name         string
+	method       string
+	path         string
+	body         string
+	headers      map[string]string
+	expectStatus int
+	expectReason string
+}
+
+var fixtures = []fixture{
+	{
+		name:         "metadata rule (allow)",
+		method:       http.MethodGet,
+		path:         "/v1/accounts/123",
+		headers:      map[string]string{"X-Account-Id": "123", "X-User": "alice"},
+		expectStatus: http.StatusOK,
+	},
+	{
+		name:         "metadata rule (deny)",
+		method:       http.MethodGet,
+		path:         "/v1/accounts/123",
+		headers:      map[string]string{"X-Account-Id": "456", "X-User": "alice"},
+		expectStatus: http.StatusForbidden,
+		expectReason: authorizer.ReasonPermissionDenied,
+	},
+	{
+		name:         "json body rule (allow)",
+		method:       http.MethodPost,
+		path:         "/v1/accounts",
+		body:         `{"account_id": "123"}`,
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusOK,
+	},
+	{
+		name:         "json body rule (deny)",
+		method:       http.MethodPost,
+		path:         "/v1/accounts",
+		body:         `{"account_id": "456"}`,
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusForbidden,
+	},
+	{
+		name:         "proto body rule (allow)",
+		method:       http.MethodPost,
+		path:         "/healthz",
+		body:         `{"service": "example"}`,
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusOK,
+	},
+	{
+		name:         "unauthenticated",
+		method:       http.MethodGet,
+		path:         "/v1/accounts/123",
+		headers:      map[string]string{"X-Account-Id": "123"},
+		expectStatus: http.StatusUnauthorized,
+		expectReason: authorizer.ReasonUnauthenticated,
+	},
+	{
+		name:         "unmatched route",
+		method:       http.MethodDelete,
+		path:         "/v1/accounts/123",
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusForbidden,
+	},
+	{
+		name:         "path parameter rule (allow)",
+		method:       http.MethodPut,
+		path:         "/v1/accounts/123",
+		body:         `{"id": "456", "name": "example"}`,
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusOK,
+	},
+	{
+		name:         "path parameter rule (deny)",
+		method:       http.MethodPut,
+		path:         "/v1/accounts/456",
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusForbidden,
+	},
+	{
+		name:         "proto path parameter rule (allow)",
+		method:       http.MethodGet,
+		path:         "/healthz/example",
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusOK,
+	},
+	{
+		name:         "escaped slash in path parameter (deny)",
+		method:       http.MethodPut,
+		path:         "/v1/accounts/123%2F456",
+		body:         `{"name": "example"}`,
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusForbidden,
+	},
+	{
+		name:         "query parameter rule (allow)",
+		method:       http.MethodGet,
+		path:         "/v1/accounts?account_id=123",
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusOK,
+	},
+	{
+		name:         "query parameter rule (deny)",
+		method:       http.MethodGet,
+		path:         "/v1/accounts?account_id=456",
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusForbidden,
+	},
+	{
+		name:         "proto query parameter rule (allow)",
+		method:       http.MethodGet,
+		path:         "/healthz?service=example&unknown=1",
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusOK,
+	},
+	{
+		name:         "proto query parameter rule (deny)",
+		method:       http.MethodGet,
+		path:         "/healthz?service=other",
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusForbidden,
+	},
+	{
+		name:         "query parameter with body",
+		method:       http.MethodPost,
+		path:         "/healthz?service=example",
+		body:         `{"service": "other"}`,
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusBadRequest,
+	},
+	{
+		name:         "body too large",
+		method:       http.MethodPost,
+		path:         "/v1/accounts",
+		body:         `{"account_id": "123", "padding": "` + strings.Repeat("x", 1024) + `"}`,
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusRequestEntityTooLarge,
+	},
+	{
+		name:         "invalid body",
+		method:       http.MethodPost,
+		path:         "/v1/accounts",
+		body:         `{`,
+		headers:      map[string]string{"X-User": "alice"},
+		expectStatus: http.StatusBadRequest,
+	},
+}
+
+func TestMiddleware(t *testing.T) {
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.AccountService/GetAccount": {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "metadata['x-account-id'] == '123'",
+				},
+			},
+		},
+		"/example.AccountService/ListAccounts": {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "request.account_id == '123'",
+				},
+			},
+		},
+		"/example.AccountService/CreateAccount": {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "request.account_id == '123'",
+				},
+			},
+		},
+		"/example.AccountService/UpdateAccount": {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "request.id == '123' && request.name == 'example'",
+				},
+			},
+		},
+		"/grpc.health.v1.Health/Check": {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "request.Service == 'example'",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	middleware := httpauth.Middleware(authz,
+		httpauth.WithRoutes(map[string]string{
+			"GET /v1/accounts/{id}":  "/example.AccountService/GetAccount",
+			"GET /v1/accounts":       "/example.AccountService/ListAccounts",
+			"POST /v1/accounts":      "/example.AccountService/CreateAccount",
+			"PUT /v1/accounts/{id}":  "/example.AccountService/UpdateAccount",
+			"POST /healthz":          healthpb.Health_Check_FullMethodName,
+			"GET /healthz":           healthpb.Health_Check_FullMethodName,
+			"GET /healthz/{service}": healthpb.Health_Check_FullMethodName,
+		}),
+		httpauth.WithMaxBodySize(512),
+		httpauth.WithAuthorizerOpts(authorizer.WithUserExtractor(func(ctx context.Context) (any, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			if len(md.Get("x-user")) == 0 {
+				return nil, authorizer.Unauthenticated("")
+			}
+			return map[string]any{"name": md.Get("x-user")[0]}, nil
+		})),
+	)
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the body must still be readable by the handler
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			r := httptest.NewRequest(f.method, f.path, strings.NewReader(f.body))
+			for k, v := range f.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != f.expectStatus {
+				t.Fatalf("expected status %d, got %d: %s", f.expectStatus, w.Code, w.Body.String())
+			}
+			if f.expectStatus == http.StatusOK {
+				if w.Body.String() != f.body {
+					t.Fatalf("expected the handler to receive the body %q, got %q", f.body, w.Body.String())
+				}
+				return
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("expected a json error body, got %q", w.Body.String())
+			}
+			if f.expectReason != "" && body["reason"] != f.expectReason {
+				t.Fatalf("expected reason %s, got %v", f.expectReason, body["reason"])
+			}
+		})
+	}
+}
+
+func TestWithPassthroughUnmatched(t *testing.T) {
+	handler := httpauth.Middleware(authorizer.AuthorizeMethodFunc(func(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+		return false, nil
+	}), httpauth.WithPassthroughUnmatched())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected unmatched request to pass through, got %d", w.Code)
+	}
+}
@@ -0,0 +1,259 @@
+package httpauth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+// errUnknownField is returned by setProtoField if the path doesn't name a field of the message
+var errUnknownField = errors.New("unknown field")
+
+// pathParams returns the values of the {name} and {name...} wildcards of an http.ServeMux pattern that matched the
+// escaped path. Like http.ServeMux the path is split into segments before they are unescaped, so an escaped slash
+// (%2F) stays part of its segment
+func pathParams(pattern string, path string) map[string]string {
+	// strip the method and host of the pattern
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		pattern = pattern[i:]
+	}
+	patternSegments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	params := map[string]string{}
+	for i, segment := range patternSegments {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") || segment == "{$}" {
+			continue
+		}
+		name := strings.TrimSuffix(segment[1:len(segment)-1], "...")
+		switch {
+		case strings.HasSuffix(segment, "...}"):
+			if i < len(pathSegments) {
+				params[name] = unescape(strings.Join(pathSegments[i:], "/"))
+			}
+		case i < len(pathSegments):
+			params[name] = unescape(pathSegments[i])
+		}
+	}
+	return params
+}
+
+func unescape(segment string) string {
+	if value, err := url.PathUnescape(segment); err == nil {
+		return value
+	}
+	return segment
+}
+
+// newRequest returns the request to set parameters on: the decoded request, the input message of the method (if it
+// is registered, see authorizer.RequestType) or a map
+func newRequest(req any, method string) any {
+	if req != nil {
+		return req
+	}
+	if msgType, ok := authorizer.RequestType(method); ok {
+		return msgType.New().Interface()
+	}
+	return map[string]any{}
+}
+
+// setPathParams sets the path parameters on a decoded request. Dotted names set nested fields. Empty requests become
+// the input message of the method (if it is registered, see authorizer.RequestType) or a map. Requests that are not
+// maps or proto messages are returned unchanged
+func setPathParams(req any, method string, params map[string]string) (any, error) {
+	if len(params) == 0 {
+		return req, nil
+	}
+	switch r := newRequest(req, method).(type) {
+	case proto.Message:
+		for name, value := range params {
+			if err := setProtoField(r.ProtoReflect(), strings.Split(name, "."), []string{value}); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err.Error())
+			}
+		}
+		return r, nil
+	case map[string]any:
+		for name, value := range params {
+			setMapField(r, strings.Split(name, "."), value)
+		}
+		return r, nil
+	default:
+		return req, nil
+	}
+}
+
+// setQueryParams sets the query parameters on a decoded request like grpc-gateway's runtime.PopulateQueryParameters:
+// dotted names set nested fields, repeated fields take every value, parameters that don't name a field of a proto
+// request and parameters of path parameter fields are ignored. grpc-gateway doesn't read the query of requests with a
+// body (body: "*"), so query parameters that would set a field of a request with a body are rejected instead of
+// letting the rules and the handler see different requests
+func setQueryParams(req any, method string, query url.Values, pathParams map[string]string, hasBody bool) (any, error) {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		if _, ok := pathParams[name]; !ok && name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return req, nil
+	}
+	switch r := newRequest(req, method).(type) {
+	case proto.Message:
+		for _, name := range names {
+			if hasBody {
+				if hasProtoField(r.ProtoReflect().Descriptor(), strings.Split(name, ".")) {
+					return nil, fmt.Errorf("%s: query parameters can't be combined with a request body", name)
+				}
+				continue
+			}
+			err := setProtoField(r.ProtoReflect(), strings.Split(name, "."), query[name])
+			if err != nil && !errors.Is(err, errUnknownField) {
+				return nil, fmt.Errorf("%s: %v", name, err.Error())
+			}
+		}
+		return r, nil
+	case map[string]any:
+		for _, name := range names {
+			if hasBody {
+				return nil, fmt.Errorf("%s: query parameters can't be combined with a request body", name)
+			}
+			if values := query[name]; len(values) == 1 {
+				setMapField(r, strings.Split(name, "."), values[0])
+			} else {
+				list := make([]any, len(values))
+				for i, value := range values {
+					list[i] = value
+				}
+				setMapField(r, strings.Split(name, "."), list)
+			}
+		}
+		return r, nil
+	default:
+		return req, nil
+	}
+}
+
+func setMapField(m map[string]any, path []string, value any) {
+	for _, name := range path[:len(path)-1] {
+		next, ok := m[name].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			m[name] = next
+		}
+		m = next
+	}
+	m[path[len(path)-1]] = value
+}
+
+// protoField returns the field of a message by its proto or json name
+func protoField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return fields.ByJSONName(name)
+}
+
+// hasProtoField returns true if the dotted path names a field of the message
+func hasProtoField(md protoreflect.MessageDescriptor, path []string) bool {
+	for i, name := range path {
+		fd := protoField(md, name)
+		if fd == nil {
+			return false
+		}
+		if i < len(path)-1 {
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return false
+			}
+			md = fd.Message()
+		}
+	}
+	return true
+}
+
+// setProtoField sets a field of a message. Repeated fields are set to all values, other fields require one value
+func setProtoField(m protoreflect.Message, path []string, values []string) error {
+	for i, name := range path {
+		fd := protoField(m.Descriptor(), name)
+		if fd == nil {
+			return fmt.Errorf("%w %s", errUnknownField, name)
+		}
+		if fd.IsMap() || (fd.IsList() && i < len(path)-1) {
+			return fmt.Errorf("unsupported field %s", name)
+		}
+		if i < len(path)-1 {
+			if fd.Message() == nil {
+				return fmt.Errorf("%s is not a message", name)
+			}
+			m = m.Mutable(fd).Message()
+			continue
+		}
+		if fd.IsList() {
+			list := m.Mutable(fd).List()
+			for _, value := range values {
+				v, err := protoValue(fd, value)
+				if err != nil {
+					return err
+				}
+				list.Append(v)
+			}
+			return nil
+		}
+		if len(values) != 1 {
+			return fmt.Errorf("expected one value for %s, got %d", name, len(values))
+		}
+		v, err := protoValue(fd, values[0])
+		if err != nil {
+			return err
+		}
+		m.Set(fd, v)
+	}
+	return nil
+}
+
+// protoValue parses a path or query parameter into the value of a scalar field
+func protoValue(fd protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(value), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(value)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(value, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(value, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(value, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(value, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(value, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(value)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		v, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	case protoreflect.BytesKind:
+		v, err := base64.URLEncoding.DecodeString(value)
+		return protoreflect.ValueOfBytes(v), err
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported field type %s", fd.Kind())
+	}
+}
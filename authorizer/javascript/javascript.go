@@ -2,11 +2,15 @@ package javascript
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/dop251/goja"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/autom8ter/proto/gen/authorize"
 
@@ -25,11 +29,63 @@ func WithVariables(variables map[string]any) Opt {
 	}
 }
 
+// VariablesFunc returns variables/functions for the evaluation of a request - for example functions that must be
+// canceled with the request context
+type VariablesFunc func(ctx context.Context) map[string]any
+
+// WithContextVariables sets a function that returns additional variables/functions for every evaluation. They are set
+// after (and override) the variables of WithVariables
+func WithContextVariables(fn VariablesFunc) Opt {
+	return func(a *JavascriptAuthorizer) {
+		a.contextVariables = append(a.contextVariables, fn)
+	}
+}
+
+// WithProtoFieldNames exposes protobuf requests and users as objects keyed by their proto field names, converted with
+// authorizer.ProtoToMap - authorizer.JSONFieldName for request.accountId or authorizer.ProtoFieldName for
+// request.account_id. Enums are their value names, unset oneof members and messages are absent, timestamps are Dates,
+// durations are milliseconds and bytes are base64 strings. By default messages are exposed as Go structs
+// (request.AccountId)
+func WithProtoFieldNames(name authorizer.FieldNameFunc) Opt {
+	return func(a *JavascriptAuthorizer) {
+		a.protoFieldName = name
+	}
+}
+
+// WithFieldNameMapper sets the goja.FieldNameMapper used to expose Go structs to the vm - for example
+// goja.TagFieldNameMapper("json", true) to use the json tags or goja.UncapFieldNameMapper() for lower camel case names
+func WithFieldNameMapper(mapper goja.FieldNameMapper) Opt {
+	return func(a *JavascriptAuthorizer) {
+		a.fieldNameMapper = mapper
+	}
+}
+
+// WithResourceLoaders sets the registry of the loaders that rules select with a @resource(name) prefix. The loaded
+// resource is available to the rule as the "resource" variable. Rules whose resource fails to load are skipped (they
+// don't match)
+func WithResourceLoaders(loaders *authorizer.ResourceLoaders) Opt {
+	return func(a *JavascriptAuthorizer) {
+		a.resourceLoaders = loaders
+	}
+}
+
+// WithTracerProvider enables tracing - a span named "authorize.evaluate" is created for every call to AuthorizeMethod
+func WithTracerProvider(tp trace.TracerProvider) Opt {
+	return func(a *JavascriptAuthorizer) {
+		a.tracerProvider = tp
+	}
+}
+
 // JavascriptAuthorizer is a javascript vm that uses javascript expressions to authorize grpc requests
 type JavascriptAuthorizer struct {
-	rules          map[string]*authorize.RuleSet
-	cachedPrograms sync.Map
-	variables      map[string]any
+	rules            map[string]*authorize.RuleSet
+	cachedPrograms   sync.Map
+	variables        map[string]any
+	contextVariables []VariablesFunc
+	protoFieldName   authorizer.FieldNameFunc
+	fieldNameMapper  goja.FieldNameMapper
+	resourceLoaders  *authorizer.ResourceLoaders
+	tracerProvider   trace.TracerProvider
 }
 
 // NewJavascriptAuthorizer returns a new JavascriptAuthorizer. The rules map is a map of method names to RuleSets. The RuleSets are used to
@@ -37,9 +93,10 @@ type JavascriptAuthorizer struct {
 // the request. The mapping can be generated with the protoc-gen-authorize plugin.
 func NewJavascriptAuthorizer(rules map[string]*authorize.RuleSet, opts ...Opt) (*JavascriptAuthorizer, error) {
 	a := &JavascriptAuthorizer{
-		rules:          rules,
-		cachedPrograms: sync.Map{},
-		variables:      map[string]any{},
+		rules:           rules,
+		cachedPrograms:  sync.Map{},
+		variables:       map[string]any{},
+		resourceLoaders: authorizer.NewResourceLoaders(),
 	}
 	for _, opt := range opts {
 		opt(a)
@@ -50,10 +107,25 @@ func NewJavascriptAuthorizer(rules map[string]*authorize.RuleSet, opts ...Opt) (
 // AuthorizeMethod authorizes a gRPC method the RuleExecutionParams and returns a boolean representing whether the
 // request is authorized or not.
 func (a *JavascriptAuthorizer) AuthorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	if a.tracerProvider != nil {
+		return authorizer.TraceEvaluation(ctx, a.tracerProvider, "javascript", method, func(ctx context.Context) (bool, error) {
+			return a.authorizeMethod(ctx, method, params)
+		})
+	}
+	return a.authorizeMethod(ctx, method, params)
+}
+
+func (a *JavascriptAuthorizer) authorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	authorizer.RecordBackend(ctx, "javascript")
 	// return false if no rules exist for the method
 	rules, ok := a.rules[method]
 	if !ok {
-		svc := strings.Split(method, "/")[1]
+		// malformed methods (not /service/method) are denied
+		parts := strings.Split(method, "/")
+		if len(parts) < 2 || parts[1] == "" {
+			return false, nil
+		}
+		svc := parts[1]
 		for k, _ := range a.rules {
 			if strings.HasPrefix(k, "/"+svc) {
 				return true, nil
@@ -63,6 +135,7 @@ func (a *JavascriptAuthorizer) AuthorizeMethod(ctx context.Context, method strin
 	}
 	// allow all
 	if len(rules.Rules) == 1 && rules.Rules[0].Expression == "*" {
+		authorizer.RecordRuleMatched(ctx, 0)
 		return true, nil
 	}
 	programs, err := a.getMethodPrograms(rules)
@@ -70,11 +143,21 @@ func (a *JavascriptAuthorizer) AuthorizeMethod(ctx context.Context, method strin
 		return false, err
 	}
 	vm := goja.New()
+	if a.fieldNameMapper != nil {
+		vm.SetFieldNameMapper(a.fieldNameMapper)
+	}
 	for k, v := range a.variables {
 		if err := vm.Set(k, v); err != nil {
 			return false, fmt.Errorf("authorizer: failed to set variable: %v", err.Error())
 		}
 	}
+	for _, fn := range a.contextVariables {
+		for k, v := range fn(ctx) {
+			if err := vm.Set(k, v); err != nil {
+				return false, fmt.Errorf("authorizer: failed to set variable: %v", err.Error())
+			}
+		}
+	}
 	var (
 		metaMap = map[string]string{}
 	)
@@ -84,10 +167,18 @@ func (a *JavascriptAuthorizer) AuthorizeMethod(ctx context.Context, method strin
 	if err := vm.Set(string(authorizer.ExpressionVarMetadata), metaMap); err != nil {
 		return false, fmt.Errorf("authorizer: failed to set metadata: %v", err.Error())
 	}
-	if err := vm.Set(string(authorizer.ExpressionVarRequest), params.Request); err != nil {
+	request, err := a.value(vm, params.Request)
+	if err != nil {
+		return false, fmt.Errorf("authorizer: failed to convert request: %v", err.Error())
+	}
+	if err := vm.Set(string(authorizer.ExpressionVarRequest), request); err != nil {
 		return false, fmt.Errorf("authorizer: failed to set request: %v", err.Error())
 	}
-	if err := vm.Set(string(authorizer.ExpressionVarUser), params.User); err != nil {
+	user, err := a.value(vm, params.User)
+	if err != nil {
+		return false, fmt.Errorf("authorizer: failed to convert user: %v", err.Error())
+	}
+	if err := vm.Set(string(authorizer.ExpressionVarUser), user); err != nil {
 		return false, fmt.Errorf("authorizer: failed to set user: %v", err.Error())
 	}
 	if err := vm.Set(string(authorizer.ExpressionVarIsStream), params.IsStream); err != nil {
@@ -96,18 +187,128 @@ func (a *JavascriptAuthorizer) AuthorizeMethod(ctx context.Context, method strin
 	if err := vm.Set(string(authorizer.ExpressionVarMethod), method); err != nil {
 		return false, fmt.Errorf("authorizer: failed to set method: %v", err.Error())
 	}
-	for _, program := range programs {
+	if err := vm.Set(string(authorizer.ExpressionVarPeer), params.PeerMap()); err != nil {
+		return false, fmt.Errorf("authorizer: failed to set peer: %v", err.Error())
+	}
+	if err := vm.Set(string(authorizer.ExpressionVarAuthority), params.Authority); err != nil {
+		return false, fmt.Errorf("authorizer: failed to set authority: %v", err.Error())
+	}
+	// milliseconds, like the other javascript time values
+	if err := vm.Set(string(authorizer.ExpressionVarDeadlineRemaining), params.DeadlineRemaining().Milliseconds()); err != nil {
+		return false, fmt.Errorf("authorizer: failed to set deadline_remaining: %v", err.Error())
+	}
+	now, err := vm.New(vm.Get("Date"), vm.ToValue(params.EvaluationTime().UnixMilli()))
+	if err != nil {
+		return false, fmt.Errorf("authorizer: failed to create date: %v", err.Error())
+	}
+	if err := vm.Set(string(authorizer.ExpressionVarNow), now); err != nil {
+		return false, fmt.Errorf("authorizer: failed to set now: %v", err.Error())
+	}
+	resources := a.resourceLoaders.Resources(params)
+	for i, program := range programs {
+		authorizer.RecordRuleEvaluated(ctx)
+		var resource any
+		if name := a.resourceLoaders.LoaderName(method, rules.Rules[i].Expression); name != "" {
+			loaded, err := resources.Load(ctx, name)
+			if err != nil {
+				// a rule can't match without its resource - the other rules may still allow the request
+				continue
+			}
+			if resource, err = a.value(vm, loaded); err != nil {
+				return false, fmt.Errorf("authorizer: failed to convert resource: %v", err.Error())
+			}
+		}
+		if err := vm.Set(string(authorizer.ExpressionVarResource), resource); err != nil {
+			return false, fmt.Errorf("authorizer: failed to set resource: %v", err.Error())
+		}
 		v, err := vm.RunProgram(program)
 		if err != nil {
 			return false, fmt.Errorf("authorizer: failed to run expression: %v", err.Error())
 		}
 		if v.ToBoolean() {
+			authorizer.RecordRuleMatched(ctx, i)
 			return true, nil
 		}
 	}
 	return false, nil
 }
 
+// Validate compiles the rule expressions of every method and returns the first error. Resource loaders that are
+// selected by a rule or bound to a method must be registered
+func (a *JavascriptAuthorizer) Validate() error {
+	for method, rules := range a.rules {
+		if len(rules.GetRules()) == 1 && rules.Rules[0].Expression == "*" {
+			continue
+		}
+		for _, rule := range rules.GetRules() {
+			if err := a.resourceLoaders.Validate(rule.Expression); err != nil {
+				return fmt.Errorf("%s: %w", method, err)
+			}
+		}
+		if _, err := a.getMethodPrograms(rules); err != nil {
+			return fmt.Errorf("%s: %w", method, err)
+		}
+	}
+	return a.resourceLoaders.Validate()
+}
+
+// value converts protobuf messages to objects keyed by their proto field names if WithProtoFieldNames is set
+func (a *JavascriptAuthorizer) value(vm *goja.Runtime, v any) (any, error) {
+	if a.protoFieldName == nil {
+		return v, nil
+	}
+	msg, ok := v.(proto.Message)
+	if !ok || !msg.ProtoReflect().IsValid() {
+		return v, nil
+	}
+	return jsValue(vm, authorizer.ProtoToMap(msg, a.protoFieldName))
+}
+
+// jsValue converts the values of authorizer.ProtoToMap to their javascript representation
+func jsValue(vm *goja.Runtime, v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			converted, err := jsValue(vm, e)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = converted
+		}
+		return out, nil
+	case map[any]any:
+		// javascript object keys are strings
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			converted, err := jsValue(vm, e)
+			if err != nil {
+				return nil, err
+			}
+			out[fmt.Sprint(k)] = converted
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			converted, err := jsValue(vm, e)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = converted
+		}
+		return out, nil
+	case time.Time:
+		return vm.New(vm.Get("Date"), vm.ToValue(v.UnixMilli()))
+	case time.Duration:
+		return float64(v) / float64(time.Millisecond), nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	default:
+		return v, nil
+	}
+}
+
 func (j *JavascriptAuthorizer) getMethodPrograms(rules *authorize.RuleSet) ([]*goja.Program, error) {
 	var (
 		programs []*goja.Program
@@ -116,7 +317,8 @@ func (j *JavascriptAuthorizer) getMethodPrograms(rules *authorize.RuleSet) ([]*g
 	for _, rule := range rules.Rules {
 		program, ok := j.cachedPrograms.Load(rule.Expression)
 		if !ok {
-			program, err = goja.Compile(rule.Expression, rule.Expression, true)
+			_, expression := authorizer.SplitResourceLoader(rule.Expression)
+			program, err = goja.Compile(rule.Expression, expression, true)
 			if err != nil {
 				return nil, fmt.Errorf("authorizer: failed to compile expression: %v", err.Error())
 			}
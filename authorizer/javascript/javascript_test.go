@@ -2,7 +2,9 @@ package javascript_test
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/autom8ter/proto/gen/authorize"
 
@@ -308,6 +310,43 @@ var fixtures = []fixture{
 		},
 		expectAllow: true,
 	},
+	{
+		name:   "peer, authority and time rule (allow)",
+		method: "testing",
+		params: &authorizer.RuleExecutionParams{
+			Peer:      &authorizer.Peer{Address: "10.0.0.1:1234", IP: "10.0.0.1", Port: 1234},
+			Authority: "api.internal",
+			Now:       time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+			Deadline:  time.Date(2024, 1, 2, 10, 0, 5, 0, time.UTC),
+		},
+		rules: map[string]*authorize.RuleSet{
+			"testing": {
+				Rules: []*authorize.Rule{
+					{
+						Expression: "peer.ip.startsWith('10.') && authority === 'api.internal' && now.getUTCHours() >= 9 && now.getUTCHours() < 17 && deadline_remaining > 1000",
+					},
+				},
+			},
+		},
+		expectAllow: true,
+	},
+	{
+		name:   "peer, authority and time rule (deny)",
+		method: "testing",
+		params: &authorizer.RuleExecutionParams{
+			Now: time.Date(2024, 1, 2, 20, 0, 0, 0, time.UTC),
+		},
+		rules: map[string]*authorize.RuleSet{
+			"testing": {
+				Rules: []*authorize.Rule{
+					{
+						Expression: "!peer.tls.verified && (peer.ip.startsWith('10.') || (now.getUTCHours() >= 9 && now.getUTCHours() < 17) || deadline_remaining > 0)",
+					},
+				},
+			},
+		},
+		expectAllow: false,
+	},
 }
 
 func TestJavascriptAuthorizer_AuthorizeMethod(t *testing.T) {
@@ -424,3 +463,120 @@ func BenchmarkJavascriptAuthorizer_AuthorizeMethod(b *testing.B) {
 		})
 	}
 }
+
+func TestJavascriptAuthorizer_MalformedMethod(t *testing.T) {
+	authz, err := javascript.NewJavascriptAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Orders/Get": {Rules: []*authorize.Rule{{Expression: "*"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{"", "example.Orders", "/", "//Get"} {
+		allow, err := authz.AuthorizeMethod(context.Background(), method, &authorizer.RuleExecutionParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow {
+			t.Fatalf("%q: expected deny", method)
+		}
+	}
+}
+
+func TestWithResourceLoaders(t *testing.T) {
+	orders := map[string]map[string]any{
+		"1": {"owner": "alice", "status": "open"},
+		"2": {"owner": "bob", "status": "closed"},
+	}
+	var calls int
+	loaders := authorizer.NewResourceLoaders().
+		Register("order", func(ctx context.Context, params *authorizer.RuleExecutionParams) (any, error) {
+			calls++
+			return orders[params.Request.(map[string]any)["id"].(string)], nil
+		}).
+		Bind("/example.Orders/Cancel", "order")
+	authz, err := javascript.NewJavascriptAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Orders/Get": {Rules: []*authorize.Rule{
+			{Expression: "user.roles.includes('admin')"},
+			{Expression: "@resource(order) resource.owner === user.id"},
+		}},
+		"/example.Orders/Cancel": {Rules: []*authorize.Rule{
+			{Expression: "resource.status === 'open' && resource.owner === user.id"},
+			{Expression: "resource.status === 'closed' && user.roles.includes('admin')"},
+		}},
+	}, javascript.WithResourceLoaders(loaders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []struct {
+		method      string
+		user        string
+		roles       []string
+		order       string
+		expectAllow bool
+		expectCalls int
+	}{
+		{method: "/example.Orders/Get", user: "alice", order: "1", expectAllow: true, expectCalls: 1},
+		{method: "/example.Orders/Get", user: "alice", order: "2", expectAllow: false, expectCalls: 1},
+		// the resource isn't loaded if an earlier rule matches
+		{method: "/example.Orders/Get", user: "carol", roles: []string{"admin"}, order: "2", expectAllow: true, expectCalls: 0},
+		{method: "/example.Orders/Cancel", user: "alice", order: "1", expectAllow: true, expectCalls: 1},
+		// the resource is loaded once per request
+		{method: "/example.Orders/Cancel", user: "bob", order: "2", expectAllow: false, expectCalls: 1},
+	} {
+		calls = 0
+		allow, err := authz.AuthorizeMethod(context.Background(), f.method, &authorizer.RuleExecutionParams{
+			User:    map[string]any{"id": f.user, "roles": append([]string{}, f.roles...)},
+			Request: map[string]any{"id": f.order},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow != f.expectAllow {
+			t.Fatalf("%s %s %s: expected allow=%v, got %v", f.method, f.user, f.order, f.expectAllow, allow)
+		}
+		if calls != f.expectCalls {
+			t.Fatalf("%s %s %s: expected %d loader calls, got %d", f.method, f.user, f.order, f.expectCalls, calls)
+		}
+	}
+	// unknown loaders are rejected by Validate
+	authz, err = javascript.NewJavascriptAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Orders/Get": {Rules: []*authorize.Rule{{Expression: "@resource(invoice) resource.owner === user.id"}}},
+	}, javascript.WithResourceLoaders(loaders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authz.Validate(); err == nil {
+		t.Fatal("expected an error for an unknown resource loader")
+	}
+	// rules whose resource fails to load are skipped
+	loaders.Register("failing", func(ctx context.Context, params *authorizer.RuleExecutionParams) (any, error) {
+		return nil, errors.New("unavailable")
+	})
+	authz, err = javascript.NewJavascriptAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Orders/Get": {Rules: []*authorize.Rule{
+			{Expression: "@resource(failing) resource.owner === user.id"},
+			{Expression: "user.roles.includes('admin')"},
+		}},
+	}, javascript.WithResourceLoaders(loaders))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []struct {
+		roles       []string
+		expectAllow bool
+	}{
+		{roles: nil, expectAllow: false},
+		{roles: []string{"admin"}, expectAllow: true},
+	} {
+		allow, err := authz.AuthorizeMethod(context.Background(), "/example.Orders/Get", &authorizer.RuleExecutionParams{
+			User:    map[string]any{"id": "alice", "roles": append([]string{}, f.roles...)},
+			Request: map[string]any{"id": "1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow != f.expectAllow {
+			t.Fatalf("%v: expected allow=%v, got %v", f.roles, f.expectAllow, allow)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package javascript_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/javascript"
+)
+
+// protoRequest has a timestamp, a oneof, an enum, maps of strings and messages and a struct
+func protoRequest(t *testing.T) *authv3.CheckRequest {
+	attrs, err := structpb.NewStruct(map[string]any{"tier": "gold", "limit": 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Address: &corev3.Address{
+					Address: &corev3.Address_SocketAddress{
+						SocketAddress: &corev3.SocketAddress{
+							Protocol:      corev3.SocketAddress_UDP,
+							Address:       "10.0.0.1",
+							PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 1234},
+						},
+					},
+				},
+			},
+			Request: &authv3.AttributeContext_Request{
+				Time: timestamppb.New(time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)),
+				Http: &authv3.AttributeContext_HttpRequest{
+					Headers: map[string]string{"x-account-id": "123"},
+				},
+			},
+			MetadataContext: &corev3.Metadata{
+				FilterMetadata: map[string]*structpb.Struct{"accounts": attrs},
+			},
+		},
+	}
+}
+
+func TestWithProtoFieldNames(t *testing.T) {
+	type protoFixture struct {
+		name        string
+		fieldName   authorizer.FieldNameFunc
+		expression  string
+		expectAllow bool
+	}
+	fixtures := []protoFixture{
+		{
+			name:        "json names",
+			fieldName:   authorizer.JSONFieldName,
+			expression:  "request.attributes.request.http.headers['x-account-id'] === '123' && request.attributes.metadataContext.filterMetadata.accounts.tier === 'gold'",
+			expectAllow: true,
+		},
+		{
+			name:        "proto names",
+			fieldName:   authorizer.ProtoFieldName,
+			expression:  "request.attributes.source.address.socket_address.port_value === 1234 && request.attributes.metadata_context.filter_metadata.accounts.limit === 10",
+			expectAllow: true,
+		},
+		{
+			name:        "enum names",
+			fieldName:   authorizer.JSONFieldName,
+			expression:  "request.attributes.source.address.socketAddress.protocol === 'UDP'",
+			expectAllow: true,
+		},
+		{
+			name:        "oneof",
+			fieldName:   authorizer.JSONFieldName,
+			expression:  "'socketAddress' in request.attributes.source.address && !('pipe' in request.attributes.source.address)",
+			expectAllow: true,
+		},
+		{
+			name:        "timestamp",
+			fieldName:   authorizer.JSONFieldName,
+			expression:  "request.attributes.request.time instanceof Date && request.attributes.request.time.getUTCHours() === 10 && request.attributes.request.time < now",
+			expectAllow: true,
+		},
+		{
+			name:        "go names are not exposed",
+			fieldName:   authorizer.JSONFieldName,
+			expression:  "request.Attributes !== undefined",
+			expectAllow: false,
+		},
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			authz, err := javascript.NewJavascriptAuthorizer(map[string]*authorize.RuleSet{
+				"/example.Service/Method": {
+					Rules: []*authorize.Rule{{Expression: f.expression}},
+				},
+			}, javascript.WithProtoFieldNames(f.fieldName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			allow, err := authz.AuthorizeMethod(context.Background(), "/example.Service/Method", &authorizer.RuleExecutionParams{
+				Request: protoRequest(t),
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if allow != f.expectAllow {
+				t.Fatalf("expected allow=%v, got %v", f.expectAllow, allow)
+			}
+		})
+	}
+}
+
+type taggedUser struct {
+	AccountIds []string `json:"account_ids"`
+}
+
+func TestWithFieldNameMapper(t *testing.T) {
+	authz, err := javascript.NewJavascriptAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Service/Method": {
+			Rules: []*authorize.Rule{{Expression: "user.account_ids.includes(metadata['x-account-id'])"}},
+		},
+	}, javascript.WithFieldNameMapper(goja.TagFieldNameMapper("json", true)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow, err := authz.AuthorizeMethod(context.Background(), "/example.Service/Method", &authorizer.RuleExecutionParams{
+		User:     &taggedUser{AccountIds: []string{"123"}},
+		Metadata: map[string][]string{"x-account-id": {"123"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allow {
+		t.Fatal("expected allow")
+	}
+}
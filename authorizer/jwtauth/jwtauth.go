@@ -0,0 +1,317 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+// UserMapper converts verified claims to the user object passed to the authorizer
+type UserMapper func(claims map[string]any) (any, error)
+
+// Opt is a functional option for configuring the user extractor
+type Opt func(*options)
+
+type options struct {
+	header     string
+	jwks       [][]byte
+	jwksFiles  []string
+	pem        [][]byte
+	pemFiles   []string
+	secrets    [][]byte
+	algorithms []string
+	issuers    []string
+	audiences  []string
+	leeway     time.Duration
+	noExp      bool
+	mapping    map[string]string
+	mapper     UserMapper
+}
+
+// WithHeader sets the metadata key the bearer token is read from (default "authorization")
+func WithHeader(key string) Opt {
+	return func(o *options) {
+		o.header = strings.ToLower(key)
+	}
+}
+
+// WithJWKS adds the keys of a json web key set
+func WithJWKS(data []byte) Opt {
+	return func(o *options) {
+		o.jwks = append(o.jwks, data)
+	}
+}
+
+// WithJWKSFile adds the keys of a json web key set file
+func WithJWKSFile(path string) Opt {
+	return func(o *options) {
+		o.jwksFiles = append(o.jwksFiles, path)
+	}
+}
+
+// WithPEM adds the PEM encoded public keys or certificates
+func WithPEM(data []byte) Opt {
+	return func(o *options) {
+		o.pem = append(o.pem, data)
+	}
+}
+
+// WithPEMFile adds the public keys or certificates of a PEM file
+func WithPEMFile(path string) Opt {
+	return func(o *options) {
+		o.pemFiles = append(o.pemFiles, path)
+	}
+}
+
+// WithHMACSecret adds a secret used to verify HS256/HS384/HS512 signatures
+func WithHMACSecret(secret []byte) Opt {
+	return func(o *options) {
+		o.secrets = append(o.secrets, secret)
+	}
+}
+
+// WithAlgorithms restricts the accepted signing algorithms. By default every algorithm supported by the configured
+// keys is accepted
+func WithAlgorithms(algorithms ...string) Opt {
+	return func(o *options) {
+		o.algorithms = append(o.algorithms, algorithms...)
+	}
+}
+
+// WithIssuer sets the accepted issuers. If set, the iss claim must match one of them
+func WithIssuer(issuers ...string) Opt {
+	return func(o *options) {
+		o.issuers = append(o.issuers, issuers...)
+	}
+}
+
+// WithAudience sets the accepted audiences. If set, the aud claim must contain one of them
+func WithAudience(audiences ...string) Opt {
+	return func(o *options) {
+		o.audiences = append(o.audiences, audiences...)
+	}
+}
+
+// WithLeeway sets the clock skew allowed when validating the exp, nbf and iat claims
+func WithLeeway(leeway time.Duration) Opt {
+	return func(o *options) {
+		o.leeway = leeway
+	}
+}
+
+// WithoutExpiration accepts tokens without an exp claim. By default the exp claim is required
+func WithoutExpiration() Opt {
+	return func(o *options) {
+		o.noExp = true
+	}
+}
+
+// WithClaimMapping copies claims to user fields. The keys are the user fields and the values the claim paths, with
+// nested claims separated by dots - for example {"roles": "realm_access.roles"}. Unmapped claims are kept
+func WithClaimMapping(mapping map[string]string) Opt {
+	return func(o *options) {
+		if o.mapping == nil {
+			o.mapping = map[string]string{}
+		}
+		for k, v := range mapping {
+			o.mapping[k] = v
+		}
+	}
+}
+
+// WithUserMapper sets a function that converts the claims (after the claim mapping) to the user object.
+// By default the user is the claims map
+func WithUserMapper(mapper UserMapper) Opt {
+	return func(o *options) {
+		o.mapper = mapper
+	}
+}
+
+// NewUserExtractor returns a UserExtractor that reads a bearer token from the authorization metadata, verifies its
+// signature with the configured JWKS, PEM or HMAC keys and validates the exp, nbf, iss and aud claims.
+// The user is the claims map so rules can use it directly (user.sub, user.roles...).
+// Missing or invalid tokens result in an Unauthenticated error
+func NewUserExtractor(opts ...Opt) (authorizer.UserExtractor, error) {
+	o := &options{
+		header: "authorization",
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	keys, err := o.keys()
+	if err != nil {
+		return nil, err
+	}
+	algorithms := o.algorithms
+	if len(algorithms) == 0 {
+		algorithms = supportedAlgorithms(keys)
+	}
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(algorithms),
+		jwt.WithLeeway(o.leeway),
+		jwt.WithIssuedAt(),
+	}
+	if !o.noExp {
+		parserOpts = append(parserOpts, jwt.WithExpirationRequired())
+	}
+	parser := jwt.NewParser(parserOpts...)
+	keyFunc := func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		var set jwt.VerificationKeySet
+		for _, k := range keys {
+			if kid != "" && k.id != "" && k.id != kid {
+				continue
+			}
+			if k.alg != "" && k.alg != token.Method.Alg() {
+				continue
+			}
+			set.Keys = append(set.Keys, k.key)
+		}
+		if len(set.Keys) == 0 {
+			return nil, fmt.Errorf("no matching key")
+		}
+		return set, nil
+	}
+	return func(ctx context.Context) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(o.header)
+		if len(values) == 0 || values[0] == "" {
+			return nil, authorizer.Unauthenticated("")
+		}
+		scheme, raw, ok := strings.Cut(values[0], " ")
+		if !ok || !strings.EqualFold(scheme, "bearer") {
+			return nil, authorizer.Unauthenticated("authorizer: invalid authorization header")
+		}
+		claims := jwt.MapClaims{}
+		if _, err := parser.ParseWithClaims(strings.TrimSpace(raw), claims, keyFunc); err != nil {
+			return nil, authorizer.Unauthenticated(fmt.Sprintf("authorizer: invalid token: %v", err.Error()))
+		}
+		if err := o.validate(claims); err != nil {
+			return nil, authorizer.Unauthenticated(fmt.Sprintf("authorizer: invalid token: %v", err.Error()))
+		}
+		user := map[string]any(claims)
+		for field, path := range o.mapping {
+			if v, ok := lookup(user, path); ok {
+				user[field] = v
+			}
+		}
+		if o.mapper != nil {
+			return o.mapper(user)
+		}
+		return user, nil
+	}, nil
+}
+
+func (o *options) keys() ([]key, error) {
+	var keys []key
+	for _, path := range o.jwksFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("authorizer: failed to read jwks file: %v", err.Error())
+		}
+		o.jwks = append(o.jwks, data)
+	}
+	for _, path := range o.pemFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("authorizer: failed to read pem file: %v", err.Error())
+		}
+		o.pem = append(o.pem, data)
+	}
+	for _, data := range o.jwks {
+		k, err := parseJWKS(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+	}
+	for _, data := range o.pem {
+		k, err := parsePEM(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+	}
+	for _, secret := range o.secrets {
+		keys = append(keys, key{key: secret})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("authorizer: no verification keys configured")
+	}
+	return keys, nil
+}
+
+// validate checks the iss and aud claims against the accepted values
+func (o *options) validate(claims jwt.MapClaims) error {
+	if len(o.issuers) > 0 {
+		iss, _ := claims.GetIssuer()
+		if !slices.Contains(o.issuers, iss) {
+			return fmt.Errorf("invalid issuer: %s", iss)
+		}
+	}
+	if len(o.audiences) > 0 {
+		aud, _ := claims.GetAudience()
+		if !slices.ContainsFunc(aud, func(a string) bool {
+			return slices.Contains(o.audiences, a)
+		}) {
+			return fmt.Errorf("invalid audience: %v", []string(aud))
+		}
+	}
+	return nil
+}
+
+// supportedAlgorithms returns the signing algorithms that can be verified with the keys
+func supportedAlgorithms(keys []key) []string {
+	var algorithms []string
+	add := func(algs ...string) {
+		for _, alg := range algs {
+			if !slices.Contains(algorithms, alg) {
+				algorithms = append(algorithms, alg)
+			}
+		}
+	}
+	for _, k := range keys {
+		if k.alg != "" {
+			add(k.alg)
+			continue
+		}
+		switch k.key.(type) {
+		case *rsa.PublicKey:
+			add("RS256", "RS384", "RS512", "PS256", "PS384", "PS512")
+		case *ecdsa.PublicKey:
+			add("ES256", "ES384", "ES512")
+		case ed25519.PublicKey:
+			add("EdDSA")
+		case []byte:
+			add("HS256", "HS384", "HS512")
+		}
+	}
+	return algorithms
+}
+
+// lookup returns the value of a dot separated claim path
+func lookup(claims map[string]any, path string) (any, bool) {
+	var current any = claims
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,229 @@
+package jwtauth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/jwtauth"
+)
+
+var (
+	rsaKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _  = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret    = []byte("secret")
+)
+
+func jwks(t *testing.T) []byte {
+	data, err := json.Marshal(map[string]any{
+		"keys": []map[string]any{
+			{
+				"kty": "RSA",
+				"kid": "rsa-1",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func pemKey(t *testing.T) []byte {
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "alice",
+		"iss": "https://issuer.example.com",
+		"aud": "api",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"realm_access": map[string]any{
+			"roles": []any{"admin"},
+		},
+	}
+}
+
+func with(claims jwt.MapClaims, k string, v any) jwt.MapClaims {
+	claims[k] = v
+	return claims
+}
+
+type fixture struct {
+	name       string
+	header     func(t *testing.T) string
+	expectCode codes.Code
+}
+
+var fixtures = []fixture{
+	{
+		name: "jwks rsa",
+		header: func(t *testing.T) string {
+			return "Bearer " + sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-1", validClaims())
+		},
+		expectCode: codes.OK,
+	},
+	{
+		name: "pem ecdsa",
+		header: func(t *testing.T) string {
+			return "Bearer " + sign(t, jwt.SigningMethodES256, ecKey, "", validClaims())
+		},
+		expectCode: codes.OK,
+	},
+	{
+		name: "hmac",
+		header: func(t *testing.T) string {
+			return "bearer " + sign(t, jwt.SigningMethodHS256, secret, "", validClaims())
+		},
+		expectCode: codes.OK,
+	},
+	{
+		name: "missing token",
+		header: func(t *testing.T) string {
+			return ""
+		},
+		expectCode: codes.Unauthenticated,
+	},
+	{
+		name: "invalid scheme",
+		header: func(t *testing.T) string {
+			return "Basic YWxpY2U6c2VjcmV0"
+		},
+		expectCode: codes.Unauthenticated,
+	},
+	{
+		name: "unknown kid",
+		header: func(t *testing.T) string {
+			return "Bearer " + sign(t, jwt.SigningMethodRS256, rsaKey, "rsa-2", validClaims())
+		},
+		expectCode: codes.Unauthenticated,
+	},
+	{
+		name: "wrong hmac secret",
+		header: func(t *testing.T) string {
+			return "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("other"), "", validClaims())
+		},
+		expectCode: codes.Unauthenticated,
+	},
+	{
+		name: "expired",
+		header: func(t *testing.T) string {
+			return "Bearer " + sign(t, jwt.SigningMethodHS256, secret, "", with(validClaims(), "exp", time.Now().Add(-time.Hour).Unix()))
+		},
+		expectCode: codes.Unauthenticated,
+	},
+	{
+		name: "missing exp",
+		header: func(t *testing.T) string {
+			claims := validClaims()
+			delete(claims, "exp")
+			return "Bearer " + sign(t, jwt.SigningMethodHS256, secret, "", claims)
+		},
+		expectCode: codes.Unauthenticated,
+	},
+	{
+		name: "not yet valid",
+		header: func(t *testing.T) string {
+			return "Bearer " + sign(t, jwt.SigningMethodHS256, secret, "", with(validClaims(), "nbf", time.Now().Add(time.Hour).Unix()))
+		},
+		expectCode: codes.Unauthenticated,
+	},
+	{
+		name: "wrong issuer",
+		header: func(t *testing.T) string {
+			return "Bearer " + sign(t, jwt.SigningMethodHS256, secret, "", with(validClaims(), "iss", "https://evil.example.com"))
+		},
+		expectCode: codes.Unauthenticated,
+	},
+	{
+		name: "wrong audience",
+		header: func(t *testing.T) string {
+			return "Bearer " + sign(t, jwt.SigningMethodHS256, secret, "", with(validClaims(), "aud", []string{"other"}))
+		},
+		expectCode: codes.Unauthenticated,
+	},
+	{
+		name: "none algorithm",
+		header: func(t *testing.T) string {
+			return "Bearer " + sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", validClaims())
+		},
+		expectCode: codes.Unauthenticated,
+	},
+}
+
+func TestNewUserExtractor(t *testing.T) {
+	extractor, err := jwtauth.NewUserExtractor(
+		jwtauth.WithJWKS(jwks(t)),
+		jwtauth.WithPEM(pemKey(t)),
+		jwtauth.WithHMACSecret(secret),
+		jwtauth.WithIssuer("https://issuer.example.com"),
+		jwtauth.WithAudience("api"),
+		jwtauth.WithClaimMapping(map[string]string{
+			"id":    "sub",
+			"roles": "realm_access.roles",
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", f.header(t)))
+			user, err := extractor(ctx)
+			if status.Code(err) != f.expectCode {
+				t.Fatalf("expected code %v, got %v", f.expectCode, err)
+			}
+			if err != nil {
+				return
+			}
+			claims := user.(map[string]any)
+			if claims["id"] != "alice" {
+				t.Fatalf("expected mapped id claim, got %v", claims["id"])
+			}
+			if roles, ok := claims["roles"].([]any); !ok || len(roles) != 1 || roles[0] != "admin" {
+				t.Fatalf("expected mapped roles claim, got %v", claims["roles"])
+			}
+		})
+	}
+}
+
+func TestNewUserExtractor_NoKeys(t *testing.T) {
+	if _, err := jwtauth.NewUserExtractor(); err == nil {
+		t.Fatal("expected an error without keys")
+	}
+}
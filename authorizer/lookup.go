@@ -0,0 +1,99 @@
+package authorizer
+
+import (
+	"reflect"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// LookupField returns the value of a dot separated field path (for example "org.id") of a user or request. Maps,
+// structs (case-insensitive field names, underscores are ignored) and proto messages (proto or json field names) are
+// supported. Enums are returned as their value names and repeated proto fields as []any
+func LookupField(v any, path string) (any, bool) {
+	current := v
+	for _, part := range strings.Split(path, ".") {
+		var ok bool
+		if current, ok = lookupField(current, part); !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func lookupField(v any, name string) (any, bool) {
+	switch v := v.(type) {
+	case nil:
+		return nil, false
+	case map[string]any:
+		value, ok := v[name]
+		return value, ok
+	case map[string]string:
+		value, ok := v[name]
+		return value, ok
+	case proto.Message:
+		m := v.ProtoReflect()
+		fields := m.Descriptor().Fields()
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+		if fd == nil || fd.IsMap() {
+			return nil, false
+		}
+		if fd.IsList() {
+			list := m.Get(fd).List()
+			values := make([]any, list.Len())
+			for i := range values {
+				values[i] = protoFieldValue(fd, list.Get(i))
+			}
+			return values, true
+		}
+		if fd.Message() != nil && !m.Has(fd) {
+			return nil, false
+		}
+		return protoFieldValue(fd, m.Get(fd)), true
+	}
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		value := rv.MapIndex(reflect.ValueOf(name).Convert(rv.Type().Key()))
+		if !value.IsValid() {
+			return nil, false
+		}
+		return value.Interface(), true
+	case reflect.Struct:
+		value := rv.FieldByNameFunc(func(field string) bool {
+			return strings.EqualFold(field, strings.ReplaceAll(name, "_", ""))
+		})
+		if !value.IsValid() || !value.CanInterface() {
+			return nil, false
+		}
+		return value.Interface(), true
+	}
+	return nil, false
+}
+
+func protoFieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch {
+	case fd.Message() != nil:
+		return v.Message().Interface()
+	case fd.Enum() != nil:
+		if value := fd.Enum().Values().ByNumber(v.Enum()); value != nil {
+			return string(value.Name())
+		}
+		return int32(v.Enum())
+	default:
+		return v.Interface()
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"strings"
 	"text/template"
 
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/autom8ter/proto/gen/authorize"
 
 	"github.com/storm-blue/protoc-gen-authorize/authorizer"
@@ -15,9 +17,17 @@ import (
 // Opt is a functional option for configuring a MatchAuthorizer
 type Opt func(*MatchAuthorizer)
 
+// WithTracerProvider enables tracing - a span named "authorize.evaluate" is created for every call to AuthorizeMethod
+func WithTracerProvider(tp trace.TracerProvider) Opt {
+	return func(a *MatchAuthorizer) {
+		a.tracerProvider = tp
+	}
+}
+
 // MatchAuthorizer is a javascript vm that uses javascript expressions to authorize grpc requests
 type MatchAuthorizer struct {
-	rules map[string]*authorize.RuleSet
+	rules          map[string]*authorize.RuleSet
+	tracerProvider trace.TracerProvider
 }
 
 // NewMatchAuthorizer returns a new MatchAuthorizer. The rules map is a map of method names to RuleSets. The RuleSets are used to
@@ -35,7 +45,17 @@ func NewMatchAuthorizer(rules map[string]*authorize.RuleSet, opts ...Opt) (*Matc
 
 // AuthorizeMethod authorizes a gRPC method the RuleExecutionParams and returns a boolean representing whether the
 // request is authorized or not.
-func (a *MatchAuthorizer) AuthorizeMethod(_ context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+func (a *MatchAuthorizer) AuthorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	if a.tracerProvider != nil {
+		return authorizer.TraceEvaluation(ctx, a.tracerProvider, "match", method, func(ctx context.Context) (bool, error) {
+			return a.authorizeMethod(ctx, method, params)
+		})
+	}
+	return a.authorizeMethod(ctx, method, params)
+}
+
+func (a *MatchAuthorizer) authorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	authorizer.RecordBackend(ctx, "match")
 	// return false if no rules exist for the method
 	rules, ok := a.rules[method]
 	if !ok {
@@ -59,10 +79,15 @@ func (a *MatchAuthorizer) AuthorizeMethod(_ context.Context, method string, para
 	}
 
 	data := map[string]interface{}{
-		"metadata": metaMap,
-		"request":  params.Request,
-		"user":     params.User,
-		"rule":     rules,
+		"metadata":           metaMap,
+		"request":            params.Request,
+		"user":               params.User,
+		"rule":               rules,
+		"method":             method,
+		"peer":               params.PeerMap(),
+		"authority":          params.Authority,
+		"deadline_remaining": params.DeadlineRemaining(),
+		"now":                params.EvaluationTime(),
 	}
 
 	expressions := getExpressions(rules)
@@ -71,7 +96,30 @@ func (a *MatchAuthorizer) AuthorizeMethod(_ context.Context, method string, para
 		return false, err
 	}
 
-	return permissionsMatch(needPermissions, permissions)
+	for i, needPermission := range needPermissions {
+		authorizer.RecordRuleEvaluated(ctx)
+		match, err := permissionsMatch([]string{needPermission}, permissions)
+		if err != nil {
+			return false, err
+		}
+		if match {
+			authorizer.RecordRuleMatched(ctx, i)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Validate parses the rule templates of every method and returns the first error
+func (a *MatchAuthorizer) Validate() error {
+	for method, rules := range a.rules {
+		for _, expression := range getExpressions(rules) {
+			if err := IsValidExpression(expression); err != nil {
+				return fmt.Errorf("%s: authorizer: invalid expression: %w", method, err)
+			}
+		}
+	}
+	return nil
 }
 
 func IsValidExpression(expression string) error {
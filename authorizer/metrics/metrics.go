@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+// Opt is a functional option for configuring Metrics
+type Opt func(*options)
+
+type options struct {
+	namespace string
+	buckets   []float64
+	methods   map[string]struct{}
+}
+
+// unknownMethod is the method label of methods that aren't known (see WithMethods)
+const unknownMethod = "unknown"
+
+// WithNamespace sets the namespace prefixed to the metric names
+func WithNamespace(namespace string) Opt {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithMethods adds methods that are recorded with their own method label. Methods of the grpc services registered in
+// the binary (protoregistry.GlobalFiles) are always known. Other methods - for example arbitrary paths sent to the
+// http or ext_authz adapters - are recorded as "unknown" so that clients can't create unbounded label values
+func WithMethods(methods ...string) Opt {
+	return func(o *options) {
+		for _, method := range methods {
+			o.methods[method] = struct{}{}
+		}
+	}
+}
+
+// WithBuckets sets the buckets (in seconds) of the evaluation latency histogram
+func WithBuckets(buckets ...float64) Opt {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// Metrics is a prometheus.Collector that records authorization decisions made by the interceptors.
+// Labels are limited to the grpc method, the decision and the backend to keep cardinality under control. Methods
+// that aren't known are recorded as "unknown" (see WithMethods)
+type Metrics struct {
+	methods       map[string]struct{}
+	decisions     *prometheus.CounterVec
+	latency       *prometheus.HistogramVec
+	disagreements *prometheus.CounterVec
+}
+
+// New returns a new Metrics collector. It must be registered with a prometheus.Registerer to be exported
+func New(opts ...Opt) *Metrics {
+	o := &options{
+		buckets: []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1},
+		methods: map[string]struct{}{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Metrics{
+		methods: o.methods,
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "authorize_decisions_total",
+			Help:      "Total number of authorization decisions by grpc method and decision (allow, deny, error).",
+		}, []string{"method", "decision"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.namespace,
+			Name:      "authorize_evaluation_duration_seconds",
+			Help:      "Latency of authorization decisions by backend.",
+			Buckets:   o.buckets,
+		}, []string{"backend"}),
+		disagreements: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "authorize_shadow_disagreements_total",
+			Help:      "Total number of decisions where the shadow authorizer disagreed with the enforced authorizer by grpc method.",
+		}, []string{"method"}),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.decisions.Describe(ch)
+	m.latency.Describe(ch)
+	m.disagreements.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.decisions.Collect(ch)
+	m.latency.Collect(ch)
+	m.disagreements.Collect(ch)
+}
+
+// Observe records a decision. It is an authorizer.DecisionHook
+func (m *Metrics) Observe(_ context.Context, d *authorizer.Decision) {
+	decision := "deny"
+	switch {
+	case d.Err != nil:
+		decision = "error"
+	case d.Allowed:
+		decision = "allow"
+	}
+	m.decisions.WithLabelValues(m.method(d.Method), decision).Inc()
+	backend := d.Backend
+	if backend == "" {
+		backend = "unknown"
+	}
+	m.latency.WithLabelValues(backend).Observe(d.Latency.Seconds())
+}
+
+// ObserveShadow records a disagreement between the enforced and the shadow authorizer. It is an authorizer.ShadowHook
+func (m *Metrics) ObserveShadow(_ context.Context, r *authorizer.ShadowResult) {
+	m.disagreements.WithLabelValues(m.method(r.Method)).Inc()
+}
+
+// method returns the method label of a method
+func (m *Metrics) method(method string) string {
+	if _, ok := m.methods[method]; ok {
+		return method
+	}
+	if _, ok := authorizer.RequestType(method); ok {
+		return method
+	}
+	return unknownMethod
+}
+
+// WithMetrics returns an interceptor option that records every authorization decision in the given Metrics
+func WithMetrics(m *Metrics) authorizer.Opt {
+	return authorizer.WithDecisionHook(m.Observe)
+}
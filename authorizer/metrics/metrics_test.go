@@ -0,0 +1,93 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/metrics"
+)
+
+func handler(ctx context.Context, req any) (any, error) {
+	return "ok", nil
+}
+
+func TestWithMetrics(t *testing.T) {
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Service/Method": {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "user.IsSuperUser == true",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := metrics.New(metrics.WithNamespace("test"), metrics.WithMethods("/example.Service/Method"))
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(m)
+
+	var user any
+	interceptor := authorizer.UnaryServerInterceptor(authz,
+		authorizer.WithUserExtractor(func(ctx context.Context) (any, error) {
+			if user == nil {
+				return nil, errors.New("no user")
+			}
+			return user, nil
+		}),
+		metrics.WithMetrics(m),
+	)
+	info := &grpc.UnaryServerInfo{FullMethod: "/example.Service/Method"}
+
+	user = map[string]any{"IsSuperUser": true}
+	if _, err := interceptor(context.Background(), nil, info, handler); err != nil {
+		t.Fatal(err)
+	}
+	user = map[string]any{"IsSuperUser": false}
+	for i := 0; i < 2; i++ {
+		if _, err := interceptor(context.Background(), nil, info, handler); err == nil {
+			t.Fatal("expected permission denied")
+		}
+	}
+	user = nil
+	if _, err := interceptor(context.Background(), nil, info, handler); err == nil {
+		t.Fatal("expected error")
+	}
+	// methods that aren't known share the unknown label, methods of registered services are known
+	user = map[string]any{"IsSuperUser": true}
+	for _, method := range []string{"/example.Service/Random1", "/x/Random2", healthpb.Health_Check_FullMethodName} {
+		_, _ = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+
+	expect := `
+# HELP test_authorize_decisions_total Total number of authorization decisions by grpc method and decision (allow, deny, error).
+# TYPE test_authorize_decisions_total counter
+test_authorize_decisions_total{decision="allow",method="/example.Service/Method"} 1
+test_authorize_decisions_total{decision="deny",method="/example.Service/Method"} 2
+test_authorize_decisions_total{decision="error",method="/example.Service/Method"} 1
+test_authorize_decisions_total{decision="deny",method="/grpc.health.v1.Health/Check"} 1
+test_authorize_decisions_total{decision="deny",method="unknown"} 1
+test_authorize_decisions_total{decision="allow",method="unknown"} 1
+`
+	if err := testutil.CollectAndCompare(m, strings.NewReader(expect), "test_authorize_decisions_total"); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.CollectAndCount(m, "test_authorize_evaluation_duration_seconds"); got != 2 {
+		t.Fatalf("expected latency histograms for 2 backends (cel, unknown), got %d", got)
+	}
+	if _, err := registry.Gather(); err != nil {
+		t.Fatal(err)
+	}
+}
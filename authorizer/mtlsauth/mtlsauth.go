@@ -0,0 +1,88 @@
+package mtlsauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+// UserExtractor is an authorizer.UserExtractor that returns the verified client certificate of an mTLS connection as
+// the user. The server must be configured to require and verify client certificates
+// (tls.RequireAndVerifyClientCert). It returns an Unauthenticated error if there is no verified certificate.
+// See UserFromCertificate for the fields of the user
+func UserExtractor(ctx context.Context) (any, error) {
+	cert, ok := PeerCertificate(ctx)
+	if !ok {
+		return nil, authorizer.Unauthenticated("authorizer: no verified client certificate")
+	}
+	return UserFromCertificate(cert), nil
+}
+
+// PeerCertificate returns the verified client certificate of the grpc peer in the context
+func PeerCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, false
+	}
+	chains := info.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil, false
+	}
+	return chains[0][0], true
+}
+
+// UserFromCertificate converts a certificate to a user map with the fields:
+//   - subject: the subject distinguished name
+//   - common_name, organization, organizational_unit: subject attributes
+//   - issuer: the issuer distinguished name
+//   - serial_number: the decimal serial number
+//   - dns_names, uris, email_addresses, ip_addresses: the subject alternative names
+//   - spiffe_id: the first spiffe:// URI SAN, or an empty string
+//   - fingerprint: the hex encoded SHA-256 fingerprint of the certificate
+func UserFromCertificate(cert *x509.Certificate) map[string]any {
+	uris := make([]string, 0, len(cert.URIs))
+	spiffeID := ""
+	for _, u := range cert.URIs {
+		uris = append(uris, u.String())
+		if spiffeID == "" && u.Scheme == "spiffe" {
+			spiffeID = u.String()
+		}
+	}
+	ips := make([]string, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	return map[string]any{
+		"subject":             cert.Subject.String(),
+		"common_name":         cert.Subject.CommonName,
+		"organization":        nonNil(cert.Subject.Organization),
+		"organizational_unit": nonNil(cert.Subject.OrganizationalUnit),
+		"issuer":              cert.Issuer.String(),
+		"serial_number":       cert.SerialNumber.String(),
+		"dns_names":           nonNil(cert.DNSNames),
+		"uris":                uris,
+		"email_addresses":     nonNil(cert.EmailAddresses),
+		"ip_addresses":        ips,
+		"spiffe_id":           spiffeID,
+		"fingerprint":         hex.EncodeToString(fingerprint[:]),
+	}
+}
+
+// nonNil returns an empty slice for nil slices so that rules can use list functions on every field
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
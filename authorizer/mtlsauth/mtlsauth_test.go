@@ -0,0 +1,147 @@
+package mtlsauth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/mtlsauth"
+)
+
+// newCertificate returns a client certificate signed by a self-signed CA
+func newCertificate(t *testing.T) (leaf *x509.Certificate, ca *x509.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err = x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spiffeID, _ := url.Parse("spiffe://prod/ns/billing/sa/api")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "billing-api", Organization: []string{"example"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"billing.prod.svc"},
+		URIs:         []*url.URL{spiffeID},
+		IPAddresses:  []net.IP{net.IPv4(10, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf, ca
+}
+
+func peerContext(state tls.ConnectionState) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234},
+		AuthInfo: credentials.TLSInfo{State: state},
+	})
+}
+
+func TestUserExtractor(t *testing.T) {
+	leaf, ca := newCertificate(t)
+	user, err := mtlsauth.UserExtractor(peerContext(tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{leaf},
+		VerifiedChains:   [][]*x509.Certificate{{leaf, ca}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := user.(map[string]any)
+	fingerprint := sha256.Sum256(leaf.Raw)
+	expect := map[string]any{
+		"common_name":   "billing-api",
+		"spiffe_id":     "spiffe://prod/ns/billing/sa/api",
+		"serial_number": "42",
+		"fingerprint":   hex.EncodeToString(fingerprint[:]),
+	}
+	for k, v := range expect {
+		if fields[k] != v {
+			t.Fatalf("expected %s to be %v, got %v", k, v, fields[k])
+		}
+	}
+
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.BillingService/Charge": {
+			Rules: []*authorize.Rule{
+				{
+					Expression: "user.spiffe_id == 'spiffe://prod/ns/billing/sa/api' && 'billing.prod.svc' in user.dns_names",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed, err := authz.AuthorizeMethod(context.Background(), "/example.BillingService/Charge", &authorizer.RuleExecutionParams{
+		User: user,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Fatal("expected the certificate to be authorized")
+	}
+}
+
+func TestUserExtractor_Unverified(t *testing.T) {
+	leaf, _ := newCertificate(t)
+	for name, ctx := range map[string]context.Context{
+		"no peer":     context.Background(),
+		"no tls":      peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{}}),
+		"no verified": peerContext(tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := mtlsauth.UserExtractor(ctx); status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("expected unauthenticated, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package authorizer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Peer describes the client of a request
+type Peer struct {
+	// Address is the address of the client (host:port for tcp connections)
+	Address string
+	// IP is the ip of the client (empty if the address is not an ip address)
+	IP string
+	// Port is the port of the client (0 if unknown)
+	Port int
+	// AuthType is the transport security of the connection ("tls", "insecure"...) or an empty string
+	AuthType string
+	// TLS is the tls connection state (nil if the connection is not a tls connection)
+	TLS *PeerTLS
+}
+
+// PeerTLS describes the tls connection of a client
+type PeerTLS struct {
+	// Version is the tls version ("TLS 1.3")
+	Version string
+	// CipherSuite is the negotiated cipher suite
+	CipherSuite string
+	// ServerName is the server name requested by the client (SNI)
+	ServerName string
+	// Verified is true if the client certificate was verified
+	Verified bool
+	// Subject is the subject of the client certificate
+	Subject string
+	// DNSNames are the dns names of the client certificate
+	DNSNames []string
+	// URIs are the uri SANs of the client certificate (spiffe ids)
+	URIs []string
+}
+
+// PeerFromContext returns the client of a grpc request, or nil if the context has no peer
+func PeerFromContext(ctx context.Context) *Peer {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info := &Peer{}
+	if p.Addr != nil {
+		info.Address = p.Addr.String()
+		if host, port, err := net.SplitHostPort(info.Address); err == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				info.IP = ip.String()
+			}
+			info.Port, _ = strconv.Atoi(port)
+		}
+	}
+	if p.AuthInfo != nil {
+		info.AuthType = p.AuthInfo.AuthType()
+	}
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		info.TLS = peerTLS(tlsInfo.State)
+	}
+	return info
+}
+
+func peerTLS(state tls.ConnectionState) *PeerTLS {
+	info := &PeerTLS{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+		Verified:    len(state.VerifiedChains) > 0,
+	}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		info.Subject = cert.Subject.String()
+		info.DNSNames = cert.DNSNames
+		for _, u := range cert.URIs {
+			info.URIs = append(info.URIs, u.String())
+		}
+	}
+	return info
+}
+
+// authority returns the :authority pseudo header of the request
+func authority(md metadata.MD) string {
+	if values := md.Get(":authority"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// PeerMap returns the peer as the map used for the peer expression variable:
+//
+//	{address, ip, port, auth_type, tls: {version, cipher_suite, server_name, verified, subject, dns_names, uris}}
+//
+// Every key is present (with empty values if the peer or the tls connection is unknown) so that expressions don't
+// have to check for missing keys
+func (p *RuleExecutionParams) PeerMap() map[string]any {
+	info := p.Peer
+	if info == nil {
+		info = &Peer{}
+	}
+	tlsInfo := info.TLS
+	if tlsInfo == nil {
+		tlsInfo = &PeerTLS{}
+	}
+	return map[string]any{
+		"address":   info.Address,
+		"ip":        info.IP,
+		"port":      info.Port,
+		"auth_type": info.AuthType,
+		"tls": map[string]any{
+			"version":      tlsInfo.Version,
+			"cipher_suite": tlsInfo.CipherSuite,
+			"server_name":  tlsInfo.ServerName,
+			"verified":     tlsInfo.Verified,
+			"subject":      tlsInfo.Subject,
+			"dns_names":    nonNilStrings(tlsInfo.DNSNames),
+			"uris":         nonNilStrings(tlsInfo.URIs),
+		},
+	}
+}
+
+// EvaluationTime returns the time the request is evaluated
+func (p *RuleExecutionParams) EvaluationTime() time.Time {
+	if p.Now.IsZero() {
+		return time.Now()
+	}
+	return p.Now
+}
+
+// DeadlineRemaining returns the time left until the deadline of the request, or 0 if there is no deadline
+func (p *RuleExecutionParams) DeadlineRemaining() time.Duration {
+	if p.Deadline.IsZero() {
+		return 0
+	}
+	return p.Deadline.Sub(p.EvaluationTime())
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,130 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/reload"
+)
+
+// DirSource is a Source that loads the rule files (json, yaml or textproto - see reload.Format) of a directory, for
+// example a kubernetes ConfigMap volume. Hidden files and subdirectories are ignored. A method may only be defined
+// in one file
+type DirSource struct {
+	dir      string
+	debounce time.Duration
+}
+
+// NewDirSource returns a DirSource for the directory
+func NewDirSource(dir string) *DirSource {
+	return &DirSource{
+		dir:      dir,
+		debounce: 100 * time.Millisecond,
+	}
+}
+
+// Load implements Source
+func (s *DirSource) Load(ctx context.Context) (map[string]*authorize.RuleSet, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("authorizer: failed to read rules directory: %v", err.Error())
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	rules := map[string]*authorize.RuleSet{}
+	definedIn := map[string]string{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		// stat follows symlinks (ConfigMap volumes link the files to a hidden data directory)
+		if info, err := os.Stat(path); err != nil || info.IsDir() {
+			continue
+		}
+		if _, err := reload.FormatFromPath(path); err != nil {
+			continue
+		}
+		fileRules, err := reload.LoadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		for method, ruleSet := range fileRules {
+			if other, ok := definedIn[method]; ok {
+				return nil, fmt.Errorf("authorizer: rules for %s are defined in %s and %s", method, other, entry.Name())
+			}
+			definedIn[method] = entry.Name()
+			rules[method] = ruleSet
+		}
+	}
+	return rules, nil
+}
+
+// Watch implements Source. The current rules are reported when Watch starts, later updates only if the rules changed
+func (s *DirSource) Watch(ctx context.Context, update func(rules map[string]*authorize.RuleSet, err error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("authorizer: failed to create file watcher: %v", err.Error())
+	}
+	defer watcher.Close()
+	if err := watcher.Add(s.dir); err != nil {
+		return fmt.Errorf("authorizer: failed to watch rules directory: %v", err.Error())
+	}
+	previous, err := s.Load(ctx)
+	update(previous, err)
+	timer := time.NewTimer(s.debounce)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return fmt.Errorf("authorizer: file watcher closed")
+			}
+			timer.Reset(s.debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return fmt.Errorf("authorizer: file watcher closed")
+			}
+			update(nil, fmt.Errorf("authorizer: file watcher error: %v", err.Error()))
+		case <-timer.C:
+			rules, err := s.Load(ctx)
+			if err != nil {
+				update(nil, err)
+				continue
+			}
+			if Equal(previous, rules) {
+				continue
+			}
+			previous = rules
+			update(rules, nil)
+		}
+	}
+}
+
+// Equal returns true if both rule maps define the same rules
+func Equal(a, b map[string]*authorize.RuleSet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for method, rules := range a {
+		other, ok := b[method]
+		if !ok || !proto.Equal(rules, other) {
+			return false
+		}
+	}
+	return true
+}
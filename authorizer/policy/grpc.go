@@ -0,0 +1,310 @@
+package policy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/autom8ter/proto/gen/authorize"
+)
+
+// ServiceName is the full name of the policy distribution service (see policy.proto)
+const ServiceName = "protoc_gen_authorize.policy.PolicyService"
+
+const (
+	getPolicyMethod   = "/" + ServiceName + "/GetPolicy"
+	watchPolicyMethod = "/" + ServiceName + "/WatchPolicy"
+)
+
+// policyFile is the descriptor of policy.proto. It is built at runtime so that the service doesn't require generated
+// code - the messages are handled with dynamicpb and are wire compatible with clients generated from policy.proto
+var policyFile = func() protoreflect.FileDescriptor {
+	ruleSet := (&authorize.RuleSet{}).ProtoReflect().Descriptor()
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    label.Enum(),
+			Type:     typ.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("protoc_gen_authorize/policy/policy.proto"),
+		Package:    proto.String("protoc_gen_authorize.policy"),
+		Dependency: []string{ruleSet.ParentFile().Path()},
+		Syntax:     proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("PolicyRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("version", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+				},
+			},
+			{
+				Name: proto.String("Policy"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("version", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+					field("rules", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_LABEL_REPEATED, ".protoc_gen_authorize.policy.Policy.RulesEntry"),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("RulesEntry"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, optional, ""),
+							field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, optional, "."+string(ruleSet.FullName())),
+						},
+						Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+					},
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name: proto.String("PolicyService"),
+				Method: []*descriptorpb.MethodDescriptorProto{
+					{
+						Name:       proto.String("GetPolicy"),
+						InputType:  proto.String(".protoc_gen_authorize.policy.PolicyRequest"),
+						OutputType: proto.String(".protoc_gen_authorize.policy.Policy"),
+					},
+					{
+						Name:            proto.String("WatchPolicy"),
+						InputType:       proto.String(".protoc_gen_authorize.policy.PolicyRequest"),
+						OutputType:      proto.String(".protoc_gen_authorize.policy.Policy"),
+						ServerStreaming: proto.Bool(true),
+					},
+				},
+			},
+		},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(fmt.Sprintf("authorizer: failed to build policy descriptor: %v", err))
+	}
+	return file
+}()
+
+var (
+	policyRequestDescriptor = policyFile.Messages().ByName("PolicyRequest")
+	policyDescriptor        = policyFile.Messages().ByName("Policy")
+)
+
+func newPolicyRequest(version string) *dynamicpb.Message {
+	msg := dynamicpb.NewMessage(policyRequestDescriptor)
+	msg.Set(policyRequestDescriptor.Fields().ByName("version"), protoreflect.ValueOfString(version))
+	return msg
+}
+
+func newPolicy(rules map[string]*authorize.RuleSet) *dynamicpb.Message {
+	msg := dynamicpb.NewMessage(policyDescriptor)
+	msg.Set(policyDescriptor.Fields().ByName("version"), protoreflect.ValueOfString(Version(rules)))
+	m := msg.Mutable(policyDescriptor.Fields().ByName("rules")).Map()
+	for method, ruleSet := range rules {
+		if ruleSet == nil {
+			continue
+		}
+		m.Set(protoreflect.ValueOfString(method).MapKey(), protoreflect.ValueOfMessage(ruleSet.ProtoReflect()))
+	}
+	return msg
+}
+
+func policyRules(msg *dynamicpb.Message) (map[string]*authorize.RuleSet, error) {
+	rules := map[string]*authorize.RuleSet{}
+	var err error
+	msg.Get(policyDescriptor.Fields().ByName("rules")).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+		ruleSet, ok := v.Message().Interface().(*authorize.RuleSet)
+		if !ok {
+			// dynamic message - round trip through the wire format
+			ruleSet = &authorize.RuleSet{}
+			var b []byte
+			if b, err = proto.Marshal(v.Message().Interface()); err == nil {
+				err = proto.Unmarshal(b, ruleSet)
+			}
+			if err != nil {
+				return false
+			}
+		}
+		rules[k.String()] = ruleSet
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("authorizer: failed to decode policy: %v", err.Error())
+	}
+	return rules, nil
+}
+
+// Version returns a content hash of the rules. The policy service sends it with every policy so that clients can
+// log which policy is active
+func Version(rules map[string]*authorize.RuleSet) string {
+	methods := make([]string, 0, len(rules))
+	for method := range rules {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	h := sha256.New()
+	for _, method := range methods {
+		if rules[method] == nil {
+			continue
+		}
+		b, _ := proto.MarshalOptions{Deterministic: true}.Marshal(rules[method])
+		fmt.Fprintf(h, "%s\x00%d\x00", method, len(b))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Server serves the rules of a Source with the PolicyService so that many instances can share one central policy
+type Server struct {
+	source Source
+}
+
+// NewServer returns a Server for the source
+func NewServer(source Source) *Server {
+	return &Server{source: source}
+}
+
+// Register registers the PolicyService on the grpc server
+func (s *Server) Register(srv *grpc.Server) {
+	srv.RegisterService(&grpc.ServiceDesc{
+		ServiceName: ServiceName,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			{
+				MethodName: "GetPolicy",
+				Handler:    s.getPolicyHandler,
+			},
+		},
+		Streams: []grpc.StreamDesc{
+			{
+				StreamName:    "WatchPolicy",
+				Handler:       s.watchPolicyHandler,
+				ServerStreams: true,
+			},
+		},
+		Metadata: policyFile.Path(),
+	}, s)
+}
+
+func (s *Server) getPolicyHandler(_ any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	req := dynamicpb.NewMessage(policyRequestDescriptor)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		rules, err := s.source.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return newPolicy(rules), nil
+	}
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{
+		Server:     s,
+		FullMethod: getPolicyMethod,
+	}, handler)
+}
+
+// watchPolicyHandler sends the current policy (unless the client already has its version) and then every update of
+// the source. Updates that fail to load are not sent - clients keep their current policy
+func (s *Server) watchPolicyHandler(_ any, stream grpc.ServerStream) error {
+	req := dynamicpb.NewMessage(policyRequestDescriptor)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	version := req.Get(policyRequestDescriptor.Fields().ByName("version")).String()
+	first := true
+	var sendErr error
+	err := s.source.Watch(ctx, func(rules map[string]*authorize.RuleSet, err error) {
+		if sendErr != nil {
+			return
+		}
+		// the first update is the current policy
+		if first {
+			first = false
+			if err != nil {
+				sendErr = err
+				cancel()
+				return
+			}
+			if Version(rules) == version {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+		if sendErr = stream.SendMsg(newPolicy(rules)); sendErr != nil {
+			cancel()
+		}
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	return err
+}
+
+// GRPCSource is a Source that loads rules from a PolicyService
+type GRPCSource struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewGRPCSource returns a Source that loads rules from the PolicyService served on the connection
+func NewGRPCSource(conn grpc.ClientConnInterface) *GRPCSource {
+	return &GRPCSource{conn: conn}
+}
+
+// Load implements Source
+func (s *GRPCSource) Load(ctx context.Context) (map[string]*authorize.RuleSet, error) {
+	resp := dynamicpb.NewMessage(policyDescriptor)
+	if err := s.conn.Invoke(ctx, getPolicyMethod, newPolicyRequest(""), resp); err != nil {
+		return nil, fmt.Errorf("authorizer: failed to get policy: %v", err.Error())
+	}
+	return policyRules(resp)
+}
+
+// Watch implements Source. It returns an error when the stream breaks - Authorizer.Run watches again after the
+// retry interval
+func (s *GRPCSource) Watch(ctx context.Context, update func(rules map[string]*authorize.RuleSet, err error)) error {
+	stream, err := s.conn.NewStream(ctx, &grpc.StreamDesc{
+		StreamName:    "WatchPolicy",
+		ServerStreams: true,
+	}, watchPolicyMethod)
+	if err != nil {
+		return fmt.Errorf("authorizer: failed to watch policy: %v", err.Error())
+	}
+	if err := stream.SendMsg(newPolicyRequest("")); err != nil {
+		return fmt.Errorf("authorizer: failed to watch policy: %v", err.Error())
+	}
+	if err := stream.CloseSend(); err != nil {
+		return fmt.Errorf("authorizer: failed to watch policy: %v", err.Error())
+	}
+	for {
+		msg := dynamicpb.NewMessage(policyDescriptor)
+		if err := stream.RecvMsg(msg); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("authorizer: policy stream closed: %v", err.Error())
+		}
+		update(policyRules(msg))
+	}
+}
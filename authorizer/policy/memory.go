@@ -0,0 +1,69 @@
+package policy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/autom8ter/proto/gen/authorize"
+)
+
+// MemorySource is a Source that holds rules in memory. Rules are updated with Set - for example by an admin API or
+// in tests
+type MemorySource struct {
+	mu       sync.Mutex
+	rules    map[string]*authorize.RuleSet
+	watchers map[chan struct{}]struct{}
+}
+
+// NewMemorySource returns a MemorySource with the given initial rules
+func NewMemorySource(rules map[string]*authorize.RuleSet) *MemorySource {
+	return &MemorySource{
+		rules:    rules,
+		watchers: map[chan struct{}]struct{}{},
+	}
+}
+
+// Set replaces the rules and notifies the watchers
+func (s *MemorySource) Set(rules map[string]*authorize.RuleSet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = rules
+	for ch := range s.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// an update is already pending - the watcher will read the latest rules
+		}
+	}
+}
+
+// Load implements Source
+func (s *MemorySource) Load(ctx context.Context) (map[string]*authorize.RuleSet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rules, nil
+}
+
+// Watch implements Source
+func (s *MemorySource) Watch(ctx context.Context, update func(rules map[string]*authorize.RuleSet, err error)) error {
+	ch := make(chan struct{}, 1)
+	// report the current rules first
+	ch <- struct{}{}
+	s.mu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, ch)
+		s.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ch:
+			rules, err := s.Load(ctx)
+			update(rules, err)
+		}
+	}
+}
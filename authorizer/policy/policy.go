@@ -0,0 +1,191 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/reload"
+)
+
+// Source provides rules that can change at runtime - for example a directory of rule files or a central policy
+// service
+type Source interface {
+	// Load returns the current rules
+	Load(ctx context.Context) (map[string]*authorize.RuleSet, error)
+	// Watch calls update with the current rules when it starts and then with the new rules whenever they change until
+	// the context is canceled, so that changes made while nobody was watching aren't lost. Errors loading an update
+	// are passed to update with nil rules. Watch returns an error if the source can't be watched anymore
+	// (for example if the connection to a policy service is lost)
+	Watch(ctx context.Context, update func(rules map[string]*authorize.RuleSet, err error)) error
+}
+
+// Opt is a functional option for configuring an Authorizer
+type Opt func(*Authorizer)
+
+// WithBaseline sets baseline rules - for example the map generated by protoc-gen-authorize. The rules of the source
+// are merged over the baseline: methods defined by the source override the baseline and other methods keep their
+// baseline rules. If the source can't be loaded initially the baseline is used
+func WithBaseline(rules map[string]*authorize.RuleSet) Opt {
+	return func(a *Authorizer) {
+		a.baseline = rules
+	}
+}
+
+// WithOnUpdate sets a callback that is called after new rules have been swapped in
+func WithOnUpdate(fn func(rules map[string]*authorize.RuleSet)) Opt {
+	return func(a *Authorizer) {
+		a.onUpdate = fn
+	}
+}
+
+// WithOnError sets a callback that is called when the source fails or new rules are invalid. The previous rules stay
+// active
+func WithOnError(fn func(err error)) Opt {
+	return func(a *Authorizer) {
+		a.onError = fn
+	}
+}
+
+// WithRetryInterval sets how long Run waits before watching the source again after Watch failed (default 1s)
+func WithRetryInterval(d time.Duration) Opt {
+	return func(a *Authorizer) {
+		a.retryInterval = d
+	}
+}
+
+// Authorizer is an Authorizer whose rules come from a Source. New rules are validated and compiled with the factory
+// before they are swapped in atomically, in the same way as reload.Reloadable
+type Authorizer struct {
+	source        Source
+	factory       reload.Factory
+	baseline      map[string]*authorize.RuleSet
+	onUpdate      func(rules map[string]*authorize.RuleSet)
+	onError       func(err error)
+	retryInterval time.Duration
+	mu            sync.Mutex
+	current       atomic.Pointer[state]
+}
+
+type state struct {
+	// source holds the rules of the source before they were merged over the baseline
+	source     map[string]*authorize.RuleSet
+	rules      map[string]*authorize.RuleSet
+	authorizer authorizer.Authorizer
+}
+
+// New loads the rules of the source and returns an Authorizer that uses the factory (for example
+// cel.NewCelAuthorizer) to compile them. Call Run to apply updates of the source
+func New(ctx context.Context, source Source, factory reload.Factory, opts ...Opt) (*Authorizer, error) {
+	a := &Authorizer{
+		source:        source,
+		factory:       factory,
+		retryInterval: time.Second,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	rules, err := source.Load(ctx)
+	if err == nil {
+		err = a.apply(rules)
+	}
+	if err != nil {
+		if a.baseline == nil {
+			return nil, err
+		}
+		a.reportError(err)
+		if err := a.apply(nil); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// AuthorizeMethod implements authorizer.Authorizer with the current rules
+func (a *Authorizer) AuthorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	return a.current.Load().authorizer.AuthorizeMethod(ctx, method, params)
+}
+
+// Rules returns the current (merged) rules. They must not be modified
+func (a *Authorizer) Rules() map[string]*authorize.RuleSet {
+	return a.current.Load().rules
+}
+
+// Run watches the source and applies its updates until the context is canceled. If watching fails it is retried
+// after the retry interval. Updates are compared against the rules that were applied last, so changes made before
+// Run or while watching is retried are applied and unchanged rules aren't compiled again
+func (a *Authorizer) Run(ctx context.Context) error {
+	for {
+		err := a.source.Watch(ctx, func(rules map[string]*authorize.RuleSet, err error) {
+			if err == nil {
+				if current := a.current.Load(); current.source != nil && Equal(current.source, rules) {
+					return
+				}
+				err = a.apply(rules)
+			}
+			if err != nil {
+				a.reportError(err)
+			}
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			a.reportError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(a.retryInterval):
+		}
+	}
+}
+
+// apply merges the rules over the baseline, compiles them and swaps them in
+func (a *Authorizer) apply(rules map[string]*authorize.RuleSet) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	merged := Merge(a.baseline, rules)
+	authz, err := a.factory(merged)
+	if err != nil {
+		return fmt.Errorf("authorizer: failed to create authorizer: %v", err.Error())
+	}
+	if v, ok := authz.(reload.Validator); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("authorizer: invalid rules: %v", err.Error())
+		}
+	}
+	a.current.Store(&state{
+		source:     rules,
+		rules:      merged,
+		authorizer: authz,
+	})
+	if a.onUpdate != nil {
+		a.onUpdate(merged)
+	}
+	return nil
+}
+
+func (a *Authorizer) reportError(err error) {
+	if a.onError != nil {
+		a.onError(err)
+	}
+}
+
+// Merge returns the rules of the overrides merged over the base rules. Methods defined by the overrides replace the
+// base rules of the method
+func Merge(base, overrides map[string]*authorize.RuleSet) map[string]*authorize.RuleSet {
+	merged := make(map[string]*authorize.RuleSet, len(base)+len(overrides))
+	for method, rules := range base {
+		merged[method] = rules
+	}
+	for method, rules := range overrides {
+		merged[method] = rules
+	}
+	return merged
+}
@@ -0,0 +1,263 @@
+package policy_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/policy"
+)
+
+const (
+	method      = "/example.Service/Method"
+	otherMethod = "/example.Service/Other"
+)
+
+func celFactory(rules map[string]*authorize.RuleSet) (authorizer.Authorizer, error) {
+	return cel.NewCelAuthorizer(rules)
+}
+
+func ruleSet(expression string) *authorize.RuleSet {
+	return &authorize.RuleSet{Rules: []*authorize.Rule{{Expression: expression}}}
+}
+
+func allowed(t *testing.T, authz authorizer.Authorizer, method string, role string) bool {
+	allow, err := authz.AuthorizeMethod(context.Background(), method, &authorizer.RuleExecutionParams{
+		User: map[string]any{"role": role},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return allow
+}
+
+// run starts watching the source and returns a channel that receives the rules of every update
+func run(t *testing.T, source policy.Source, opts ...policy.Opt) (*policy.Authorizer, <-chan map[string]*authorize.RuleSet) {
+	updates := make(chan map[string]*authorize.RuleSet, 10)
+	opts = append(opts, policy.WithRetryInterval(10*time.Millisecond), policy.WithOnUpdate(func(rules map[string]*authorize.RuleSet) {
+		updates <- rules
+	}))
+	authz, err := policy.New(context.Background(), source, celFactory, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-updates
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = authz.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return authz, updates
+}
+
+func waitForUpdate(t *testing.T, updates <-chan map[string]*authorize.RuleSet) {
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the rules to be updated")
+	}
+}
+
+func TestMemorySource(t *testing.T) {
+	source := policy.NewMemorySource(map[string]*authorize.RuleSet{
+		method: ruleSet("user.role == 'admin'"),
+	})
+	errs := make(chan error, 1)
+	authz, updates := run(t, source,
+		policy.WithBaseline(map[string]*authorize.RuleSet{
+			method:      ruleSet("false"),
+			otherMethod: ruleSet("user.role == 'viewer'"),
+		}),
+		policy.WithOnError(func(err error) {
+			errs <- err
+		}),
+	)
+	// the source overrides the baseline of method and the baseline of otherMethod stays active
+	if !allowed(t, authz, method, "admin") || !allowed(t, authz, otherMethod, "viewer") {
+		t.Fatal("expected the source to be merged over the baseline")
+	}
+	// wait for the watcher to be registered before updating
+	time.Sleep(50 * time.Millisecond)
+	source.Set(map[string]*authorize.RuleSet{
+		method: ruleSet("user.role == 'editor'"),
+	})
+	waitForUpdate(t, updates)
+	if allowed(t, authz, method, "admin") || !allowed(t, authz, method, "editor") {
+		t.Fatal("expected the new rules to be active")
+	}
+
+	// invalid rules are rejected and the previous rules stay active
+	source.Set(map[string]*authorize.RuleSet{
+		method: ruleSet("user.role =="),
+	})
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the error")
+	}
+	if !allowed(t, authz, method, "editor") {
+		t.Fatal("expected the previous rules to stay active")
+	}
+}
+
+func TestRun_ChangedBeforeWatch(t *testing.T) {
+	source := policy.NewMemorySource(map[string]*authorize.RuleSet{
+		method: ruleSet("user.role == 'admin'"),
+	})
+	updates := make(chan map[string]*authorize.RuleSet, 10)
+	authz, err := policy.New(context.Background(), source, celFactory, policy.WithOnUpdate(func(rules map[string]*authorize.RuleSet) {
+		updates <- rules
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-updates
+	// the rules change before Run watches the source
+	source.Set(map[string]*authorize.RuleSet{
+		method: ruleSet("user.role == 'editor'"),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = authz.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitForUpdate(t, updates)
+	if !allowed(t, authz, method, "editor") {
+		t.Fatal("expected the rules set before Run to be active")
+	}
+	// the current rules are reported when watching starts, but unchanged rules aren't applied again
+	select {
+	case <-updates:
+		t.Fatal("expected unchanged rules to be skipped")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestNew_Baseline(t *testing.T) {
+	source := policy.NewDirSource(filepath.Join(t.TempDir(), "missing"))
+	if _, err := policy.New(context.Background(), source, celFactory); err == nil {
+		t.Fatal("expected an error without a baseline")
+	}
+	authz, err := policy.New(context.Background(), source, celFactory, policy.WithBaseline(map[string]*authorize.RuleSet{
+		method: ruleSet("user.role == 'admin'"),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed(t, authz, method, "admin") {
+		t.Fatal("expected the baseline to be active")
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		tmp := filepath.Join(dir, "."+name+".tmp")
+		if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.yaml", method+":\n  rules:\n    - expression: user.role == 'admin'\n")
+	write("b.json", `{"/example.Service/Other": {"rules": [{"expression": "user.role == 'viewer'"}]}}`)
+	write("README.md", "ignored")
+
+	source := policy.NewDirSource(dir)
+	rules, err := source.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected rules for 2 methods, got %v", rules)
+	}
+
+	authz, updates := run(t, source)
+	time.Sleep(50 * time.Millisecond)
+	write("a.yaml", method+":\n  rules:\n    - expression: user.role == 'editor'\n")
+	waitForUpdate(t, updates)
+	if !allowed(t, authz, method, "editor") || !allowed(t, authz, otherMethod, "viewer") {
+		t.Fatal("expected the new rules to be active")
+	}
+
+	// a method defined in two files is ambiguous
+	write("c.json", `{"/example.Service/Method": {"rules": [{"expression": "true"}]}}`)
+	if _, err := source.Load(context.Background()); err == nil {
+		t.Fatal("expected an error for duplicate methods")
+	}
+}
+
+func TestGRPCSource(t *testing.T) {
+	source := policy.NewMemorySource(map[string]*authorize.RuleSet{
+		method: ruleSet("user.role == 'admin'"),
+	})
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	policy.NewServer(source).Register(srv)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := policy.NewGRPCSource(conn)
+	rules, err := client.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.Equal(rules, map[string]*authorize.RuleSet{method: ruleSet("user.role == 'admin'")}) {
+		t.Fatalf("unexpected rules: %v", rules)
+	}
+
+	authz, updates := run(t, client)
+	if !allowed(t, authz, method, "admin") {
+		t.Fatal("expected the rules of the server to be active")
+	}
+	source.Set(map[string]*authorize.RuleSet{
+		method: ruleSet("user.role == 'editor'"),
+	})
+	waitForUpdate(t, updates)
+	if !allowed(t, authz, method, "editor") {
+		t.Fatal("expected the new rules to be active")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	a := map[string]*authorize.RuleSet{method: ruleSet("true"), otherMethod: ruleSet("false")}
+	b := map[string]*authorize.RuleSet{otherMethod: ruleSet("false"), method: ruleSet("true")}
+	if policy.Version(a) != policy.Version(b) {
+		t.Fatal("expected the version to be independent of map order")
+	}
+	if policy.Version(a) == policy.Version(map[string]*authorize.RuleSet{method: ruleSet("true")}) {
+		t.Fatal("expected different rules to have different versions")
+	}
+}
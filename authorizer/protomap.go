@@ -0,0 +1,156 @@
+package authorizer
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// FieldNameFunc returns the key of a field when a message is converted to a map
+type FieldNameFunc func(fd protoreflect.FieldDescriptor) string
+
+// JSONFieldName uses the json name of fields (createdAt)
+func JSONFieldName(fd protoreflect.FieldDescriptor) string {
+	return fd.JSONName()
+}
+
+// ProtoFieldName uses the proto name of fields (created_at)
+func ProtoFieldName(fd protoreflect.FieldDescriptor) string {
+	return string(fd.Name())
+}
+
+// ProtoToMap converts a message to a map with protoreflect so that expression engines see the same structure as the
+// json encoding of the message (protojson), but with native values:
+//   - fields are keyed by name(fd) (JSONFieldName if nil)
+//   - scalar and list fields are always present (with their default values), message fields and oneof members only
+//     if they are set
+//   - enums are their value names
+//   - 64 bit integers are int64/uint64 and bytes are []byte (not strings)
+//   - google.protobuf.Timestamp and Duration are time.Time and time.Duration
+//   - google.protobuf.Struct, Value and ListValue are maps, values and slices, wrappers are their values
+//   - google.protobuf.Any is converted if its type is registered in protoregistry.GlobalTypes
+//
+// Unexported fields of the generated structs are never exposed
+func ProtoToMap(msg proto.Message, name FieldNameFunc) map[string]any {
+	if msg == nil || !msg.ProtoReflect().IsValid() {
+		return nil
+	}
+	if name == nil {
+		name = JSONFieldName
+	}
+	return messageToMap(msg.ProtoReflect(), name)
+}
+
+func messageToMap(m protoreflect.Message, name FieldNameFunc) map[string]any {
+	fields := m.Descriptor().Fields()
+	out := make(map[string]any, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if !m.Has(fd) && (fd.ContainingOneof() != nil || (fd.Message() != nil && !fd.IsList() && !fd.IsMap())) {
+			continue
+		}
+		out[name(fd)] = fieldValue(fd, m.Get(fd), name)
+	}
+	return out
+}
+
+func fieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, name FieldNameFunc) any {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		out := make([]any, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			out[i] = singularValue(fd, list.Get(i), name)
+		}
+		return out
+	case fd.IsMap():
+		m := v.Map()
+		if fd.MapKey().Kind() == protoreflect.StringKind {
+			out := make(map[string]any, m.Len())
+			m.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				out[k.String()] = singularValue(fd.MapValue(), v, name)
+				return true
+			})
+			return out
+		}
+		out := make(map[any]any, m.Len())
+		m.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			out[k.Interface()] = singularValue(fd.MapValue(), v, name)
+			return true
+		})
+		return out
+	default:
+		return singularValue(fd, v, name)
+	}
+}
+
+func singularValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, name FieldNameFunc) any {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return int64(v.Enum())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return messageValue(v.Message(), name)
+	default:
+		return v.Interface()
+	}
+}
+
+// messageValue converts well-known types to native values and other messages to maps
+func messageValue(m protoreflect.Message, name FieldNameFunc) any {
+	desc := m.Descriptor()
+	switch desc.FullName() {
+	case "google.protobuf.Timestamp":
+		return time.Unix(m.Get(desc.Fields().ByName("seconds")).Int(), m.Get(desc.Fields().ByName("nanos")).Int()).UTC()
+	case "google.protobuf.Duration":
+		return time.Duration(m.Get(desc.Fields().ByName("seconds")).Int())*time.Second +
+			time.Duration(m.Get(desc.Fields().ByName("nanos")).Int())
+	case "google.protobuf.Struct":
+		out := map[string]any{}
+		m.Get(desc.Fields().ByName("fields")).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			out[k.String()] = messageValue(v.Message(), name)
+			return true
+		})
+		return out
+	case "google.protobuf.ListValue":
+		list := m.Get(desc.Fields().ByName("values")).List()
+		out := make([]any, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			out[i] = messageValue(list.Get(i).Message(), name)
+		}
+		return out
+	case "google.protobuf.Value":
+		fd := m.WhichOneof(desc.Oneofs().ByName("kind"))
+		if fd == nil || fd.Name() == "null_value" {
+			return nil
+		}
+		if fd.Message() != nil {
+			return messageValue(m.Get(fd).Message(), name)
+		}
+		return m.Get(fd).Interface()
+	case "google.protobuf.DoubleValue", "google.protobuf.FloatValue", "google.protobuf.Int64Value",
+		"google.protobuf.UInt64Value", "google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue", "google.protobuf.BytesValue":
+		return m.Get(desc.Fields().ByName("value")).Interface()
+	case "google.protobuf.Any":
+		typeURL := m.Get(desc.Fields().ByName("type_url")).String()
+		value := m.Get(desc.Fields().ByName("value")).Bytes()
+		if msg, err := (&anypb.Any{TypeUrl: typeURL, Value: value}).UnmarshalNew(); err == nil {
+			out := map[string]any{"@type": typeURL}
+			if converted, ok := messageValue(msg.ProtoReflect(), name).(map[string]any); ok {
+				for k, v := range converted {
+					out[k] = v
+				}
+			} else {
+				out["value"] = messageValue(msg.ProtoReflect(), name)
+			}
+			return out
+		}
+		return map[string]any{"@type": typeURL}
+	}
+	return messageToMap(m, name)
+}
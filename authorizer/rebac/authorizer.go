@@ -0,0 +1,203 @@
+package rebac
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+// SubjectFunc returns the subject of a request - an id of the checker's subject type or, if the checker parses typed
+// subjects (see WithTypedSubjects), an object (type:id). An empty subject is denied
+type SubjectFunc func(ctx context.Context, params *authorizer.RuleExecutionParams) (string, error)
+
+// DefaultSubject returns the "id" (or "sub") field of the user
+func DefaultSubject(ctx context.Context, params *authorizer.RuleExecutionParams) (string, error) {
+	for _, field := range []string{"id", "sub"} {
+		if value, ok := authorizer.LookupField(params.User, field); ok && value != nil {
+			return fmt.Sprint(value), nil
+		}
+	}
+	return "", nil
+}
+
+// Opt is a functional option for configuring a RebacAuthorizer
+type Opt func(*RebacAuthorizer)
+
+// WithSubject sets the function that returns the subject of a request (default DefaultSubject)
+func WithSubject(fn SubjectFunc) Opt {
+	return func(a *RebacAuthorizer) {
+		a.subject = fn
+	}
+}
+
+// WithTracerProvider enables tracing - a span named "authorize.evaluate" is created for every call to AuthorizeMethod
+func WithTracerProvider(tp trace.TracerProvider) Opt {
+	return func(a *RebacAuthorizer) {
+		a.tracerProvider = tp
+	}
+}
+
+// placeholder matches the {request.field} and {user.field} placeholders of an object template
+var placeholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// check is a parsed rule expression
+type check struct {
+	permission string
+	object     string
+}
+
+// RebacAuthorizer authorizes grpc requests with relationship checks. Every rule expression is a permission and an
+// object template - placeholders are replaced with fields of the request or user (see authorizer.LookupField):
+//
+//	option (authorize.rules) = {
+//	  rules: [{expression: "edit doc:{request.id}"}]
+//	};
+//
+// The request is allowed if the subject of the request has the permission on the object for any rule of the method.
+// Rules with a missing placeholder value don't match
+type RebacAuthorizer struct {
+	rules          map[string]*authorize.RuleSet
+	checks         map[string]check
+	checker        *Checker
+	subject        SubjectFunc
+	tracerProvider trace.TracerProvider
+}
+
+// NewRebacAuthorizer returns a new RebacAuthorizer. The rules map is a map of method names to RuleSets. The RuleSets are used to
+// authorize the method. The RuleSets are evaluated in order and the first rule that evaluates to true will authorize
+// the request. The mapping can be generated with the protoc-gen-authorize plugin.
+// An error is returned if a rule can't be parsed or references a permission that doesn't exist in the schema
+func NewRebacAuthorizer(rules map[string]*authorize.RuleSet, checker *Checker, opts ...Opt) (*RebacAuthorizer, error) {
+	a := &RebacAuthorizer{
+		rules:   rules,
+		checks:  map[string]check{},
+		checker: checker,
+		subject: DefaultSubject,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	for method, ruleSet := range rules {
+		if len(ruleSet.GetRules()) == 1 && ruleSet.Rules[0].Expression == "*" {
+			continue
+		}
+		for _, rule := range ruleSet.GetRules() {
+			c, err := a.parseRule(rule.Expression)
+			if err != nil {
+				return nil, fmt.Errorf("authorizer: invalid rule %q of %s: %v", rule.Expression, method, err.Error())
+			}
+			a.checks[rule.Expression] = c
+		}
+	}
+	return a, nil
+}
+
+// parseRule parses a rule expression into a permission and an object template
+func (a *RebacAuthorizer) parseRule(expression string) (check, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 2 {
+		return check{}, fmt.Errorf("expected <permission> <type>:<id>")
+	}
+	c := check{permission: fields[0], object: fields[1]}
+	typ, _, ok := strings.Cut(c.object, ":")
+	if !ok || typ == "" {
+		return check{}, fmt.Errorf("expected <permission> <type>:<id>")
+	}
+	if !placeholder.MatchString(typ) && !a.checker.Schema().HasPermission(typ, c.permission) {
+		return check{}, fmt.Errorf("unknown relation or permission %s of %s", c.permission, typ)
+	}
+	return c, nil
+}
+
+// resolve replaces the placeholders of an object template - false is returned if a placeholder has no value
+func (c check) resolve(params *authorizer.RuleExecutionParams) (string, bool) {
+	values := map[string]any{
+		"request": params.Request,
+		"user":    params.User,
+	}
+	resolved := true
+	object := placeholder.ReplaceAllStringFunc(c.object, func(match string) string {
+		value, ok := authorizer.LookupField(values, strings.TrimSpace(match[1:len(match)-1]))
+		if !ok || value == nil || fmt.Sprint(value) == "" {
+			resolved = false
+			return ""
+		}
+		return fmt.Sprint(value)
+	})
+	return object, resolved
+}
+
+// Checker returns the checker of the authorizer - for example to write tuples at runtime
+func (a *RebacAuthorizer) Checker() *Checker {
+	return a.checker
+}
+
+// AuthorizeMethod authorizes a gRPC method the RuleExecutionParams and returns a boolean representing whether the
+// request is authorized or not.
+func (a *RebacAuthorizer) AuthorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	if a.tracerProvider != nil {
+		return authorizer.TraceEvaluation(ctx, a.tracerProvider, "rebac", method, func(ctx context.Context) (bool, error) {
+			return a.authorizeMethod(ctx, method, params)
+		})
+	}
+	return a.authorizeMethod(ctx, method, params)
+}
+
+func (a *RebacAuthorizer) authorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	authorizer.RecordBackend(ctx, "rebac")
+	// return false if no rules exist for the method
+	rules, ok := a.rules[method]
+	if !ok {
+		// malformed methods (not /service/method) are denied
+		parts := strings.Split(method, "/")
+		if len(parts) < 2 || parts[1] == "" {
+			return false, nil
+		}
+		svc := parts[1]
+		for k := range a.rules {
+			if strings.HasPrefix(k, "/"+svc) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	// allow all
+	if len(rules.Rules) == 1 && rules.Rules[0].Expression == "*" {
+		authorizer.RecordRuleMatched(ctx, 0)
+		return true, nil
+	}
+	subject, err := a.subject(ctx, params)
+	if err != nil {
+		return false, fmt.Errorf("authorizer: failed to get subject: %v", err.Error())
+	}
+	if subject == "" {
+		return false, nil
+	}
+	for i, rule := range rules.Rules {
+		authorizer.RecordRuleEvaluated(ctx)
+		c, ok := a.checks[rule.Expression]
+		if !ok {
+			return false, fmt.Errorf("authorizer: rule not parsed: %s", rule.Expression)
+		}
+		object, ok := c.resolve(params)
+		if !ok {
+			continue
+		}
+		allowed, err := a.checker.Check(ctx, subject, c.permission, object)
+		if err != nil {
+			return false, fmt.Errorf("authorizer: failed to check %s %s: %v", c.permission, object, err.Error())
+		}
+		if allowed {
+			authorizer.RecordRuleMatched(ctx, i)
+			return true, nil
+		}
+	}
+	return false, nil
+}
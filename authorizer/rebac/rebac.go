@@ -0,0 +1,255 @@
+package rebac
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+
+	authcel "github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+)
+
+// DefaultMaxDepth is the default maximum depth of the relationship graph traversed by a check
+const DefaultMaxDepth = 25
+
+// CheckerOpt is a functional option for configuring a Checker
+type CheckerOpt func(*Checker)
+
+// WithMaxDepth sets the maximum depth of the relationship graph traversed by a check (default DefaultMaxDepth).
+// Checks that exceed it return an error
+func WithMaxDepth(depth int) CheckerOpt {
+	return func(c *Checker) {
+		c.maxDepth = depth
+	}
+}
+
+// WithSubjectType sets the type of the subjects passed to Check (default "user") - Check(ctx, "alice", ...) checks
+// user:alice
+func WithSubjectType(typ string) CheckerOpt {
+	return func(c *Checker) {
+		c.subjectType = typ
+	}
+}
+
+// WithTypedSubjects makes Check parse subjects that contain a ":" as typed subjects (type:id or type:id#relation) -
+// Check(ctx, "group:eng#member", ...) checks the members of group eng. By default every subject is an id of the
+// subject type, so ids like SPIFFE ids (spiffe://example.org/alice) and URNs are checked as user:<id>. Only enable it
+// if the subjects can't be chosen by clients
+func WithTypedSubjects() CheckerOpt {
+	return func(c *Checker) {
+		c.typedSubjects = true
+	}
+}
+
+// Checker checks permissions by traversing the relationship tuples of a TupleStore according to a Schema
+type Checker struct {
+	schema        *Schema
+	store         TupleStore
+	maxDepth      int
+	subjectType   string
+	typedSubjects bool
+}
+
+// NewChecker returns a new Checker
+func NewChecker(schema *Schema, store TupleStore, opts ...CheckerOpt) *Checker {
+	c := &Checker{
+		schema:      schema,
+		store:       store,
+		maxDepth:    DefaultMaxDepth,
+		subjectType: "user",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Schema returns the schema of the checker
+func (c *Checker) Schema() *Schema {
+	return c.schema
+}
+
+// Store returns the tuple store of the checker
+func (c *Checker) Store() TupleStore {
+	return c.store
+}
+
+// Write validates the tuples against the schema and writes them to the store
+func (c *Checker) Write(ctx context.Context, tuples ...Tuple) error {
+	for _, t := range tuples {
+		if err := c.schema.ValidateTuple(t); err != nil {
+			return err
+		}
+	}
+	return c.store.Write(ctx, tuples...)
+}
+
+// Check returns true if the subject has the relation or permission on the object (type:id). The subject is an id of
+// the subject type (see WithSubjectType) or with WithTypedSubjects a typed subject. An error is returned if the object
+// type or permission doesn't exist in the schema
+func (c *Checker) Check(ctx context.Context, subject string, permission string, object string) (bool, error) {
+	if subject == "" {
+		return false, nil
+	}
+	if !c.typedSubjects || !strings.Contains(subject, ":") {
+		subject = c.subjectType + ":" + subject
+	}
+	if _, _, _, err := parseSubject(subject); err != nil {
+		return false, err
+	}
+	typ, _, err := parseObject(object)
+	if err != nil {
+		return false, err
+	}
+	if !c.schema.HasPermission(typ, permission) {
+		return false, fmt.Errorf("authorizer: unknown relation or permission %s of %s", permission, typ)
+	}
+	return c.check(ctx, subject, permission, object, 0)
+}
+
+func (c *Checker) check(ctx context.Context, subject string, permission string, object string, depth int) (bool, error) {
+	if depth > c.maxDepth {
+		return false, fmt.Errorf("authorizer: max depth of %d exceeded checking %s#%s@%s", c.maxDepth, object, permission, subject)
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	typ, _, err := parseObject(object)
+	if err != nil {
+		return false, err
+	}
+	def, ok := c.schema.definitions[typ]
+	if !ok {
+		return false, nil
+	}
+	if e, ok := def.permissions[permission]; ok {
+		return c.eval(ctx, subject, e, object, depth)
+	}
+	if _, ok := def.relations[permission]; !ok {
+		return false, nil
+	}
+	tuples, err := c.store.Read(ctx, object, permission)
+	if err != nil {
+		return false, fmt.Errorf("authorizer: failed to read tuples: %v", err.Error())
+	}
+	subjectType, _, _, _ := parseSubject(subject)
+	var usersets []Tuple
+	for _, t := range tuples {
+		if t.Subject == subject {
+			return true, nil
+		}
+		typ, id, relation, err := parseSubject(t.Subject)
+		if err != nil {
+			continue
+		}
+		switch {
+		case id == "*":
+			if typ == subjectType && !strings.Contains(subject, "#") {
+				return true, nil
+			}
+		case relation != "":
+			usersets = append(usersets, t)
+		}
+	}
+	// resolve usersets after direct matches
+	for _, t := range usersets {
+		object, relation, _ := strings.Cut(t.Subject, "#")
+		ok, err := c.check(ctx, subject, relation, object, depth+1)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (c *Checker) eval(ctx context.Context, subject string, e expr, object string, depth int) (bool, error) {
+	switch e := e.(type) {
+	case computedExpr:
+		return c.check(ctx, subject, e.name, object, depth+1)
+	case arrowExpr:
+		tuples, err := c.store.Read(ctx, object, e.relation)
+		if err != nil {
+			return false, fmt.Errorf("authorizer: failed to read tuples: %v", err.Error())
+		}
+		for _, t := range tuples {
+			typ, id, relation, err := parseSubject(t.Subject)
+			if err != nil || id == "*" || relation != "" || !c.schema.HasPermission(typ, e.permission) {
+				continue
+			}
+			ok, err := c.check(ctx, subject, e.permission, t.Subject, depth+1)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case binaryExpr:
+		left, err := c.eval(ctx, subject, e.left, object, depth)
+		if err != nil {
+			return false, err
+		}
+		switch e.op {
+		case '+':
+			if left {
+				return true, nil
+			}
+		case '&', '-':
+			if !left {
+				return false, nil
+			}
+		}
+		right, err := c.eval(ctx, subject, e.right, object, depth)
+		if err != nil {
+			return false, err
+		}
+		if e.op == '-' {
+			return !right, nil
+		}
+		return right, nil
+	}
+	return false, fmt.Errorf("authorizer: unsupported rebac expression: %v", e)
+}
+
+// CELFunction returns the rebac.check(subject, permission, object) function for the cel authorizer
+// (cel.WithFunctions):
+//
+//	rebac.check(user.id, 'edit', 'doc:' + request.id)
+//
+// The check runs with the context of the request, so it is canceled with the request
+func CELFunction(checker *Checker) cel.EnvOption {
+	return authcel.ContextFunction("rebac.check", "rebac_check_string_string_string",
+		[]*cel.Type{cel.StringType, cel.StringType, cel.StringType}, cel.BoolType,
+		func(ctx context.Context, args ...ref.Val) ref.Val {
+			subject, ok1 := args[0].Value().(string)
+			permission, ok2 := args[1].Value().(string)
+			object, ok3 := args[2].Value().(string)
+			if !ok1 || !ok2 || !ok3 {
+				return types.NewErr("rebac.check: expected string arguments")
+			}
+			allowed, err := checker.Check(ctx, subject, permission, object)
+			if err != nil {
+				return types.WrapErr(err)
+			}
+			return types.Bool(allowed)
+		})
+}
+
+// JSVariables returns the rebac object with a check(subject, permission, object) function for the javascript
+// authorizer (javascript.WithContextVariables(rebac.JSVariables(checker))):
+//
+//	rebac.check(user.id, 'edit', 'doc:' + request.id)
+//
+// Like CELFunction the check runs with the context of the request
+func JSVariables(checker *Checker) func(ctx context.Context) map[string]any {
+	return func(ctx context.Context) map[string]any {
+		return map[string]any{
+			"rebac": map[string]any{
+				"check": func(subject string, permission string, object string) (bool, error) {
+					return checker.Check(ctx, subject, permission, object)
+				},
+			},
+		}
+	}
+}
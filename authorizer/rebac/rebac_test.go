@@ -0,0 +1,328 @@
+package rebac_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/javascript"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/rebac"
+)
+
+const schema = `
+definition user {}
+
+definition group {
+  relation member: user | group#member
+}
+
+definition folder {
+  relation parent: folder
+  relation editor: user | group#member
+  relation viewer: user | user:*
+  permission edit = editor + parent->edit
+  permission view = viewer + edit + parent->view
+}
+
+// documents inherit the permissions of their folder
+definition doc {
+  relation parent: folder
+  relation owner: user
+  relation editor: user | group#member
+  relation banned: user
+  permission edit = owner + editor + parent->edit
+  permission view = (edit + parent->view) - banned
+  permission delete = owner & parent->edit
+}
+`
+
+var tuples = []string{
+	"group:eng#member@user:alice",
+	"group:staff#member@group:eng#member",
+	"group:staff#member@user:bob",
+	"folder:root#viewer@user:*",
+	"folder:eng#parent@folder:root",
+	"folder:eng#editor@group:eng#member",
+	"doc:design#parent@folder:eng",
+	"doc:design#owner@user:alice",
+	"doc:notes#editor@group:staff#member",
+	"doc:notes#parent@folder:root",
+	"doc:notes#banned@user:mallory",
+}
+
+func newChecker(t *testing.T, opts ...rebac.CheckerOpt) *rebac.Checker {
+	t.Helper()
+	checker := rebac.NewChecker(rebac.MustParseSchema(schema), rebac.NewMemoryStore(), opts...)
+	for _, s := range tuples {
+		if err := checker.Write(context.Background(), rebac.MustParseTuple(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return checker
+}
+
+func TestChecker(t *testing.T) {
+	checker := newChecker(t, rebac.WithTypedSubjects())
+	for _, f := range []struct {
+		subject     string
+		permission  string
+		object      string
+		expectAllow bool
+	}{
+		{subject: "alice", permission: "edit", object: "doc:design", expectAllow: true},
+		{subject: "alice", permission: "delete", object: "doc:design", expectAllow: true},
+		{subject: "bob", permission: "edit", object: "doc:design", expectAllow: false},
+		{subject: "bob", permission: "view", object: "doc:design", expectAllow: true},
+		{subject: "user:alice", permission: "edit", object: "folder:eng", expectAllow: true},
+		{subject: "alice", permission: "edit", object: "folder:root", expectAllow: false},
+		{subject: "alice", permission: "edit", object: "doc:notes", expectAllow: true},
+		{subject: "bob", permission: "edit", object: "doc:notes", expectAllow: true},
+		{subject: "bob", permission: "delete", object: "doc:notes", expectAllow: false},
+		{subject: "mallory", permission: "view", object: "doc:design", expectAllow: true},
+		{subject: "mallory", permission: "view", object: "doc:notes", expectAllow: false},
+		{subject: "group:eng#member", permission: "member", object: "group:staff", expectAllow: true},
+		{subject: "alice", permission: "edit", object: "doc:missing", expectAllow: false},
+	} {
+		t.Run(f.subject+" "+f.permission+" "+f.object, func(t *testing.T) {
+			allow, err := checker.Check(context.Background(), f.subject, f.permission, f.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allow != f.expectAllow {
+				t.Fatalf("expected allow=%v, got %v", f.expectAllow, allow)
+			}
+		})
+	}
+	if _, err := checker.Check(context.Background(), "alice", "share", "doc:design"); err == nil {
+		t.Fatal("expected an error for an unknown permission")
+	}
+	// tuples can be removed at runtime
+	if err := checker.Store().Delete(context.Background(), rebac.MustParseTuple("group:eng#member@user:alice")); err != nil {
+		t.Fatal(err)
+	}
+	if allow, _ := checker.Check(context.Background(), "alice", "edit", "folder:eng"); allow {
+		t.Fatal("expected alice to lose the edit permission")
+	}
+}
+
+func TestChecker_UntypedSubjects(t *testing.T) {
+	checker := newChecker(t)
+	if err := checker.Write(context.Background(), rebac.MustParseTuple("doc:spec#owner@user:spiffe://example.org/alice")); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []struct {
+		subject     string
+		expectAllow bool
+	}{
+		// ids with a ":" are ids of the subject type
+		{subject: "spiffe://example.org/alice", expectAllow: true},
+		{subject: "alice", expectAllow: false},
+		// typed subjects are only parsed with WithTypedSubjects
+		{subject: "user:alice", expectAllow: false},
+	} {
+		allow, err := checker.Check(context.Background(), f.subject, "edit", "doc:spec")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow != f.expectAllow {
+			t.Fatalf("%s: expected allow=%v, got %v", f.subject, f.expectAllow, allow)
+		}
+	}
+}
+
+func TestChecker_MaxDepth(t *testing.T) {
+	checker := rebac.NewChecker(rebac.MustParseSchema(schema), rebac.NewMemoryStore(
+		rebac.MustParseTuple("folder:a#parent@folder:b"),
+		rebac.MustParseTuple("folder:b#parent@folder:a"),
+	), rebac.WithMaxDepth(10))
+	if _, err := checker.Check(context.Background(), "alice", "edit", "folder:a"); err == nil {
+		t.Fatal("expected an error for a cycle")
+	}
+}
+
+func TestParseSchema_Invalid(t *testing.T) {
+	for _, text := range []string{
+		`definition doc { relation owner: person }`,
+		`definition user {} definition doc { permission edit = owner }`,
+		`definition user {} definition doc { relation owner: user permission edit = owner->edit }`,
+		`definition user {} definition doc { relation owner: user relation owner: user }`,
+		`definition user {} definition doc { relation owner: user permission edit = (owner }`,
+	} {
+		if _, err := rebac.ParseSchema(text); err == nil {
+			t.Fatalf("expected an error for %s", text)
+		}
+	}
+}
+
+func TestChecker_WriteInvalid(t *testing.T) {
+	checker := newChecker(t)
+	for _, s := range []string{
+		"doc:design#owner@group:eng",
+		"doc:design#viewer@user:alice",
+		"doc:design#owner@user:*",
+	} {
+		if err := checker.Write(context.Background(), rebac.MustParseTuple(s)); err == nil {
+			t.Fatalf("expected an error for %s", s)
+		}
+	}
+}
+
+type fixture struct {
+	name        string
+	method      string
+	params      *authorizer.RuleExecutionParams
+	expectError bool
+	expectAllow bool
+}
+
+var fixtures = []fixture{
+	{
+		name:        "editor of document (allow)",
+		method:      "/example.Docs/Update",
+		params:      &authorizer.RuleExecutionParams{User: map[string]any{"id": "alice"}, Request: map[string]any{"id": "design"}},
+		expectAllow: true,
+	},
+	{
+		name:        "viewer of document (deny)",
+		method:      "/example.Docs/Update",
+		params:      &authorizer.RuleExecutionParams{User: map[string]any{"id": "bob"}, Request: map[string]any{"id": "design"}},
+		expectAllow: false,
+	},
+	{
+		name:        "editor of folder (allow)",
+		method:      "/example.Docs/Update",
+		params:      &authorizer.RuleExecutionParams{User: map[string]any{"id": "alice"}, Request: map[string]any{"id": "other", "folder": "eng"}},
+		expectAllow: true,
+	},
+	{
+		name:        "missing field (deny)",
+		method:      "/example.Docs/Update",
+		params:      &authorizer.RuleExecutionParams{User: map[string]any{"id": "alice"}, Request: map[string]any{}},
+		expectAllow: false,
+	},
+	{
+		name:        "no user (deny)",
+		method:      "/example.Docs/Update",
+		params:      &authorizer.RuleExecutionParams{Request: map[string]any{"id": "design"}},
+		expectAllow: false,
+	},
+	{
+		name:        "allow all",
+		method:      "/example.Docs/List",
+		params:      &authorizer.RuleExecutionParams{},
+		expectAllow: true,
+	},
+	{
+		name:        "malformed method (deny)",
+		method:      "example.Docs",
+		params:      &authorizer.RuleExecutionParams{User: map[string]any{"id": "alice"}},
+		expectAllow: false,
+	},
+}
+
+func TestRebacAuthorizer(t *testing.T) {
+	authz, err := rebac.NewRebacAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Docs/Update": {Rules: []*authorize.Rule{
+			{Expression: "edit doc:{request.id}"},
+			{Expression: "edit folder:{request.folder}"},
+		}},
+		"/example.Docs/List": {Rules: []*authorize.Rule{{Expression: "*"}}},
+	}, newChecker(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			allow, err := authz.AuthorizeMethod(context.Background(), f.method, f.params)
+			if err != nil {
+				if f.expectError {
+					return
+				}
+				t.Fatal(err)
+			}
+			if f.expectError {
+				t.Fatal("expected an error")
+			}
+			if allow != f.expectAllow {
+				t.Fatalf("expected allow=%v, got %v", f.expectAllow, allow)
+			}
+		})
+	}
+}
+
+func TestNewRebacAuthorizer_InvalidRule(t *testing.T) {
+	for _, expression := range []string{"edit", "share doc:{request.id}", "edit {request.id}"} {
+		_, err := rebac.NewRebacAuthorizer(map[string]*authorize.RuleSet{
+			"/example.Docs/Update": {Rules: []*authorize.Rule{{Expression: expression}}},
+		}, newChecker(t))
+		if err == nil {
+			t.Fatalf("expected an error for %q", expression)
+		}
+	}
+}
+
+func TestCELFunction(t *testing.T) {
+	authz, err := cel.NewCelAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Docs/Update": {Rules: []*authorize.Rule{{Expression: "rebac.check(user.id, 'edit', 'doc:' + request.id)"}}},
+	}, cel.WithFunctions(rebac.CELFunction(newChecker(t))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := authz.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for user, expectAllow := range map[string]bool{"alice": true, "bob": false} {
+		allow, err := authz.AuthorizeMethod(context.Background(), "/example.Docs/Update", &authorizer.RuleExecutionParams{
+			User:    map[string]any{"id": user},
+			Request: map[string]any{"id": "design"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow != expectAllow {
+			t.Fatalf("%s: expected allow=%v, got %v", user, expectAllow, allow)
+		}
+	}
+	// the check runs with the request context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := authz.AuthorizeMethod(ctx, "/example.Docs/Update", &authorizer.RuleExecutionParams{
+		User:    map[string]any{"id": "alice"},
+		Request: map[string]any{"id": "design"},
+	}); err == nil {
+		t.Fatal("expected an error for a canceled request")
+	}
+}
+
+func TestJSObject(t *testing.T) {
+	authz, err := javascript.NewJavascriptAuthorizer(map[string]*authorize.RuleSet{
+		"/example.Docs/Update": {Rules: []*authorize.Rule{{Expression: "rebac.check(user.id, 'edit', 'doc:' + request.id)"}}},
+	}, javascript.WithContextVariables(rebac.JSVariables(newChecker(t))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for user, expectAllow := range map[string]bool{"alice": true, "bob": false} {
+		allow, err := authz.AuthorizeMethod(context.Background(), "/example.Docs/Update", &authorizer.RuleExecutionParams{
+			User:    map[string]any{"id": user},
+			Request: map[string]any{"id": "design"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow != expectAllow {
+			t.Fatalf("%s: expected allow=%v, got %v", user, expectAllow, allow)
+		}
+	}
+	// the check runs with the request context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := authz.AuthorizeMethod(ctx, "/example.Docs/Update", &authorizer.RuleExecutionParams{
+		User:    map[string]any{"id": "alice"},
+		Request: map[string]any{"id": "design"},
+	}); err == nil {
+		t.Fatal("expected an error for a canceled request")
+	}
+}
@@ -0,0 +1,423 @@
+package rebac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Schema defines the object types with their relations and permissions. It is parsed from a SpiceDB-like language:
+//
+//	definition user {}
+//
+//	definition group {
+//	  relation member: user | group#member
+//	}
+//
+//	definition folder {
+//	  relation editor: user | group#member
+//	  relation viewer: user | user:*
+//	  permission view = viewer + editor
+//	}
+//
+//	definition doc {
+//	  relation parent: folder
+//	  relation owner: user
+//	  relation editor: user | group#member
+//	  relation banned: user
+//	  permission edit = owner + editor + parent->editor
+//	  permission view = (edit + parent->view) - banned
+//	}
+//
+// Relations are stored as tuples and list the subject types they allow: objects (user), members of a relation of
+// another object (group#member) or every object of a type (user:*). Permissions are computed from relations and
+// other permissions with + (union), & (intersection), - (exclusion) and -> (the permission of the objects of a
+// relation - parent->view). Operators have equal precedence and are left associative, use parentheses to group
+// them. Line comments start with //
+type Schema struct {
+	definitions map[string]*definition
+}
+
+type definition struct {
+	name        string
+	relations   map[string][]subjectType
+	permissions map[string]expr
+}
+
+// subjectType is an allowed subject type of a relation
+type subjectType struct {
+	typ      string
+	relation string
+	wildcard bool
+}
+
+func (s subjectType) String() string {
+	switch {
+	case s.wildcard:
+		return s.typ + ":*"
+	case s.relation != "":
+		return s.typ + "#" + s.relation
+	default:
+		return s.typ
+	}
+}
+
+// expr is a permission expression
+type expr interface {
+	String() string
+}
+
+type (
+	// computedExpr is a relation or permission of the same object
+	computedExpr struct {
+		name string
+	}
+	// arrowExpr is the permission of the objects of a relation
+	arrowExpr struct {
+		relation   string
+		permission string
+	}
+	// binaryExpr combines two expressions with +, & or -
+	binaryExpr struct {
+		op          byte
+		left, right expr
+	}
+)
+
+func (e computedExpr) String() string { return e.name }
+func (e arrowExpr) String() string    { return e.relation + "->" + e.permission }
+func (e binaryExpr) String() string {
+	return "(" + e.left.String() + " " + string(e.op) + " " + e.right.String() + ")"
+}
+
+// ParseSchema parses and validates a schema
+func ParseSchema(text string) (*Schema, error) {
+	p := &schemaParser{tokens: tokenize(text)}
+	schema := &Schema{definitions: map[string]*definition{}}
+	for !p.done() {
+		def, err := p.definition()
+		if err != nil {
+			return nil, fmt.Errorf("authorizer: invalid rebac schema: %v", err.Error())
+		}
+		if _, ok := schema.definitions[def.name]; ok {
+			return nil, fmt.Errorf("authorizer: invalid rebac schema: duplicate definition %s", def.name)
+		}
+		schema.definitions[def.name] = def
+	}
+	if err := schema.validate(); err != nil {
+		return nil, fmt.Errorf("authorizer: invalid rebac schema: %v", err.Error())
+	}
+	return schema, nil
+}
+
+// MustParseSchema is like ParseSchema but panics if the schema is invalid
+func MustParseSchema(text string) *Schema {
+	schema, err := ParseSchema(text)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// Types returns the names of the object types
+func (s *Schema) Types() []string {
+	types := make([]string, 0, len(s.definitions))
+	for name := range s.definitions {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// HasPermission returns true if the object type has the relation or permission
+func (s *Schema) HasPermission(typ string, permission string) bool {
+	def, ok := s.definitions[typ]
+	if !ok {
+		return false
+	}
+	return def.has(permission)
+}
+
+// ValidateTuple returns an error if the tuple's relation doesn't exist or doesn't allow the subject
+func (s *Schema) ValidateTuple(t Tuple) error {
+	objectType, _, err := parseObject(t.Object)
+	if err != nil {
+		return err
+	}
+	def, ok := s.definitions[objectType]
+	if !ok {
+		return fmt.Errorf("authorizer: unknown object type: %s", objectType)
+	}
+	allowed, ok := def.relations[t.Relation]
+	if !ok {
+		return fmt.Errorf("authorizer: unknown relation %s of %s", t.Relation, objectType)
+	}
+	subjectType, subjectID, subjectRelation, err := parseSubject(t.Subject)
+	if err != nil {
+		return err
+	}
+	for _, a := range allowed {
+		if a.typ != subjectType {
+			continue
+		}
+		if a.wildcard && subjectID == "*" && subjectRelation == "" ||
+			!a.wildcard && subjectID != "*" && a.relation == subjectRelation {
+			return nil
+		}
+	}
+	return fmt.Errorf("authorizer: relation %s of %s doesn't allow subject %s", t.Relation, objectType, t.Subject)
+}
+
+func (d *definition) has(name string) bool {
+	if _, ok := d.relations[name]; ok {
+		return true
+	}
+	_, ok := d.permissions[name]
+	return ok
+}
+
+func (s *Schema) validate() error {
+	for _, def := range s.definitions {
+		for relation, types := range def.relations {
+			for _, t := range types {
+				other, ok := s.definitions[t.typ]
+				if !ok {
+					return fmt.Errorf("%s#%s: unknown type %s", def.name, relation, t.typ)
+				}
+				if t.relation != "" && !other.has(t.relation) {
+					return fmt.Errorf("%s#%s: unknown relation %s", def.name, relation, t)
+				}
+			}
+		}
+		for permission, e := range def.permissions {
+			if err := s.validateExpr(def, e); err != nil {
+				return fmt.Errorf("%s#%s: %v", def.name, permission, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateExpr(def *definition, e expr) error {
+	switch e := e.(type) {
+	case computedExpr:
+		if !def.has(e.name) {
+			return fmt.Errorf("unknown relation or permission %s", e.name)
+		}
+	case arrowExpr:
+		types, ok := def.relations[e.relation]
+		if !ok {
+			return fmt.Errorf("unknown relation %s", e.relation)
+		}
+		for _, t := range types {
+			if s.definitions[t.typ].has(e.permission) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no subject type of %s has %s", e.relation, e.permission)
+	case binaryExpr:
+		if err := s.validateExpr(def, e.left); err != nil {
+			return err
+		}
+		return s.validateExpr(def, e.right)
+	}
+	return nil
+}
+
+// tokenize splits a schema into identifiers and symbols (->, {, }, :, |, #, *, +, &, -, (, ), =)
+func tokenize(text string) []string {
+	var tokens []string
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		runes := []rune(line)
+		for i := 0; i < len(runes); {
+			r := runes[i]
+			switch {
+			case unicode.IsSpace(r):
+				i++
+			case isIdent(r):
+				start := i
+				for i < len(runes) && isIdent(runes[i]) {
+					i++
+				}
+				tokens = append(tokens, string(runes[start:i]))
+			case r == '-' && i+1 < len(runes) && runes[i+1] == '>':
+				tokens = append(tokens, "->")
+				i += 2
+			default:
+				tokens = append(tokens, string(r))
+				i++
+			}
+		}
+	}
+	return tokens
+}
+
+func isIdent(r rune) bool {
+	return r == '_' || r == '/' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type schemaParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *schemaParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *schemaParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *schemaParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *schemaParser) expect(token string) error {
+	if got := p.next(); got != token {
+		return fmt.Errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+func (p *schemaParser) ident() (string, error) {
+	token := p.next()
+	if token == "" || !isIdent([]rune(token)[0]) {
+		return "", fmt.Errorf("expected a name, got %q", token)
+	}
+	return token, nil
+}
+
+func (p *schemaParser) definition() (*definition, error) {
+	if err := p.expect("definition"); err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	def := &definition{
+		name:        name,
+		relations:   map[string][]subjectType{},
+		permissions: map[string]expr{},
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for p.peek() != "}" {
+		keyword := p.next()
+		member, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if def.has(member) {
+			return nil, fmt.Errorf("%s: duplicate relation or permission %s", name, member)
+		}
+		switch keyword {
+		case "relation":
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			types, err := p.subjectTypes()
+			if err != nil {
+				return nil, fmt.Errorf("%s#%s: %v", name, member, err.Error())
+			}
+			def.relations[member] = types
+		case "permission":
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			e, err := p.expr()
+			if err != nil {
+				return nil, fmt.Errorf("%s#%s: %v", name, member, err.Error())
+			}
+			def.permissions[member] = e
+		default:
+			return nil, fmt.Errorf("%s: expected relation or permission, got %q", name, keyword)
+		}
+	}
+	return def, p.expect("}")
+}
+
+func (p *schemaParser) subjectTypes() ([]subjectType, error) {
+	var types []subjectType
+	for {
+		typ, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		t := subjectType{typ: typ}
+		switch p.peek() {
+		case "#":
+			p.next()
+			if t.relation, err = p.ident(); err != nil {
+				return nil, err
+			}
+		case ":":
+			p.next()
+			if err := p.expect("*"); err != nil {
+				return nil, err
+			}
+			t.wildcard = true
+		}
+		types = append(types, t)
+		if p.peek() != "|" {
+			return types, nil
+		}
+		p.next()
+	}
+}
+
+// expr parses term ((+ | & | -) term)*
+func (p *schemaParser) expr() (expr, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != "+" && op != "&" && op != "-" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op[0], left: left, right: right}
+	}
+}
+
+// term parses ( expr ) | name | name->name
+func (p *schemaParser) term() (expr, error) {
+	if p.peek() == "(" {
+		p.next()
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "->" {
+		return computedExpr{name: name}, nil
+	}
+	p.next()
+	permission, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	return arrowExpr{relation: name, permission: permission}, nil
+}
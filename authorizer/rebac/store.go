@@ -0,0 +1,147 @@
+package rebac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Tuple is a relationship between an object and a subject, written as <object>#<relation>@<subject>:
+//
+//	doc:readme#parent@folder:docs      - folder docs is the parent of doc readme
+//	folder:docs#editor@group:eng#member - the members of group eng are editors of folder docs
+//	doc:readme#viewer@user:*           - every user is a viewer of doc readme
+type Tuple struct {
+	// Object is the object of the relationship (type:id)
+	Object string
+	// Relation is the relation of the object
+	Relation string
+	// Subject is the subject of the relationship - an object (type:id), the subjects of a relation of an object
+	// (type:id#relation) or every object of a type (type:*)
+	Subject string
+}
+
+// ParseTuple parses a tuple from <object>#<relation>@<subject>
+func ParseTuple(s string) (Tuple, error) {
+	resource, subject, ok := strings.Cut(s, "@")
+	if !ok {
+		return Tuple{}, fmt.Errorf("authorizer: invalid tuple %q: expected <object>#<relation>@<subject>", s)
+	}
+	object, relation, ok := strings.Cut(resource, "#")
+	if !ok {
+		return Tuple{}, fmt.Errorf("authorizer: invalid tuple %q: expected <object>#<relation>@<subject>", s)
+	}
+	t := Tuple{Object: object, Relation: relation, Subject: subject}
+	if _, _, err := parseObject(t.Object); err != nil {
+		return Tuple{}, err
+	}
+	if _, _, _, err := parseSubject(t.Subject); err != nil {
+		return Tuple{}, err
+	}
+	return t, nil
+}
+
+// MustParseTuple is like ParseTuple but panics if the tuple is invalid
+func MustParseTuple(s string) Tuple {
+	t, err := ParseTuple(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// String returns the tuple as <object>#<relation>@<subject>
+func (t Tuple) String() string {
+	return t.Object + "#" + t.Relation + "@" + t.Subject
+}
+
+// parseObject splits an object into its type and id
+func parseObject(object string) (string, string, error) {
+	typ, id, ok := strings.Cut(object, ":")
+	if !ok || typ == "" || id == "" || strings.Contains(id, "#") {
+		return "", "", fmt.Errorf("authorizer: invalid object %q: expected <type>:<id>", object)
+	}
+	return typ, id, nil
+}
+
+// parseSubject splits a subject into its type, id and optional relation
+func parseSubject(subject string) (string, string, string, error) {
+	object, relation, _ := strings.Cut(subject, "#")
+	typ, id, ok := strings.Cut(object, ":")
+	if !ok || typ == "" || id == "" || strings.Contains(subject, "#") && relation == "" {
+		return "", "", "", fmt.Errorf("authorizer: invalid subject %q: expected <type>:<id>[#<relation>]", subject)
+	}
+	return typ, id, relation, nil
+}
+
+// TupleStore stores relationship tuples
+type TupleStore interface {
+	// Write adds tuples to the store - existing tuples are ignored
+	Write(ctx context.Context, tuples ...Tuple) error
+	// Delete removes tuples from the store - missing tuples are ignored
+	Delete(ctx context.Context, tuples ...Tuple) error
+	// Read returns the tuples of a relation of an object
+	Read(ctx context.Context, object string, relation string) ([]Tuple, error)
+}
+
+// MemoryStore is a TupleStore that keeps the tuples in memory. It is safe for concurrent use
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tuples map[string]map[string]struct{}
+}
+
+// NewMemoryStore returns a new MemoryStore with the tuples
+func NewMemoryStore(tuples ...Tuple) *MemoryStore {
+	s := &MemoryStore{tuples: map[string]map[string]struct{}{}}
+	_ = s.Write(context.Background(), tuples...)
+	return s
+}
+
+func memoryKey(object, relation string) string {
+	return object + "#" + relation
+}
+
+// Write implements TupleStore
+func (s *MemoryStore) Write(ctx context.Context, tuples ...Tuple) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range tuples {
+		key := memoryKey(t.Object, t.Relation)
+		if s.tuples[key] == nil {
+			s.tuples[key] = map[string]struct{}{}
+		}
+		s.tuples[key][t.Subject] = struct{}{}
+	}
+	return nil
+}
+
+// Delete implements TupleStore
+func (s *MemoryStore) Delete(ctx context.Context, tuples ...Tuple) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range tuples {
+		key := memoryKey(t.Object, t.Relation)
+		delete(s.tuples[key], t.Subject)
+		if len(s.tuples[key]) == 0 {
+			delete(s.tuples, key)
+		}
+	}
+	return nil
+}
+
+// Read implements TupleStore. The tuples are sorted by subject
+func (s *MemoryStore) Read(ctx context.Context, object string, relation string) ([]Tuple, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subjects := s.tuples[memoryKey(object, relation)]
+	tuples := make([]Tuple, 0, len(subjects))
+	for subject := range subjects {
+		tuples = append(tuples, Tuple{Object: object, Relation: relation, Subject: subject})
+	}
+	sort.Slice(tuples, func(i, j int) bool {
+		return tuples[i].Subject < tuples[j].Subject
+	})
+	return tuples, nil
+}
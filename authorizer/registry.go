@@ -0,0 +1,35 @@
+package authorizer
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// RequestType returns the type of the input message of the given full grpc method (/package.Service/Method).
+// It returns false if the service is not registered in protoregistry.GlobalFiles (the generated code of the
+// service is not linked in)
+func RequestType(method string) (protoreflect.MessageType, bool) {
+	parts := strings.Split(strings.TrimPrefix(method, "/"), "/")
+	if len(parts) != 2 {
+		return nil, false
+	}
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(parts[0]))
+	if err != nil {
+		return nil, false
+	}
+	svc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, false
+	}
+	m := svc.Methods().ByName(protoreflect.Name(parts[1]))
+	if m == nil {
+		return nil, false
+	}
+	msgType, err := protoregistry.GlobalTypes.FindMessageByName(m.Input().FullName())
+	if err != nil {
+		return nil, false
+	}
+	return msgType, true
+}
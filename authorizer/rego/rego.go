@@ -0,0 +1,261 @@
+package rego
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+// Opt is a functional option for configuring a RegoAuthorizer
+type Opt func(*RegoAuthorizer)
+
+// WithModules adds Rego policy modules (file name => source) that rule queries can reference - for example
+// data.authz.allow
+func WithModules(modules map[string]string) Opt {
+	return func(a *RegoAuthorizer) {
+		for k, v := range modules {
+			a.modules[k] = v
+		}
+	}
+}
+
+// WithData sets the base document available to policies and queries under data
+func WithData(data map[string]any) Opt {
+	return func(a *RegoAuthorizer) {
+		a.store = inmem.NewFromObject(data)
+	}
+}
+
+// WithStore sets the storage.Store that backs data - for example to update data at runtime
+func WithStore(store storage.Store) Opt {
+	return func(a *RegoAuthorizer) {
+		a.store = store
+	}
+}
+
+// WithProtoFieldNames sets the field names of protobuf requests and users in input (default authorizer.JSONFieldName
+// like protojson - input.request.accountId). Use authorizer.ProtoFieldName for input.request.account_id
+func WithProtoFieldNames(name authorizer.FieldNameFunc) Opt {
+	return func(a *RegoAuthorizer) {
+		a.protoFieldName = name
+	}
+}
+
+// WithTracerProvider enables tracing - a span named "authorize.evaluate" is created for every call to AuthorizeMethod
+func WithTracerProvider(tp trace.TracerProvider) Opt {
+	return func(a *RegoAuthorizer) {
+		a.tracerProvider = tp
+	}
+}
+
+// RegoAuthorizer evaluates rule expressions as Rego queries with the Open Policy Agent. The request is available as
+// input with the fields user, request, metadata, method, is_stream, peer, authority, deadline_remaining and now
+// (durations and times are nanoseconds like time.now_ns()). A query authorizes the request if it has a result and every
+// one of its expressions evaluates to exactly true - so both `input.user.role == "admin"` and `data.authz.allow` can be
+// used, but a query whose value is a string, number, set or object (for example `input.user.role`) denies
+type RegoAuthorizer struct {
+	rules          map[string]*authorize.RuleSet
+	modules        map[string]string
+	store          storage.Store
+	protoFieldName authorizer.FieldNameFunc
+	tracerProvider trace.TracerProvider
+	queries        map[string]rego.PreparedEvalQuery
+}
+
+// NewRegoAuthorizer returns a new RegoAuthorizer. The rules map is a map of method names to RuleSets. The RuleSets are used to
+// authorize the method. The RuleSets are evaluated in order and the first rule that evaluates to true will authorize
+// the request. The mapping can be generated with the protoc-gen-authorize plugin.
+// The modules and queries are compiled upfront - an error is returned if any of them is invalid
+func NewRegoAuthorizer(rules map[string]*authorize.RuleSet, opts ...Opt) (*RegoAuthorizer, error) {
+	a := &RegoAuthorizer{
+		rules:          rules,
+		modules:        map[string]string{},
+		protoFieldName: authorizer.JSONFieldName,
+		queries:        map[string]rego.PreparedEvalQuery{},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.store == nil {
+		a.store = inmem.New()
+	}
+	compiler, err := ast.CompileModules(a.modules)
+	if err != nil {
+		return nil, fmt.Errorf("authorizer: failed to compile rego modules: %v", err.Error())
+	}
+	for method, ruleSet := range rules {
+		if len(ruleSet.GetRules()) == 1 && ruleSet.Rules[0].Expression == "*" {
+			continue
+		}
+		for _, rule := range ruleSet.GetRules() {
+			if _, ok := a.queries[rule.Expression]; ok {
+				continue
+			}
+			query, err := rego.New(
+				rego.Query(rule.Expression),
+				rego.Compiler(compiler),
+				rego.Store(a.store),
+			).PrepareForEval(context.Background())
+			if err != nil {
+				return nil, fmt.Errorf("authorizer: failed to prepare query for %s: %v", method, err.Error())
+			}
+			a.queries[rule.Expression] = query
+		}
+	}
+	return a, nil
+}
+
+// AuthorizeMethod authorizes a gRPC method the RuleExecutionParams and returns a boolean representing whether the
+// request is authorized or not.
+func (a *RegoAuthorizer) AuthorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	if a.tracerProvider != nil {
+		return authorizer.TraceEvaluation(ctx, a.tracerProvider, "rego", method, func(ctx context.Context) (bool, error) {
+			return a.authorizeMethod(ctx, method, params)
+		})
+	}
+	return a.authorizeMethod(ctx, method, params)
+}
+
+func (a *RegoAuthorizer) authorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	authorizer.RecordBackend(ctx, "rego")
+	// return false if no rules exist for the method
+	rules, ok := a.rules[method]
+	if !ok {
+		// malformed methods (not /service/method) are denied
+		parts := strings.Split(method, "/")
+		if len(parts) < 2 || parts[1] == "" {
+			return false, nil
+		}
+		svc := parts[1]
+		for k := range a.rules {
+			if strings.HasPrefix(k, "/"+svc) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	// allow all
+	if len(rules.Rules) == 1 && rules.Rules[0].Expression == "*" {
+		authorizer.RecordRuleMatched(ctx, 0)
+		return true, nil
+	}
+	parsed, err := ast.InterfaceToValue(a.input(method, params))
+	if err != nil {
+		return false, fmt.Errorf("authorizer: failed to convert input: %v", err.Error())
+	}
+	for i, rule := range rules.Rules {
+		query, ok := a.queries[rule.Expression]
+		if !ok {
+			return false, fmt.Errorf("authorizer: query not prepared: %s", rule.Expression)
+		}
+		authorizer.RecordRuleEvaluated(ctx)
+		rs, err := query.Eval(ctx, rego.EvalParsedInput(parsed))
+		if err != nil {
+			return false, fmt.Errorf("authorizer: failed to evaluate query: %v", err.Error())
+		}
+		if allowed(rs) {
+			authorizer.RecordRuleMatched(ctx, i)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// allowed returns true if the query has a result whose expressions all evaluate to exactly true. Non-boolean values
+// (for example a query that only references input.user.id) don't allow the request
+func allowed(rs rego.ResultSet) bool {
+	for _, result := range rs {
+		if len(result.Expressions) > 0 && allTrue(result.Expressions) {
+			return true
+		}
+	}
+	return false
+}
+
+func allTrue(exprs []*rego.ExpressionValue) bool {
+	for _, expr := range exprs {
+		if b, ok := expr.Value.(bool); !ok || !b {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *RegoAuthorizer) input(method string, params *authorizer.RuleExecutionParams) map[string]any {
+	metadata := map[string]any{}
+	for k, v := range params.Metadata {
+		metadata[k] = strings.Join(v, ",")
+	}
+	return map[string]any{
+		string(authorizer.ExpressionVarUser):              a.value(params.User),
+		string(authorizer.ExpressionVarRequest):           a.value(params.Request),
+		string(authorizer.ExpressionVarMetadata):          metadata,
+		string(authorizer.ExpressionVarMethod):            method,
+		string(authorizer.ExpressionVarIsStream):          params.IsStream,
+		string(authorizer.ExpressionVarPeer):              regoValue(params.PeerMap()),
+		string(authorizer.ExpressionVarAuthority):         params.Authority,
+		string(authorizer.ExpressionVarDeadlineRemaining): params.DeadlineRemaining().Nanoseconds(),
+		string(authorizer.ExpressionVarNow):               params.EvaluationTime().UnixNano(),
+	}
+}
+
+// value converts protobuf messages to objects keyed by their proto field names
+func (a *RegoAuthorizer) value(v any) any {
+	msg, ok := v.(proto.Message)
+	if !ok || !msg.ProtoReflect().IsValid() {
+		return v
+	}
+	return regoValue(authorizer.ProtoToMap(msg, a.protoFieldName))
+}
+
+// regoValue converts the values of authorizer.ProtoToMap to json compatible values
+func regoValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			out[k] = regoValue(e)
+		}
+		return out
+	case map[any]any:
+		// rego object keys of json input are strings
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			out[fmt.Sprint(k)] = regoValue(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = regoValue(e)
+		}
+		return out
+	case []string:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = e
+		}
+		return out
+	case time.Time:
+		return v.UnixNano()
+	case time.Duration:
+		return v.Nanoseconds()
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	default:
+		return v
+	}
+}
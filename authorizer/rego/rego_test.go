@@ -0,0 +1,232 @@
+package rego_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/rego"
+)
+
+type fixture struct {
+	name        string
+	method      string
+	rules       map[string]*authorize.RuleSet
+	opts        []rego.Opt
+	params      *authorizer.RuleExecutionParams
+	expectError bool
+	expectAllow bool
+}
+
+const policy = `
+package authz
+
+import future.keywords.if
+import future.keywords.in
+
+default allow := false
+
+allow if {
+	some role in input.user.roles
+	role in data.roles[input.method]
+}
+`
+
+func ruleSet(expressions ...string) *authorize.RuleSet {
+	rs := &authorize.RuleSet{}
+	for _, expression := range expressions {
+		rs.Rules = append(rs.Rules, &authorize.Rule{Expression: expression})
+	}
+	return rs
+}
+
+var fixtures = []fixture{
+	{
+		name:   "user expression (allow)",
+		method: "/example.Service/Method",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Method": ruleSet(`input.user.role == "admin"`),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"role": "admin"},
+		},
+		expectAllow: true,
+	},
+	{
+		name:   "user expression (deny)",
+		method: "/example.Service/Method",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Method": ruleSet(`input.user.role == "admin"`),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"role": "viewer"},
+		},
+		expectAllow: false,
+	},
+	{
+		name:   "second rule (allow)",
+		method: "/example.Service/Method",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Method": ruleSet(`input.user.role == "admin"`, `input.metadata["x-api-key"] == "secret"`),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User:     map[string]any{"role": "viewer"},
+			Metadata: metadata.Pairs("x-api-key", "secret"),
+		},
+		expectAllow: true,
+	},
+	{
+		name:   "undefined field (deny)",
+		method: "/example.Service/Method",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Method": ruleSet(`input.user.missing.role == "admin"`),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"role": "admin"},
+		},
+		expectAllow: false,
+	},
+	{
+		name:   "policy module and data (allow)",
+		method: "/example.Service/Method",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Method": ruleSet(`data.authz.allow`),
+		},
+		opts: []rego.Opt{
+			rego.WithModules(map[string]string{"authz.rego": policy}),
+			rego.WithData(map[string]any{
+				"roles": map[string]any{"/example.Service/Method": []any{"editor"}},
+			}),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"roles": []string{"viewer", "editor"}},
+		},
+		expectAllow: true,
+	},
+	{
+		name:   "policy module and data (deny)",
+		method: "/example.Service/Method",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Method": ruleSet(`data.authz.allow`),
+		},
+		opts: []rego.Opt{
+			rego.WithModules(map[string]string{"authz.rego": policy}),
+			rego.WithData(map[string]any{
+				"roles": map[string]any{"/example.Service/Method": []any{"editor"}},
+			}),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"roles": []string{"viewer"}},
+		},
+		expectAllow: false,
+	},
+	{
+		name:   "proto request with json field names (allow)",
+		method: "/envoy.service.auth.v3.Authorization/Check",
+		rules: map[string]*authorize.RuleSet{
+			"/envoy.service.auth.v3.Authorization/Check": ruleSet(`input.request.attributes.request.http.method == "GET"`),
+		},
+		params: &authorizer.RuleExecutionParams{
+			Request: &authv3.CheckRequest{
+				Attributes: &authv3.AttributeContext{
+					Request: &authv3.AttributeContext_Request{
+						Http: &authv3.AttributeContext_HttpRequest{Method: "GET"},
+					},
+				},
+			},
+		},
+		expectAllow: true,
+	},
+	{
+		name:   "method, stream and deadline (allow)",
+		method: "/example.Service/Stream",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Stream": ruleSet(`input.method == "/example.Service/Stream"; input.is_stream; input.deadline_remaining > 1000000000`),
+		},
+		params: &authorizer.RuleExecutionParams{
+			IsStream: true,
+			Deadline: time.Now().Add(time.Minute),
+		},
+		expectAllow: true,
+	},
+	{
+		name:   "allow all",
+		method: "/example.Service/Method",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Method": ruleSet("*"),
+		},
+		params:      &authorizer.RuleExecutionParams{},
+		expectAllow: true,
+	},
+	{
+		name:   "method without rules in a service with rules (allow)",
+		method: "/example.Service/Other",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Method": ruleSet(`false`),
+		},
+		params:      &authorizer.RuleExecutionParams{},
+		expectAllow: true,
+	},
+	{
+		name:   "non-boolean value (deny)",
+		method: "/example.Service/Method",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Method": ruleSet(`input.user.id`, `input.user.roles`),
+		},
+		params: &authorizer.RuleExecutionParams{
+			User: map[string]any{"id": "alice", "roles": []any{"admin"}},
+		},
+		expectAllow: false,
+	},
+	{
+		name:   "malformed method (deny)",
+		method: "example.Service",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Method": ruleSet("*"),
+		},
+		params:      &authorizer.RuleExecutionParams{},
+		expectAllow: false,
+	},
+	{
+		name:   "invalid query",
+		method: "/example.Service/Method",
+		rules: map[string]*authorize.RuleSet{
+			"/example.Service/Method": ruleSet(`input.user.role ==`),
+		},
+		params:      &authorizer.RuleExecutionParams{},
+		expectError: true,
+	},
+}
+
+func TestRegoAuthorizer(t *testing.T) {
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			authz, err := rego.NewRegoAuthorizer(f.rules, f.opts...)
+			if err != nil {
+				if f.expectError {
+					return
+				}
+				t.Fatal(err)
+			}
+			allow, err := authz.AuthorizeMethod(context.Background(), f.method, f.params)
+			if err != nil {
+				if f.expectError {
+					return
+				}
+				t.Fatal(err)
+			}
+			if f.expectError {
+				t.Fatal("expected an error")
+			}
+			if allow != f.expectAllow {
+				t.Fatalf("expected allow=%v, got %v", f.expectAllow, allow)
+			}
+		})
+	}
+}
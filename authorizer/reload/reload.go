@@ -0,0 +1,222 @@
+package reload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+// Factory creates an authorizer from rules - for example cel.NewCelAuthorizer
+type Factory func(rules map[string]*authorize.RuleSet) (authorizer.Authorizer, error)
+
+// Validator is implemented by authorizers that can compile their rules upfront (CelAuthorizer, JavascriptAuthorizer
+// and MatchAuthorizer). New rules are only swapped in if Validate succeeds
+type Validator interface {
+	Validate() error
+}
+
+// Opt is a functional option for configuring a Reloadable
+type Opt func(*Reloadable)
+
+// WithFormat sets the format of the rules file. By default it is derived from the file extension
+func WithFormat(format Format) Opt {
+	return func(r *Reloadable) {
+		r.format = format
+	}
+}
+
+// WithValidator adds a function that validates new rules before they are compiled (for example to require rules
+// for a set of methods)
+func WithValidator(validate func(rules map[string]*authorize.RuleSet) error) Opt {
+	return func(r *Reloadable) {
+		r.validators = append(r.validators, validate)
+	}
+}
+
+// WithOnReload sets a callback that is called after new rules have been swapped in
+func WithOnReload(fn func(rules map[string]*authorize.RuleSet)) Opt {
+	return func(r *Reloadable) {
+		r.onReload = fn
+	}
+}
+
+// WithOnError sets a callback that is called when the rules file can't be read, parsed, validated or compiled.
+// The previous rules stay active
+func WithOnError(fn func(err error)) Opt {
+	return func(r *Reloadable) {
+		r.onError = fn
+	}
+}
+
+// WithDebounce sets how long Watch waits for further changes before reloading (default 100ms)
+func WithDebounce(d time.Duration) Opt {
+	return func(r *Reloadable) {
+		r.debounce = d
+	}
+}
+
+// Reloadable is an Authorizer that loads its rules from a file and swaps them atomically when the file changes.
+// New rules are parsed, validated and compiled before they are swapped in - if any step fails the previous rules
+// stay active and the error is reported to the WithOnError callback
+type Reloadable struct {
+	path       string
+	format     Format
+	factory    Factory
+	validators []func(rules map[string]*authorize.RuleSet) error
+	onReload   func(rules map[string]*authorize.RuleSet)
+	onError    func(err error)
+	debounce   time.Duration
+	mu         sync.Mutex
+	current    atomic.Pointer[state]
+}
+
+type state struct {
+	data       []byte
+	rules      map[string]*authorize.RuleSet
+	authorizer authorizer.Authorizer
+}
+
+// New loads the rules file and returns a Reloadable that uses the factory to create authorizers.
+// It returns an error if the initial rules are invalid. Call Watch to reload the rules when the file changes
+func New(path string, factory Factory, opts ...Opt) (*Reloadable, error) {
+	r := &Reloadable{
+		path:     path,
+		factory:  factory,
+		debounce: 100 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.format == "" {
+		format, err := FormatFromPath(path)
+		if err != nil {
+			return nil, err
+		}
+		r.format = format
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// AuthorizeMethod implements authorizer.Authorizer with the current rules
+func (r *Reloadable) AuthorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	return r.current.Load().authorizer.AuthorizeMethod(ctx, method, params)
+}
+
+// Rules returns the current rules. They must not be modified
+func (r *Reloadable) Rules() map[string]*authorize.RuleSet {
+	return r.current.Load().rules
+}
+
+// Reload reloads the rules file. It is a no-op if the file hasn't changed. Errors are also reported to the
+// WithOnError callback
+func (r *Reloadable) Reload() error {
+	if err := r.load(); err != nil {
+		if r.onError != nil {
+			r.onError(err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *Reloadable) load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("authorizer: failed to read rules file: %v", err.Error())
+	}
+	if current := r.current.Load(); current != nil && bytes.Equal(current.data, data) {
+		return nil
+	}
+	rules, err := Parse(data, r.format)
+	if err != nil {
+		return err
+	}
+	for _, validate := range r.validators {
+		if err := validate(rules); err != nil {
+			return fmt.Errorf("authorizer: invalid rules: %v", err.Error())
+		}
+	}
+	authz, err := r.factory(rules)
+	if err != nil {
+		return fmt.Errorf("authorizer: failed to create authorizer: %v", err.Error())
+	}
+	if v, ok := authz.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("authorizer: invalid rules: %v", err.Error())
+		}
+	}
+	r.current.Store(&state{
+		data:       data,
+		rules:      rules,
+		authorizer: authz,
+	})
+	if r.onReload != nil {
+		r.onReload(rules)
+	}
+	return nil
+}
+
+// Watch reloads the rules whenever the file changes until the context is canceled. The directory of the file is
+// watched so that files replaced by rename (editors, kubernetes ConfigMap volumes) are picked up
+func (r *Reloadable) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("authorizer: failed to create file watcher: %v", err.Error())
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(r.path)); err != nil {
+		return fmt.Errorf("authorizer: failed to watch rules file: %v", err.Error())
+	}
+	var (
+		timer   *time.Timer
+		timerCh <-chan time.Time
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// any change in the directory may replace the file - Reload is a no-op if the content is unchanged
+			if timer == nil {
+				timer = time.NewTimer(r.debounce)
+			} else {
+				timer.Reset(r.debounce)
+			}
+			timerCh = timer.C
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			if r.onError != nil {
+				r.onError(fmt.Errorf("authorizer: file watcher error: %v", err.Error()))
+			}
+		case <-timerCh:
+			timerCh = nil
+			_ = r.Reload()
+		}
+	}
+}
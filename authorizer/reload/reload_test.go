@@ -0,0 +1,170 @@
+package reload_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/reload"
+)
+
+const method = "/example.Service/Method"
+
+func celFactory(rules map[string]*authorize.RuleSet) (authorizer.Authorizer, error) {
+	return cel.NewCelAuthorizer(rules)
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	// write and rename so that the watcher never sees a partially written file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func allowed(t *testing.T, authz authorizer.Authorizer, role string) bool {
+	allow, err := authz.AuthorizeMethod(context.Background(), method, &authorizer.RuleExecutionParams{
+		User: map[string]any{"role": role},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return allow
+}
+
+func TestParse(t *testing.T) {
+	type parseFixture struct {
+		name   string
+		format reload.Format
+		data   string
+	}
+	fixtures := []parseFixture{
+		{
+			name:   "json",
+			format: reload.FormatJSON,
+			data:   `{"/example.Service/Method": {"rules": [{"expression": "user.role == 'admin'"}]}, "/example.Service/Other": null}`,
+		},
+		{
+			name:   "yaml",
+			format: reload.FormatYAML,
+			data: `
+/example.Service/Method:
+  rules:
+    - expression: user.role == 'admin'
+`,
+		},
+		{
+			name:   "textproto",
+			format: reload.FormatTextproto,
+			data: `
+rules {
+  key: "/example.Service/Method"
+  value { rules { expression: "user.role == 'admin'" } }
+}
+`,
+		},
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			rules, err := reload.Parse([]byte(f.data), f.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rules) != 1 || len(rules[method].GetRules()) != 1 || rules[method].Rules[0].Expression != "user.role == 'admin'" {
+				t.Fatalf("unexpected rules: %v", rules)
+			}
+		})
+	}
+}
+
+func TestReloadable_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeFile(t, path, method+":\n  rules:\n    - expression: user.role == 'admin'\n")
+	var reloadErr error
+	authz, err := reload.New(path, celFactory, reload.WithOnError(func(err error) {
+		reloadErr = err
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed(t, authz, "admin") || allowed(t, authz, "editor") {
+		t.Fatal("expected only admins to be allowed")
+	}
+
+	writeFile(t, path, method+":\n  rules:\n    - expression: user.role == 'editor'\n")
+	if err := authz.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if allowed(t, authz, "admin") || !allowed(t, authz, "editor") {
+		t.Fatal("expected the new rules to be active")
+	}
+
+	// invalid expressions are rejected and the previous rules stay active
+	writeFile(t, path, method+":\n  rules:\n    - expression: user.role ==\n")
+	if err := authz.Reload(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if reloadErr == nil {
+		t.Fatal("expected the error callback to be called")
+	}
+	if !allowed(t, authz, "editor") {
+		t.Fatal("expected the previous rules to stay active")
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	writeFile(t, path, `{"/example.Service/Method": {"rules": [{"expression": "user.role =="}]}}`)
+	if _, err := reload.New(path, celFactory); err == nil {
+		t.Fatal("expected an error for invalid initial rules")
+	}
+	if _, err := reload.New(filepath.Join(t.TempDir(), "rules.ini"), celFactory); err == nil {
+		t.Fatal("expected an error for an unsupported extension")
+	}
+}
+
+func TestReloadable_Watch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	writeFile(t, path, `{"/example.Service/Method": {"rules": [{"expression": "user.role == 'admin'"}]}}`)
+	reloaded := make(chan struct{}, 1)
+	authz, err := reload.New(path, celFactory,
+		reload.WithDebounce(10*time.Millisecond),
+		reload.WithOnReload(func(rules map[string]*authorize.RuleSet) {
+			reloaded <- struct{}{}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-reloaded
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watching := make(chan error, 1)
+	go func() {
+		watching <- authz.Watch(ctx)
+	}()
+	// give the watcher time to start
+	time.Sleep(50 * time.Millisecond)
+	writeFile(t, path, `{"/example.Service/Method": {"rules": [{"expression": "user.role == 'editor'"}]}}`)
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the rules to be reloaded")
+	}
+	if !allowed(t, authz, "editor") {
+		t.Fatal("expected the new rules to be active")
+	}
+	cancel()
+	if err := <-watching; err != nil {
+		t.Fatal(err)
+	}
+}
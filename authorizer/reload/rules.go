@@ -0,0 +1,186 @@
+package reload
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"sigs.k8s.io/yaml"
+
+	"github.com/autom8ter/proto/gen/authorize"
+)
+
+// Format is the encoding of a rules file
+type Format string
+
+const (
+	// FormatJSON is a json object of full method names to protojson encoded RuleSets - the format of the manifests
+	// generated by protoc-gen-authorize (manifest=true). Methods with null rule sets are skipped
+	FormatJSON Format = "json"
+	// FormatYAML is the yaml equivalent of FormatJSON
+	FormatYAML Format = "yaml"
+	// FormatTextproto is a text encoded message with a map<string, authorize.RuleSet> rules field:
+	//
+	//	rules {
+	//	  key: "/example.Service/Method"
+	//	  value { rules { expression: "user.is_admin" } }
+	//	}
+	FormatTextproto Format = "textproto"
+)
+
+// FormatFromPath returns the format of a rules file from its extension
+// (.json, .yaml/.yml or .textproto/.txtpb/.pbtxt)
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".textproto", ".txtpb", ".pbtxt":
+		return FormatTextproto, nil
+	default:
+		return "", fmt.Errorf("authorizer: unsupported rules file extension: %s", filepath.Ext(path))
+	}
+}
+
+// LoadFile reads and parses a rules file. The format is derived from the extension
+func LoadFile(path string) (map[string]*authorize.RuleSet, error) {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("authorizer: failed to read rules file: %v", err.Error())
+	}
+	return Parse(data, format)
+}
+
+// Parse parses rules encoded in the given format
+func Parse(data []byte, format Format) (map[string]*authorize.RuleSet, error) {
+	switch format {
+	case FormatJSON:
+		return parseJSON(data)
+	case FormatYAML:
+		converted, err := yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("authorizer: failed to parse yaml rules: %v", err.Error())
+		}
+		return parseJSON(converted)
+	case FormatTextproto:
+		return parseTextproto(data)
+	default:
+		return nil, fmt.Errorf("authorizer: unsupported rules format: %s", format)
+	}
+}
+
+func parseJSON(data []byte) (map[string]*authorize.RuleSet, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("authorizer: failed to parse json rules: %v", err.Error())
+	}
+	rules := make(map[string]*authorize.RuleSet, len(raw))
+	for method, value := range raw {
+		if string(value) == "null" {
+			continue
+		}
+		ruleSet := &authorize.RuleSet{}
+		if err := protojson.Unmarshal(value, ruleSet); err != nil {
+			return nil, fmt.Errorf("authorizer: failed to parse rules for %s: %v", method, err.Error())
+		}
+		rules[method] = ruleSet
+	}
+	return rules, nil
+}
+
+// rulesDescriptor describes the textproto message: message Rules { map<string, authorize.RuleSet> rules = 1; }
+var rulesDescriptor = func() protoreflect.MessageDescriptor {
+	ruleSet := (&authorize.RuleSet{}).ProtoReflect().Descriptor()
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("protoc_gen_authorize/reload/rules.proto"),
+		Package:    proto.String("protoc_gen_authorize.reload"),
+		Dependency: []string{ruleSet.ParentFile().Path()},
+		Syntax:     proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Rules"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{
+						Name:     proto.String("rules"),
+						JsonName: proto.String("rules"),
+						Number:   proto.Int32(1),
+						Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+						TypeName: proto.String(".protoc_gen_authorize.reload.Rules.RulesEntry"),
+					},
+				},
+				NestedType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("RulesEntry"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							{
+								Name:     proto.String("key"),
+								JsonName: proto.String("key"),
+								Number:   proto.Int32(1),
+								Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+								Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+							},
+							{
+								Name:     proto.String("value"),
+								JsonName: proto.String("value"),
+								Number:   proto.Int32(2),
+								Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+								Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+								TypeName: proto.String("." + string(ruleSet.FullName())),
+							},
+						},
+						Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+					},
+				},
+			},
+		},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(fmt.Sprintf("authorizer: failed to build rules descriptor: %v", err))
+	}
+	return file.Messages().ByName("Rules")
+}()
+
+func parseTextproto(data []byte) (map[string]*authorize.RuleSet, error) {
+	msg := dynamicpb.NewMessage(rulesDescriptor)
+	if err := prototext.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("authorizer: failed to parse textproto rules: %v", err.Error())
+	}
+	rules := map[string]*authorize.RuleSet{}
+	var err error
+	msg.Get(rulesDescriptor.Fields().ByName("rules")).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+		ruleSet, ok := v.Message().Interface().(*authorize.RuleSet)
+		if !ok {
+			// dynamic message - round trip through the wire format
+			ruleSet = &authorize.RuleSet{}
+			var b []byte
+			if b, err = proto.Marshal(v.Message().Interface()); err == nil {
+				err = proto.Unmarshal(b, ruleSet)
+			}
+			if err != nil {
+				return false
+			}
+		}
+		rules[k.String()] = ruleSet
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("authorizer: failed to parse textproto rules: %v", err.Error())
+	}
+	return rules, nil
+}
@@ -0,0 +1,162 @@
+package authorizer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// ResourceLoader loads the resource of a request - for example the order whose id is in the request - so rules can
+// use data that isn't part of the request. It should return when the context is canceled
+type ResourceLoader func(ctx context.Context, params *RuleExecutionParams) (any, error)
+
+// ResourceLoaders is a registry of named resource loaders. A rule selects a loader with a @resource(name) prefix:
+//
+//	option (authorize.rules) = {
+//	  rules: [{expression: "@resource(order) resource.owner_id == user.id"}]
+//	};
+//
+// Rules without a prefix use the loader bound to their method (see Bind). The result is injected into the expression
+// as the "resource" variable (null if the rule has no loader). It is safe for concurrent use
+type ResourceLoaders struct {
+	mu      sync.RWMutex
+	loaders map[string]ResourceLoader
+	methods map[string]string
+}
+
+// NewResourceLoaders returns a new, empty ResourceLoaders registry
+func NewResourceLoaders() *ResourceLoaders {
+	return &ResourceLoaders{
+		loaders: map[string]ResourceLoader{},
+		methods: map[string]string{},
+	}
+}
+
+// Register registers a loader under a name, replacing any loader with the same name
+func (r *ResourceLoaders) Register(name string, loader ResourceLoader) *ResourceLoaders {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loaders[name] = loader
+	return r
+}
+
+// Bind sets the loader used by the rules of a method that don't select a loader
+func (r *ResourceLoaders) Bind(method string, name string) *ResourceLoaders {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.methods[method] = name
+	return r
+}
+
+// Loader returns the loader registered under a name
+func (r *ResourceLoaders) Loader(name string) (ResourceLoader, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	loader, ok := r.loaders[name]
+	return loader, ok
+}
+
+// LoaderName returns the name of the loader of a rule expression of a method - the @resource(name) prefix of the
+// expression or the loader bound to the method. It returns an empty string if the rule has no loader
+func (r *ResourceLoaders) LoaderName(method string, expression string) string {
+	if name, _ := SplitResourceLoader(expression); name != "" {
+		return name
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.methods[method]
+}
+
+// Validate returns an error if a method is bound to a loader that isn't registered or if one of the rule expressions
+// selects a loader that isn't registered
+func (r *ResourceLoaders) Validate(expressions ...string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for method, name := range r.methods {
+		if _, ok := r.loaders[name]; !ok {
+			return fmt.Errorf("authorizer: %s is bound to unknown resource loader %s", method, name)
+		}
+	}
+	for _, expression := range expressions {
+		if name, _ := SplitResourceLoader(expression); name != "" {
+			if _, ok := r.loaders[name]; !ok {
+				return fmt.Errorf("authorizer: unknown resource loader %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// resourcePrefix matches the @resource(name) prefix of a rule expression
+var resourcePrefix = regexp.MustCompile(`^\s*@resource\(\s*([\w.\-]+)\s*\)\s*`)
+
+// SplitResourceLoader splits a rule expression into the name of its @resource(name) prefix and the expression
+// without the prefix. The name is empty if the expression has no prefix
+func SplitResourceLoader(expression string) (string, string) {
+	match := resourcePrefix.FindStringSubmatch(expression)
+	if match == nil {
+		return "", expression
+	}
+	return match[1], expression[len(match[0]):]
+}
+
+// Resources loads the resources of a single request. Every loader is called at most once - concurrent and later
+// calls share the result
+type Resources struct {
+	loaders *ResourceLoaders
+	params  *RuleExecutionParams
+	mu      sync.Mutex
+	results map[string]*resourceResult
+}
+
+type resourceResult struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+// Resources returns a memoizing loader for the resources of a request. A nil registry loads no resources
+func (r *ResourceLoaders) Resources(params *RuleExecutionParams) *Resources {
+	return &Resources{
+		loaders: r,
+		params:  params,
+		results: map[string]*resourceResult{},
+	}
+}
+
+// Load returns the resource of the named loader. It returns the context's error if the context is canceled before
+// the loader returns
+func (r *Resources) Load(ctx context.Context, name string) (any, error) {
+	if r.loaders == nil {
+		return nil, fmt.Errorf("authorizer: unknown resource loader %s", name)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	result, ok := r.results[name]
+	if !ok {
+		loader, exists := r.loaders.Loader(name)
+		if !exists {
+			r.mu.Unlock()
+			return nil, fmt.Errorf("authorizer: unknown resource loader %s", name)
+		}
+		result = &resourceResult{done: make(chan struct{})}
+		r.results[name] = result
+		go func() {
+			defer close(result.done)
+			result.value, result.err = loader(ctx, r.params)
+		}()
+	}
+	r.mu.Unlock()
+	select {
+	case <-result.done:
+		if result.err != nil {
+			return nil, fmt.Errorf("authorizer: failed to load resource %s: %v", name, result.err.Error())
+		}
+		return result.value, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,99 @@
+package authorizer_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+)
+
+func TestSplitResourceLoader(t *testing.T) {
+	for expression, expected := range map[string][2]string{
+		"@resource(order) resource.owner == user.id":   {"order", "resource.owner == user.id"},
+		" @resource( orders.v1 )resource.owner == 'x'": {"orders.v1", "resource.owner == 'x'"},
+		"user.id == 'x'":                {"", "user.id == 'x'"},
+		"user.id == '@resource(order)'": {"", "user.id == '@resource(order)'"},
+	} {
+		name, rest := authorizer.SplitResourceLoader(expression)
+		if name != expected[0] || rest != expected[1] {
+			t.Fatalf("%s: expected (%q, %q), got (%q, %q)", expression, expected[0], expected[1], name, rest)
+		}
+	}
+}
+
+func TestResources_Memoized(t *testing.T) {
+	var calls atomic.Int32
+	loaders := authorizer.NewResourceLoaders().Register("order", func(ctx context.Context, params *authorizer.RuleExecutionParams) (any, error) {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return map[string]any{"owner": "alice"}, nil
+	})
+	resources := loaders.Resources(&authorizer.RuleExecutionParams{})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := resources.Load(context.Background(), "order"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Fatalf("expected the loader to be called once, got %d", calls.Load())
+	}
+	// a new request loads the resource again
+	if _, err := loaders.Resources(&authorizer.RuleExecutionParams{}).Load(context.Background(), "order"); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("expected the loader to be called twice, got %d", calls.Load())
+	}
+}
+
+func TestResources_Canceled(t *testing.T) {
+	loaders := authorizer.NewResourceLoaders().Register("slow", func(ctx context.Context, params *authorizer.RuleExecutionParams) (any, error) {
+		time.Sleep(time.Second)
+		return nil, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := loaders.Resources(&authorizer.RuleExecutionParams{}).Load(ctx, "slow")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline exceeded error, got %v", err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("expected Load to return when the context is canceled")
+	}
+}
+
+func TestResourceLoaders_Validate(t *testing.T) {
+	loaders := authorizer.NewResourceLoaders().Bind("/example.Orders/Get", "order")
+	if err := loaders.Validate(); err == nil {
+		t.Fatal("expected an error for an unknown loader")
+	}
+	if _, err := loaders.Resources(&authorizer.RuleExecutionParams{}).Load(context.Background(), "order"); err == nil {
+		t.Fatal("expected an error for an unknown loader")
+	}
+	loaders.Register("order", func(ctx context.Context, params *authorizer.RuleExecutionParams) (any, error) {
+		return nil, nil
+	})
+	if err := loaders.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaders.Validate("@resource(invoice) resource.owner == user.id"); err == nil {
+		t.Fatal("expected an error for an expression selecting an unknown loader")
+	}
+	if err := loaders.Validate("@resource(order) resource.owner == user.id", "user.id == 'alice'"); err != nil {
+		t.Fatal(err)
+	}
+	if name := loaders.LoaderName("/example.Orders/Get", "resource.owner == user.id"); name != "order" {
+		t.Fatalf("expected the bound loader, got %q", name)
+	}
+}
@@ -0,0 +1,164 @@
+package authorizer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultShadowTimeout is the default time a candidate authorizer has to reach a decision (see WithShadowTimeout)
+const DefaultShadowTimeout = time.Second
+
+// ShadowResult is the outcome of evaluating a candidate authorizer next to the enforced one
+type ShadowResult struct {
+	// Method is the full grpc method name
+	Method string
+	// Params are the parameters the candidate was evaluated with - a snapshot taken before the handler was called
+	Params *RuleExecutionParams
+	// Enforced is the decision of the enforced authorizer
+	Enforced bool
+	// EnforcedErr is the error returned by the enforced authorizer (if any)
+	EnforcedErr error
+	// Candidate is the decision of the candidate authorizer
+	Candidate bool
+	// CandidateErr is the error returned by the candidate authorizer (if any)
+	CandidateErr error
+}
+
+// Disagree returns true if the candidate authorizer reached a different decision than the enforced one
+func (r *ShadowResult) Disagree() bool {
+	return (r.Enforced && r.EnforcedErr == nil) != (r.Candidate && r.CandidateErr == nil) ||
+		(r.EnforcedErr == nil) != (r.CandidateErr == nil)
+}
+
+// ShadowHook is a function that is called when the candidate authorizer disagrees with the enforced authorizer
+type ShadowHook func(ctx context.Context, r *ShadowResult)
+
+// WithShadowAuthorizer evaluates the candidate authorizer next to the enforced authorizer for every request and calls
+// the hook when they disagree. The candidate never affects the response - its errors (and panics) are only reported
+// to the hook. The candidate runs in its own goroutine after the enforced decision so it adds no latency to the
+// request. It is evaluated with a snapshot of the parameters (proto messages are cloned, decoded json maps and
+// slices copied) so that handlers can modify their request while it runs. Its context is detached from the request's cancellation and bounded by the shadow timeout
+// (DefaultShadowTimeout) - candidates that exceed it are reported with a timeout error. The hook is called from that
+// goroutine
+func WithShadowAuthorizer(candidate Authorizer, hook ShadowHook) Opt {
+	return func(o *options) {
+		o.shadowAuthorizer = candidate
+		o.shadowHook = hook
+	}
+}
+
+// WithShadowTimeout sets the time a candidate authorizer has to reach a decision (default DefaultShadowTimeout)
+func WithShadowTimeout(timeout time.Duration) Opt {
+	return func(o *options) {
+		o.shadowTimeout = timeout
+	}
+}
+
+// WithDryRun puts the interceptor in log-only mode: requests that are denied by the authorizer (or that fail to
+// evaluate) are allowed. Decision hooks still see the real decision with Decision.DryRun set.
+// Errors returned by the user extractor are still returned to the client
+func WithDryRun() Opt {
+	return func(o *options) {
+		o.dryRun = true
+	}
+}
+
+// LogShadowDisagreements returns a ShadowHook that logs disagreements to the given logger at Warn level
+func LogShadowDisagreements(logger *slog.Logger) ShadowHook {
+	return func(ctx context.Context, r *ShadowResult) {
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.Bool("enforced", r.Enforced && r.EnforcedErr == nil),
+			slog.Bool("candidate", r.Candidate && r.CandidateErr == nil),
+		}
+		if r.EnforcedErr != nil {
+			attrs = append(attrs, slog.String("enforced_error", r.EnforcedErr.Error()))
+		}
+		if r.CandidateErr != nil {
+			attrs = append(attrs, slog.String("candidate_error", r.CandidateErr.Error()))
+		}
+		logger.LogAttrs(ctx, slog.LevelWarn, "authorizer: shadow disagreement", attrs...)
+	}
+}
+
+// shadow evaluates the candidate authorizer in the background
+func (o *options) shadow(ctx context.Context, method string, params *RuleExecutionParams, enforced bool, enforcedErr error) {
+	timeout := o.shadowTimeout
+	if timeout <= 0 {
+		timeout = DefaultShadowTimeout
+	}
+	// the candidate must not be canceled when the request returns, but it must not run forever either
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	// give the candidate its own evaluation so it doesn't overwrite the enforced authorizer's matched rule
+	ctx, _ = WithEvaluation(ctx)
+	// the handler runs concurrently and may modify the request
+	params = snapshotParams(params)
+	go func() {
+		defer cancel()
+		o.evaluateShadow(ctx, method, params, enforced, enforcedErr)
+	}()
+}
+
+func (o *options) evaluateShadow(ctx context.Context, method string, params *RuleExecutionParams, enforced bool, enforcedErr error) {
+	r := &ShadowResult{
+		Method:      method,
+		Params:      params,
+		Enforced:    enforced,
+		EnforcedErr: enforcedErr,
+	}
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				r.Candidate, r.CandidateErr = false, fmt.Errorf("authorizer: shadow authorizer panicked: %v", p)
+			}
+		}()
+		r.Candidate, r.CandidateErr = o.shadowAuthorizer.AuthorizeMethod(ctx, method, params)
+	}()
+	if r.CandidateErr == nil && ctx.Err() != nil {
+		r.Candidate, r.CandidateErr = false, fmt.Errorf("authorizer: shadow authorizer timed out: %v", ctx.Err().Error())
+	}
+	if r.Disagree() && o.shadowHook != nil {
+		o.shadowHook(ctx, r)
+	}
+}
+
+// snapshotParams returns a copy of the params that doesn't share the request, user and metadata with the handler.
+// Proto messages are cloned and decoded json (maps and slices) copied, other values are shared
+func snapshotParams(params *RuleExecutionParams) *RuleExecutionParams {
+	if params == nil {
+		return nil
+	}
+	snapshot := *params
+	snapshot.Request = snapshotValue(params.Request)
+	snapshot.User = snapshotValue(params.User)
+	snapshot.Metadata = params.Metadata.Copy()
+	if params.Peer != nil {
+		peer := *params.Peer
+		snapshot.Peer = &peer
+	}
+	return &snapshot
+}
+
+func snapshotValue(v any) any {
+	switch v := v.(type) {
+	case proto.Message:
+		return proto.Clone(v)
+	case map[string]any:
+		values := make(map[string]any, len(v))
+		for k, e := range v {
+			values[k] = snapshotValue(e)
+		}
+		return values
+	case []any:
+		values := make([]any, len(v))
+		for i, e := range v {
+			values[i] = snapshotValue(e)
+		}
+		return values
+	}
+	return v
+}
@@ -0,0 +1,242 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/policy"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/reload"
+)
+
+// ReasonUnknownTenant is the ErrorInfo reason of requests for unknown tenants and requests without a tenant
+// (see WithStrict)
+const ReasonUnknownTenant = "UNKNOWN_TENANT"
+
+// KeyFunc returns the tenant of a request. An empty tenant selects the default rules
+type KeyFunc func(ctx context.Context, params *authorizer.RuleExecutionParams) (string, error)
+
+// FromMetadata returns a KeyFunc that reads the tenant from a metadata key (for example x-tenant-id).
+// The key is sent by the client, so it must only be used behind a trusted proxy that sets the key (or strips it from
+// client requests) - otherwise any client can select the rules of another tenant. Prefer FromUser with a tenant claim
+// of an authenticated user
+func FromMetadata(key string) KeyFunc {
+	key = strings.ToLower(key)
+	return func(ctx context.Context, params *authorizer.RuleExecutionParams) (string, error) {
+		if values := params.Metadata.Get(key); len(values) > 0 {
+			return values[0], nil
+		}
+		return "", nil
+	}
+}
+
+// FromUser returns a KeyFunc that reads the tenant from a dot separated field path of the user
+// (for example "org.id"). Maps, structs and proto messages (proto or json field names) are supported
+func FromUser(path string) KeyFunc {
+	return func(ctx context.Context, params *authorizer.RuleExecutionParams) (string, error) {
+		return lookup(params.User, path), nil
+	}
+}
+
+// FromRequest returns a KeyFunc that reads the tenant from a dot separated field path of the request
+// (for example "tenant_id")
+func FromRequest(path string) KeyFunc {
+	return func(ctx context.Context, params *authorizer.RuleExecutionParams) (string, error) {
+		return lookup(params.Request, path), nil
+	}
+}
+
+// FirstOf returns a KeyFunc that returns the first non-empty tenant of the given functions. Put trusted sources
+// (FromUser) before FromMetadata so that a client can't override them
+func FirstOf(keys ...KeyFunc) KeyFunc {
+	return func(ctx context.Context, params *authorizer.RuleExecutionParams) (string, error) {
+		for _, key := range keys {
+			tenant, err := key(ctx, params)
+			if err != nil {
+				return "", err
+			}
+			if tenant != "" {
+				return tenant, nil
+			}
+		}
+		return "", nil
+	}
+}
+
+// Opt is a functional option for configuring an Authorizer
+type Opt func(*Authorizer)
+
+// WithTenant sets the rules of a tenant
+func WithTenant(tenant string, rules map[string]*authorize.RuleSet) Opt {
+	return func(a *Authorizer) {
+		a.tenants[tenant] = rules
+	}
+}
+
+// WithTenants sets the rules of many tenants
+func WithTenants(tenants map[string]map[string]*authorize.RuleSet) Opt {
+	return func(a *Authorizer) {
+		for tenant, rules := range tenants {
+			a.tenants[tenant] = rules
+		}
+	}
+}
+
+// WithStrict denies requests of tenants without rules and requests without a tenant instead of evaluating them with
+// the default rules
+func WithStrict() Opt {
+	return func(a *Authorizer) {
+		a.strict = true
+	}
+}
+
+// WithReplace makes the rules of a tenant replace the default rules instead of being merged over them
+func WithReplace() Opt {
+	return func(a *Authorizer) {
+		a.replace = true
+	}
+}
+
+// Authorizer selects the rules of the tenant of a request. By default the rules of a tenant are merged over the
+// default rules (the map generated by protoc-gen-authorize): methods defined for the tenant override the defaults and
+// other methods keep their default rules. Tenant authorizers are compiled with the factory on first use and cached
+type Authorizer struct {
+	key      KeyFunc
+	factory  reload.Factory
+	defaults authorizer.Authorizer
+	strict   bool
+	replace  bool
+
+	mu           sync.RWMutex
+	defaultRules map[string]*authorize.RuleSet
+	tenants      map[string]map[string]*authorize.RuleSet
+	compiled     map[string]*compiled
+}
+
+// compiled is the authorizer of a tenant. It holds the rules it was created for so that an authorizer compiled after
+// SetTenant never uses the previous rules
+type compiled struct {
+	rules      map[string]*authorize.RuleSet
+	once       sync.Once
+	authorizer authorizer.Authorizer
+	err        error
+}
+
+// New returns an Authorizer that uses the factory (for example cel.NewCelAuthorizer) to compile the default and tenant
+// rules and the key function to select the tenant of a request
+func New(defaults map[string]*authorize.RuleSet, factory reload.Factory, key KeyFunc, opts ...Opt) (*Authorizer, error) {
+	a := &Authorizer{
+		key:          key,
+		factory:      factory,
+		defaultRules: defaults,
+		tenants:      map[string]map[string]*authorize.RuleSet{},
+		compiled:     map[string]*compiled{},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	authz, err := factory(defaults)
+	if err != nil {
+		return nil, fmt.Errorf("authorizer: failed to create default authorizer: %v", err.Error())
+	}
+	a.defaults = authz
+	return a, nil
+}
+
+// SetTenant sets (or with nil rules removes) the rules of a tenant at runtime. The tenant is compiled again on its
+// next request
+func (a *Authorizer) SetTenant(tenant string, rules map[string]*authorize.RuleSet) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if rules == nil {
+		delete(a.tenants, tenant)
+	} else {
+		a.tenants[tenant] = rules
+	}
+	delete(a.compiled, tenant)
+}
+
+// Tenants returns the tenants with rules
+func (a *Authorizer) Tenants() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	tenants := make([]string, 0, len(a.tenants))
+	for tenant := range a.tenants {
+		tenants = append(tenants, tenant)
+	}
+	return tenants
+}
+
+// AuthorizeMethod implements authorizer.Authorizer with the rules of the tenant of the request
+func (a *Authorizer) AuthorizeMethod(ctx context.Context, method string, params *authorizer.RuleExecutionParams) (bool, error) {
+	tenant, err := a.key(ctx, params)
+	if err != nil {
+		return false, err
+	}
+	authz, err := a.authorizer(method, tenant)
+	if err != nil {
+		return false, err
+	}
+	return authz.AuthorizeMethod(ctx, method, params)
+}
+
+// authorizer returns the (cached) authorizer of the tenant
+func (a *Authorizer) authorizer(method string, tenant string) (authorizer.Authorizer, error) {
+	if tenant == "" {
+		if a.strict {
+			return nil, authorizer.Error(codes.PermissionDenied, ReasonUnknownTenant, "authorizer: missing tenant", map[string]string{
+				"method": method,
+			})
+		}
+		return a.defaults, nil
+	}
+	a.mu.RLock()
+	c, ok := a.compiled[tenant]
+	a.mu.RUnlock()
+	if !ok {
+		// the rules are read and the entry is created under one lock so a concurrent SetTenant can't be missed
+		a.mu.Lock()
+		if c, ok = a.compiled[tenant]; !ok {
+			if rules, hasRules := a.tenants[tenant]; hasRules {
+				c = &compiled{rules: rules}
+				a.compiled[tenant] = c
+			}
+		}
+		a.mu.Unlock()
+	}
+	if c == nil {
+		if a.strict {
+			return nil, authorizer.Error(codes.PermissionDenied, ReasonUnknownTenant, "authorizer: unknown tenant", map[string]string{
+				"method": method,
+				"tenant": tenant,
+			})
+		}
+		return a.defaults, nil
+	}
+	c.once.Do(func() {
+		rules := c.rules
+		if !a.replace {
+			rules = policy.Merge(a.defaultRules, rules)
+		}
+		c.authorizer, c.err = a.factory(rules)
+		if c.err != nil {
+			c.err = fmt.Errorf("authorizer: failed to create authorizer for tenant %s: %v", tenant, c.err.Error())
+		}
+	})
+	return c.authorizer, c.err
+}
+
+// lookup returns the value of a dot separated field path as a string (empty if the path doesn't exist)
+func lookup(v any, path string) string {
+	value, ok := authorizer.LookupField(v, path)
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,204 @@
+package tenant_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/tenant"
+)
+
+const (
+	method      = "/example.Service/Method"
+	otherMethod = "/example.Service/Other"
+)
+
+func ruleSet(expression string) *authorize.RuleSet {
+	return &authorize.RuleSet{Rules: []*authorize.Rule{{Expression: expression}}}
+}
+
+// newAuthorizer returns an authorizer with an acme tenant and a counter of the compiled rule maps
+func newAuthorizer(t *testing.T, key tenant.KeyFunc, opts ...tenant.Opt) (*tenant.Authorizer, *int) {
+	compiles := 0
+	authz, err := tenant.New(map[string]*authorize.RuleSet{
+		method:      ruleSet("user.role == 'admin'"),
+		otherMethod: ruleSet("user.role == 'viewer'"),
+	}, func(rules map[string]*authorize.RuleSet) (authorizer.Authorizer, error) {
+		compiles++
+		return cel.NewCelAuthorizer(rules)
+	}, key, append([]tenant.Opt{
+		tenant.WithTenant("acme", map[string]*authorize.RuleSet{
+			method: ruleSet("user.role == 'editor'"),
+		}),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authz, &compiles
+}
+
+func TestAuthorizer(t *testing.T) {
+	type fixture struct {
+		name    string
+		tenant  string
+		method  string
+		role    string
+		allowed bool
+	}
+	fixtures := []fixture{
+		{name: "default rules", tenant: "", method: method, role: "admin", allowed: true},
+		{name: "default rules deny", tenant: "", method: method, role: "editor", allowed: false},
+		{name: "tenant rules", tenant: "acme", method: method, role: "editor", allowed: true},
+		{name: "tenant rules override defaults", tenant: "acme", method: method, role: "admin", allowed: false},
+		{name: "tenant falls back to defaults", tenant: "acme", method: otherMethod, role: "viewer", allowed: true},
+		{name: "unknown tenant uses defaults", tenant: "globex", method: method, role: "admin", allowed: true},
+	}
+	authz, compiles := newAuthorizer(t, tenant.FromMetadata("X-Tenant-ID"))
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			md := metadata.MD{}
+			if f.tenant != "" {
+				md.Set("x-tenant-id", f.tenant)
+			}
+			allowed, err := authz.AuthorizeMethod(context.Background(), f.method, &authorizer.RuleExecutionParams{
+				User:     map[string]any{"role": f.role},
+				Metadata: md,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != f.allowed {
+				t.Fatalf("expected allowed=%v, got %v", f.allowed, allowed)
+			}
+		})
+	}
+	// the defaults and the acme tenant are only compiled once
+	if *compiles != 2 {
+		t.Fatalf("expected 2 compilations, got %d", *compiles)
+	}
+}
+
+func TestAuthorizer_Strict(t *testing.T) {
+	authz, _ := newAuthorizer(t, tenant.FromUser("org.id"), tenant.WithStrict(), tenant.WithReplace())
+	params := func(org string) *authorizer.RuleExecutionParams {
+		return &authorizer.RuleExecutionParams{
+			User: map[string]any{"role": "viewer", "org": map[string]any{"id": org}},
+		}
+	}
+	_, err := authz.AuthorizeMethod(context.Background(), method, params("globex"))
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for an unknown tenant, got %v", err)
+	}
+	// requests without a tenant don't fall back to the default rules (which would allow the admin)
+	admin := params("")
+	admin.User.(map[string]any)["role"] = "admin"
+	_, err = authz.AuthorizeMethod(context.Background(), method, admin)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for a missing tenant, got %v", err)
+	}
+	// with WithReplace the tenant has no rules for otherMethod, so the default rules (which would deny the
+	// editor) don't apply
+	editor := params("acme")
+	editor.User.(map[string]any)["role"] = "editor"
+	allowed, err := authz.AuthorizeMethod(context.Background(), otherMethod, editor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Fatal("expected the default rules to be replaced")
+	}
+
+	authz.SetTenant("globex", map[string]*authorize.RuleSet{
+		method: ruleSet("user.role == 'viewer'"),
+	})
+	allowed, err = authz.AuthorizeMethod(context.Background(), method, params("globex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Fatal("expected the new tenant rules to be active")
+	}
+}
+
+func TestAuthorizer_SetTenantConcurrent(t *testing.T) {
+	authz, err := tenant.New(map[string]*authorize.RuleSet{}, func(rules map[string]*authorize.RuleSet) (authorizer.Authorizer, error) {
+		return cel.NewCelAuthorizer(rules)
+	}, tenant.FromMetadata("x-tenant-id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := func(role string) bool {
+		allowed, err := authz.AuthorizeMethod(context.Background(), method, &authorizer.RuleExecutionParams{
+			User:     map[string]any{"role": role},
+			Metadata: metadata.Pairs("x-tenant-id", "acme"),
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		return allowed
+	}
+	for i := 0; i < 50; i++ {
+		role := fmt.Sprintf("role-%d", i)
+		var wg sync.WaitGroup
+		for j := 0; j < 4; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				allowed(role)
+			}()
+		}
+		authz.SetTenant("acme", map[string]*authorize.RuleSet{
+			method: ruleSet(fmt.Sprintf("user.role == '%s'", role)),
+		})
+		wg.Wait()
+		// requests racing with SetTenant must not cache the previous rules
+		if !allowed(role) {
+			t.Fatalf("expected the rules of iteration %d to be active", i)
+		}
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	type user struct {
+		TenantID string
+	}
+	params := &authorizer.RuleExecutionParams{
+		User:     &user{TenantID: "from-user"},
+		Request:  &descriptorpb.FileDescriptorProto{Package: proto.String("from-request")},
+		Metadata: metadata.Pairs("x-tenant-id", "from-metadata"),
+	}
+	type fixture struct {
+		name   string
+		key    tenant.KeyFunc
+		tenant string
+	}
+	fixtures := []fixture{
+		{name: "metadata", key: tenant.FromMetadata("x-tenant-id"), tenant: "from-metadata"},
+		{name: "struct user", key: tenant.FromUser("tenant_id"), tenant: "from-user"},
+		{name: "proto request", key: tenant.FromRequest("package"), tenant: "from-request"},
+		{name: "missing field", key: tenant.FromRequest("options.deprecated"), tenant: ""},
+		{name: "first of", key: tenant.FirstOf(tenant.FromMetadata("x-org"), tenant.FromUser("tenant_id")), tenant: "from-user"},
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			got, err := f.key(context.Background(), params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != f.tenant {
+				t.Fatalf("expected tenant %q, got %q", f.tenant, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package authorizer
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TracerName is the name of the tracer used to create authorization spans
+	TracerName = "github.com/storm-blue/protoc-gen-authorize/authorizer"
+	// SpanName is the name of the span created for every authorization decision
+	SpanName = "authorize.evaluate"
+)
+
+// WithTracerProvider enables tracing of authorization decisions. A span named "authorize.evaluate" is created for every
+// request that is authorized by the interceptor. Enable tracing on either the interceptor or the backend, not both
+func WithTracerProvider(tp trace.TracerProvider) Opt {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+// TraceEvaluation runs the evaluation function in an "authorize.evaluate" span created with the given tracer provider.
+// The span is annotated with the method, backend, decision, number of rules evaluated and index of the matched rule.
+// Errors returned by the evaluation function are recorded on the span
+func TraceEvaluation(ctx context.Context, tp trace.TracerProvider, backend string, method string, evaluate func(ctx context.Context) (bool, error)) (bool, error) {
+	eval, ok := EvaluationFromContext(ctx)
+	if !ok {
+		ctx, eval = WithEvaluation(ctx)
+	}
+	ctx, span := tp.Tracer(TracerName).Start(ctx, SpanName, trace.WithAttributes(
+		attribute.String("authorize.method", method),
+	))
+	defer span.End()
+	allow, err := evaluate(ctx)
+	if backend == "" {
+		backend = eval.Backend
+	}
+	decision := "deny"
+	switch {
+	case err != nil:
+		decision = "error"
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	case allow:
+		decision = "allow"
+	}
+	span.SetAttributes(
+		attribute.String("authorize.backend", backend),
+		attribute.String("authorize.decision", decision),
+		attribute.Int("authorize.rules_evaluated", eval.RulesEvaluated),
+		attribute.Int("authorize.matched_rule", eval.MatchedRule),
+	)
+	return allow, err
+}
@@ -0,0 +1,69 @@
+package authorizer
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// HealthCheckMethods matches the grpc health checking service methods.
+	// Use it with AddWhiteListMethods(HealthCheckMethods...) to exempt health checks from authorization
+	HealthCheckMethods = []string{"/grpc.health.v1.Health/*"}
+	// ReflectionMethods matches the grpc server reflection service methods.
+	// Use it with AddWhiteListMethods(ReflectionMethods...) to exempt server reflection from authorization
+	ReflectionMethods = []string{
+		"/grpc.reflection.v1.ServerReflection/*",
+		"/grpc.reflection.v1alpha.ServerReflection/*",
+	}
+)
+
+// methodMatcher matches full grpc method names against exact names, glob patterns and regular expressions.
+// It is built once when the interceptor is created
+type methodMatcher struct {
+	exact   map[string]struct{}
+	globs   *regexp.Regexp
+	regexps []*regexp.Regexp
+}
+
+func newMethodMatcher(patterns []string, regexps []*regexp.Regexp) *methodMatcher {
+	m := &methodMatcher{
+		exact:   map[string]struct{}{},
+		regexps: regexps,
+	}
+	var globs []string
+	for _, p := range patterns {
+		if !strings.Contains(p, "*") {
+			m.exact[p] = struct{}{}
+			continue
+		}
+		globs = append(globs, globToRegexp(p))
+	}
+	if len(globs) > 0 {
+		m.globs = regexp.MustCompile("^(?:" + strings.Join(globs, "|") + ")$")
+	}
+	return m
+}
+
+// globToRegexp converts a glob pattern to a regular expression. * matches any sequence of characters except /
+func globToRegexp(pattern string) string {
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return strings.Join(parts, "[^/]*")
+}
+
+func (m *methodMatcher) Match(method string) bool {
+	if _, ok := m.exact[method]; ok {
+		return true
+	}
+	if m.globs != nil && m.globs.MatchString(method) {
+		return true
+	}
+	for _, r := range m.regexps {
+		if r.MatchString(method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+// Command authorize-diff prints a semantic diff of the authorize.rules options between two FileDescriptorSets or
+// two manifests generated by protoc-gen-authorize (manifest=true). Directories are searched for manifests, which are
+// merged - use them to compare the output of several Go packages.
+//
+// It exits with status 1 if the new policy grants access that the old policy did not.
+//
+//	authorize-diff [-json] old.binpb new.binpb
+//	authorize-diff [-json] old/gen new/gen
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/storm-blue/protoc-gen-authorize/policydiff"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	jsonOutput := flag.Bool("json", false, "print the diff as json")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-json] <old> <new>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		return 2
+	}
+	oldPolicy, err := policydiff.Load(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	newPolicy, err := policydiff.Load(flag.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	changes := policydiff.Diff(oldPolicy, newPolicy)
+	looser := policydiff.Looser(changes)
+	if changes == nil {
+		changes = []policydiff.Change{}
+	}
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(struct {
+			Changes []policydiff.Change `json:"changes"`
+			Looser  bool                `json:"looser"`
+		}{
+			Changes: changes,
+			Looser:  looser,
+		}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	} else {
+		for _, c := range changes {
+			fmt.Println(c.String())
+		}
+	}
+	if looser {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+// Command authorize-extauthz serves the Envoy ext_authz gRPC API (envoy.service.auth.v3.Authorization) for the
+// authorize.rules of a FileDescriptorSet or a manifest generated by protoc-gen-authorize (manifest=true).
+//
+// Without -user-header requests are evaluated without a user. With -user-header the user is decoded from a json (or
+// base64url json) request header, for example the payload header forwarded by Envoy's jwt_authn filter. The header
+// is trusted as is: Envoy must strip or overwrite it on every request (jwt_authn with forward_payload_header does when
+// the token is required) - otherwise any client can claim any identity.
+//
+//	authorize-extauthz -rules rules.pb.authorizer.json [-authorizer cel] [-addr :9001] [-user-header x-jwt-payload]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"google.golang.org/grpc"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cedar"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cel"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/extauthz"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/javascript"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/match"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/rego"
+	"github.com/storm-blue/protoc-gen-authorize/policydiff"
+)
+
+func main() {
+	rulesPath := flag.String("rules", "", "path to a FileDescriptorSet or a protoc-gen-authorize manifest (.json)")
+	backend := flag.String("authorizer", "cel", "authorizer backend (cel, javascript, match, rego, cedar)")
+	addr := flag.String("addr", ":9001", "address to listen on")
+	userHeader := flag.String("user-header", "", "trusted request header containing the json user (the proxy must strip it from client requests)")
+	flag.Parse()
+	if *rulesPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	authz, err := newAuthorizer(*rulesPath, *backend)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// no user unless a trusted header is configured explicitly
+	userExtractor := func(ctx context.Context) (any, error) {
+		return nil, nil
+	}
+	if *userHeader != "" {
+		userExtractor = extauthz.HeaderUserExtractor(*userHeader)
+	}
+	srv := grpc.NewServer()
+	extauthz.NewServer(authz, extauthz.WithAuthorizerOpts(
+		authorizer.WithUserExtractor(userExtractor),
+	)).Register(srv)
+	log.Printf("authorize-extauthz: listening on %s", lis.Addr())
+	if err := srv.Serve(lis); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func newAuthorizer(path string, backend string) (authorizer.Authorizer, error) {
+	policy, err := policydiff.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := map[string]*authorize.RuleSet{}
+	for method, ruleSet := range policy {
+		if ruleSet != nil {
+			rules[method] = ruleSet
+		}
+	}
+	switch backend {
+	case "cel":
+		return cel.NewCelAuthorizer(rules)
+	case "javascript":
+		return javascript.NewJavascriptAuthorizer(rules)
+	case "match":
+		return match.NewMatchAuthorizer(rules)
+	case "rego":
+		return rego.NewRegoAuthorizer(rules)
+	case "cedar":
+		return cedar.NewCedarAuthorizer(rules)
+	default:
+		return nil, fmt.Errorf("authorize-extauthz: unsupported authorizer: %s", backend)
+	}
+}
@@ -2,11 +2,15 @@ package module
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cedar"
 	"github.com/storm-blue/protoc-gen-authorize/authorizer/match"
 	"strings"
 	"text/template"
 
+	"google.golang.org/protobuf/encoding/protojson"
+
 	pgs "github.com/lyft/protoc-gen-star"
 	pgsgo "github.com/lyft/protoc-gen-star/lang/go"
 
@@ -19,6 +23,7 @@ type module struct {
 	*pgs.ModuleBase
 	pgsgo.Context
 	authorizer string
+	manifest   bool
 }
 
 func New() pgs.Module {
@@ -38,6 +43,11 @@ func (m *module) InitContext(c pgs.BuildContext) {
 		m.authorizer = "cel"
 	}
 	m.authorizer = strings.ToLower(m.authorizer)
+	manifest, err := params.Bool("manifest")
+	if err != nil {
+		m.AddError(err.Error())
+	}
+	m.manifest = manifest
 }
 
 func (m *module) Execute(targets map[string]pgs.File, packages map[string]pgs.Package) []pgs.Artifact {
@@ -63,6 +73,8 @@ func (m *module) Execute(targets map[string]pgs.File, packages map[string]pgs.Pa
 // generateForPackage generates a single authorizer file for all services in a Go package
 func (m *module) generateForPackage(goPackage string, files []pgs.File) {
 	var rules = map[string]*authorize.RuleSet{}
+	// fullMethod name => RuleSet (nil if the method has no rules), used for the json manifest
+	var manifest = map[string]*authorize.RuleSet{}
 	var firstFile pgs.File // Used for generating the output file name
 
 	// Collect rules from all files in this package
@@ -73,6 +85,8 @@ func (m *module) generateForPackage(goPackage string, files []pgs.File) {
 
 		for _, s := range f.Services() {
 			for _, method := range s.Methods() {
+				fullMethod := fmt.Sprintf("/%s/%s", strings.TrimPrefix(s.FullyQualifiedName(), "."), method.Name())
+				manifest[fullMethod] = nil
 				var ruleSet authorize.RuleSet
 				ok, err := method.Extension(authorize.E_Rules, &ruleSet)
 				if err != nil {
@@ -82,6 +96,7 @@ func (m *module) generateForPackage(goPackage string, files []pgs.File) {
 				if !ok {
 					continue
 				}
+				manifest[fullMethod] = &ruleSet
 
 				if m.authorizer == "match" {
 					for _, r := range ruleSet.Rules {
@@ -99,10 +114,6 @@ func (m *module) generateForPackage(goPackage string, files []pgs.File) {
 		}
 	}
 
-	if len(rules) == 0 {
-		return
-	}
-
 	// Generate output filename: use package name instead of individual file name
 	// This ensures one authorizer file per Go package
 	outputName := strings.ReplaceAll(goPackage, "/", "_") + ".pb.authorizer.go"
@@ -113,6 +124,14 @@ func (m *module) generateForPackage(goPackage string, files []pgs.File) {
 		outputName = dir + outputName
 	}
 
+	// the manifest is generated for packages without rules too so that removed rules show up in a diff
+	if m.manifest {
+		m.generateManifest(strings.TrimSuffix(outputName, ".go")+".json", manifest)
+	}
+	if len(rules) == 0 {
+		return
+	}
+
 	var (
 		t   *template.Template
 		err error
@@ -136,6 +155,33 @@ func (m *module) generateForPackage(goPackage string, files []pgs.File) {
 			m.AddError(err.Error())
 			return
 		}
+	case "rego":
+		t, err = template.New("authorizer").Parse(regoTmpl)
+		if err != nil {
+			m.AddError(err.Error())
+			return
+		}
+	case "cedar":
+		t, err = template.New("authorizer").Parse(cedarTmpl)
+		if err != nil {
+			m.AddError(err.Error())
+			return
+		}
+	case "casbin":
+		t, err = template.New("authorizer").Parse(casbinTmpl)
+		if err != nil {
+			m.AddError(err.Error())
+			return
+		}
+	case "rebac":
+		t, err = template.New("authorizer").Parse(rebacTmpl)
+		if err != nil {
+			m.AddError(err.Error())
+			return
+		}
+	default:
+		m.AddError(fmt.Sprintf("unsupported authorizer: %s", m.authorizer))
+		return
 	}
 
 	buffer := &bytes.Buffer{}
@@ -147,6 +193,51 @@ func (m *module) generateForPackage(goPackage string, files []pgs.File) {
 		return
 	}
 	m.AddGeneratorFile(outputName, buffer.String())
+
+	if m.authorizer == "cedar" {
+		m.generateCedarPolicySet(strings.TrimSuffix(outputName, ".go")+".cedar", manifest)
+	}
+}
+
+// generateCedarPolicySet generates a Cedar policy set with the policies of every method scoped to the method's action
+// so that the policies can be validated and analyzed with the Cedar tooling
+func (m *module) generateCedarPolicySet(outputName string, manifest map[string]*authorize.RuleSet) {
+	rules := map[string]*authorize.RuleSet{}
+	for method, ruleSet := range manifest {
+		if ruleSet != nil {
+			rules[method] = ruleSet
+		}
+	}
+	policySet, err := cedar.NewPolicySet(rules)
+	if err != nil {
+		m.AddError(err.Error())
+		return
+	}
+	m.AddGeneratorFile(outputName, string(policySet.MarshalCedar())+"\n")
+}
+
+// generateManifest generates a json file mapping full method names to their RuleSets so that policy changes can be
+// inspected without compiling the generated code (see the policydiff package)
+func (m *module) generateManifest(outputName string, manifest map[string]*authorize.RuleSet) {
+	entries := map[string]json.RawMessage{}
+	for method, ruleSet := range manifest {
+		if ruleSet == nil {
+			entries[method] = json.RawMessage("null")
+			continue
+		}
+		bits, err := protojson.Marshal(ruleSet)
+		if err != nil {
+			m.AddError(err.Error())
+			return
+		}
+		entries[method] = bits
+	}
+	bits, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		m.AddError(err.Error())
+		return
+	}
+	m.AddGeneratorFile(outputName, string(bits)+"\n")
 }
 
 type templateData struct {
@@ -240,3 +331,123 @@ func NewAuthorizer(opts ...match.Opt) (*match.MatchAuthorizer, error) {
 }, opts...)
 }
 `
+
+// regoTmpl quotes the expressions with %q because Rego strings use double quotes
+var regoTmpl = `
+package {{ .Package }}
+
+import (
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/rego"
+)
+
+// NewAuthorizer returns a new rego authorizer. The rules map is a map of method names to RuleSets. The RuleSets are used to
+// authorize the method. The RuleSets are evaluated in order and the first rule that evaluates to true will authorize
+// the request. The mapping can be generated with the protoc-gen-authorize plugin.
+func NewAuthorizer(opts ...rego.Opt) (*rego.RegoAuthorizer, error) {
+	return rego.NewRegoAuthorizer(map[string]*authorize.RuleSet{
+	{{- range $key, $value := .Rules }}
+	{{$key}}: {
+		Rules: []*authorize.Rule{
+		{{- range $value.Rules }}
+			{
+				Expression: {{ printf "%q" .Expression }},
+			},
+		{{- end }}
+		},
+	},
+	{{- end }}
+}, opts...)
+}
+`
+
+// cedarTmpl quotes the expressions with %q because Cedar strings and entity ids use double quotes
+var cedarTmpl = `
+package {{ .Package }}
+
+import (
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/cedar"
+)
+
+// NewAuthorizer returns a new cedar authorizer. The rules map is a map of method names to RuleSets. The RuleSets are used to
+// authorize the method. The policies of all rules of a method are evaluated together as a Cedar policy set.
+// The mapping can be generated with the protoc-gen-authorize plugin.
+func NewAuthorizer(opts ...cedar.Opt) (*cedar.CedarAuthorizer, error) {
+	return cedar.NewCedarAuthorizer(map[string]*authorize.RuleSet{
+	{{- range $key, $value := .Rules }}
+	{{$key}}: {
+		Rules: []*authorize.Rule{
+		{{- range $value.Rules }}
+			{
+				Expression: {{ printf "%q" .Expression }},
+			},
+		{{- end }}
+		},
+	},
+	{{- end }}
+}, opts...)
+}
+`
+
+var casbinTmpl = `
+package {{ .Package }}
+
+import (
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/casbin"
+)
+
+// NewAuthorizer returns a new casbin authorizer. The rules map is a map of method names to RuleSets. The RuleSets are used to
+// authorize the method. The RuleSets are evaluated in order and the first rule that evaluates to true will authorize
+// the request. The mapping can be generated with the protoc-gen-authorize plugin.
+// Use casbin.WithModelFile and casbin.WithPolicyFile to load the model and policies.
+func NewAuthorizer(opts ...casbin.Opt) (*casbin.CasbinAuthorizer, error) {
+	return casbin.NewCasbinAuthorizer(map[string]*authorize.RuleSet{
+	{{- range $key, $value := .Rules }}
+	{{$key}}: {
+		Rules: []*authorize.Rule{
+		{{- range $value.Rules }}
+			{
+				Expression: {{ printf "%q" .Expression }},
+			},
+		{{- end }}
+		},
+	},
+	{{- end }}
+}, opts...)
+}
+`
+
+var rebacTmpl = `
+package {{ .Package }}
+
+import (
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/authorizer/rebac"
+)
+
+// NewAuthorizer returns a new rebac authorizer. The rules map is a map of method names to RuleSets. The RuleSets are used to
+// authorize the method. The RuleSets are evaluated in order and the first rule that evaluates to true will authorize
+// the request. The mapping can be generated with the protoc-gen-authorize plugin.
+// The checker resolves the "<permission> <type>:<id>" rules against the relationship schema and tuple store.
+func NewAuthorizer(checker *rebac.Checker, opts ...rebac.Opt) (*rebac.RebacAuthorizer, error) {
+	return rebac.NewRebacAuthorizer(map[string]*authorize.RuleSet{
+	{{- range $key, $value := .Rules }}
+	{{$key}}: {
+		Rules: []*authorize.Rule{
+		{{- range $value.Rules }}
+			{
+				Expression: {{ printf "%q" .Expression }},
+			},
+		{{- end }}
+		},
+	},
+	{{- end }}
+}, checker, opts...)
+}
+`
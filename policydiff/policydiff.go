@@ -0,0 +1,373 @@
+package policydiff
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/autom8ter/proto/gen/authorize"
+)
+
+// Policy is a map of full grpc method names (/package.Service/Method) to RuleSets.
+// A nil RuleSet means the method exists but has no authorize.rules option set.
+type Policy map[string]*authorize.RuleSet
+
+// FromFileDescriptorSet extracts the authorize.rules option of every service method in the descriptor set
+func FromFileDescriptorSet(fds *descriptorpb.FileDescriptorSet) (Policy, error) {
+	policy := Policy{}
+	for _, f := range fds.GetFile() {
+		for _, s := range f.GetService() {
+			svc := s.GetName()
+			if f.GetPackage() != "" {
+				svc = f.GetPackage() + "." + svc
+			}
+			for _, m := range s.GetMethod() {
+				name := fmt.Sprintf("/%s/%s", svc, m.GetName())
+				policy[name] = nil
+				if m.GetOptions() == nil || !proto.HasExtension(m.GetOptions(), authorize.E_Rules) {
+					continue
+				}
+				ruleSet, ok := proto.GetExtension(m.GetOptions(), authorize.E_Rules).(*authorize.RuleSet)
+				if !ok {
+					return nil, fmt.Errorf("policydiff: unexpected authorize.rules type on %s", name)
+				}
+				policy[name] = ruleSet
+			}
+		}
+	}
+	return policy, nil
+}
+
+// FromManifest parses a JSON manifest (as generated by the plugin with the manifest=true option) mapping
+// full method names to RuleSets
+func FromManifest(data []byte) (Policy, error) {
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("policydiff: failed to parse manifest: %v", err.Error())
+	}
+	policy := Policy{}
+	for method, entry := range entries {
+		policy[method] = nil
+		if string(entry) == "null" {
+			continue
+		}
+		ruleSet := &authorize.RuleSet{}
+		if err := protojson.Unmarshal(entry, ruleSet); err != nil {
+			return nil, fmt.Errorf("policydiff: failed to parse rules for %s: %v", method, err.Error())
+		}
+		policy[method] = ruleSet
+	}
+	return policy, nil
+}
+
+// ManifestSuffix is the suffix of the manifests generated by the plugin - one per Go package
+const ManifestSuffix = ".pb.authorizer.json"
+
+// Load loads a Policy from a file or directory. Files with a .json extension are treated as manifests, everything
+// else is treated as a binary FileDescriptorSet (protoc --descriptor_set_out / buf build -o). Directories are searched
+// recursively for manifests (ManifestSuffix) which are merged - so the output of several Go packages can be compared
+func Load(path string) (Policy, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("policydiff: failed to read %s: %v", path, err.Error())
+	}
+	if info.IsDir() {
+		return loadDir(path)
+	}
+	return loadFile(path)
+}
+
+func loadDir(dir string) (Policy, error) {
+	policy := Policy{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ManifestSuffix) {
+			return nil
+		}
+		manifest, err := loadFile(path)
+		if err != nil {
+			return err
+		}
+		for method, ruleSet := range manifest {
+			if _, ok := policy[method]; ok {
+				return fmt.Errorf("policydiff: %s is defined in more than one manifest (%s)", method, path)
+			}
+			policy[method] = ruleSet
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func loadFile(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("policydiff: failed to read %s: %v", path, err.Error())
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return FromManifest(data)
+	}
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, fds); err != nil {
+		return nil, fmt.Errorf("policydiff: failed to parse descriptor set %s: %v", path, err.Error())
+	}
+	return FromFileDescriptorSet(fds)
+}
+
+// ChangeKind is the kind of change made to a method's authorization rules
+type ChangeKind string
+
+const (
+	// ChangeMethodAdded is a new method without any rules
+	ChangeMethodAdded ChangeKind = "method_added"
+	// ChangeMethodRemoved is a method that no longer exists
+	ChangeMethodRemoved ChangeKind = "method_removed"
+	// ChangeRulesAdded is a method that had no rules and now has rules
+	ChangeRulesAdded ChangeKind = "rules_added"
+	// ChangeRulesRemoved is a method that had rules and now has none
+	ChangeRulesRemoved ChangeKind = "rules_removed"
+	// ChangeRuleAdded is an additional rule on a method that already had rules
+	ChangeRuleAdded ChangeKind = "rule_added"
+	// ChangeRuleRemoved is a rule that was removed from a method that still has rules
+	ChangeRuleRemoved ChangeKind = "rule_removed"
+	// ChangeExpressionChanged is a rule whose expression was replaced
+	ChangeExpressionChanged ChangeKind = "expression_changed"
+)
+
+// Change is a single semantic difference between two policies
+type Change struct {
+	// Method is the full grpc method name
+	Method string `json:"method"`
+	// Kind is the kind of change
+	Kind ChangeKind `json:"kind"`
+	// Old is the old expression (if any)
+	Old string `json:"old,omitempty"`
+	// New is the new expression (if any)
+	New string `json:"new,omitempty"`
+	// Looser is true if the change grants (or may grant) access that was not granted before
+	Looser bool `json:"looser"`
+}
+
+// String returns a human readable representation of the change
+func (c Change) String() string {
+	var sb strings.Builder
+	if c.Looser {
+		sb.WriteString("! ")
+	} else {
+		sb.WriteString("  ")
+	}
+	sb.WriteString(fmt.Sprintf("%s %s", c.Method, c.Kind))
+	switch {
+	case c.Old != "" && c.New != "":
+		sb.WriteString(fmt.Sprintf(": %q -> %q", c.Old, c.New))
+	case c.Old != "":
+		sb.WriteString(fmt.Sprintf(": %q", c.Old))
+	case c.New != "":
+		sb.WriteString(fmt.Sprintf(": %q", c.New))
+	}
+	return sb.String()
+}
+
+// Diff returns the changes required to turn the old policy into the new policy, sorted by method
+func Diff(old, new Policy) []Change {
+	var changes []Change
+	for _, method := range methods(old, new) {
+		oldRules, inOld := old[method]
+		newRules, inNew := new[method]
+		oldExprs := expressions(oldRules)
+		newExprs := expressions(newRules)
+		switch {
+		case !inOld && len(newExprs) == 0:
+			changes = append(changes, Change{Method: method, Kind: ChangeMethodAdded, Looser: true})
+		case !inNew:
+			changes = append(changes, Change{Method: method, Kind: ChangeMethodRemoved})
+		case len(oldExprs) == 0 && len(newExprs) > 0:
+			for _, e := range newExprs {
+				changes = append(changes, Change{Method: method, Kind: ChangeRulesAdded, New: e})
+			}
+		case len(oldExprs) > 0 && len(newExprs) == 0:
+			for _, e := range oldExprs {
+				changes = append(changes, Change{Method: method, Kind: ChangeRulesRemoved, Old: e, Looser: true})
+			}
+		default:
+			changes = append(changes, diffExpressions(method, oldExprs, newExprs)...)
+		}
+	}
+	return changes
+}
+
+// Looser returns true if any of the changes grants access that was not granted before
+func Looser(changes []Change) bool {
+	for _, c := range changes {
+		if c.Looser {
+			return true
+		}
+	}
+	return false
+}
+
+// diffExpressions compares the rule expressions of a method. Rules are OR'ed together, so added rules loosen the
+// policy and removed rules tighten it. Replaced expressions are paired up in order and reported as changed - they are
+// looser unless they are provably tighter (see tighter) so that a CI gate fails closed.
+func diffExpressions(method string, oldExprs, newExprs []string) []Change {
+	removed := subtract(oldExprs, newExprs)
+	added := subtract(newExprs, oldExprs)
+	var changes []Change
+	for len(removed) > 0 && len(added) > 0 {
+		changes = append(changes, Change{
+			Method: method,
+			Kind:   ChangeExpressionChanged,
+			Old:    removed[0],
+			New:    added[0],
+			Looser: !tighter(removed[0], added[0]),
+		})
+		removed, added = removed[1:], added[1:]
+	}
+	for _, e := range removed {
+		changes = append(changes, Change{Method: method, Kind: ChangeRuleRemoved, Old: e})
+	}
+	for _, e := range added {
+		changes = append(changes, Change{Method: method, Kind: ChangeRuleAdded, New: e, Looser: true})
+	}
+	return changes
+}
+
+// tighter returns true if the new expression can't allow a request that the old expression denies: the old
+// expression allowed everything (*), the new expression is false or the new expression is a conjunction (&&) that
+// contains the old expression or all of its conjuncts
+func tighter(oldExpr, newExpr string) bool {
+	oldExpr, newExpr = strings.TrimSpace(oldExpr), strings.TrimSpace(newExpr)
+	if oldExpr == "*" || newExpr == "false" {
+		return true
+	}
+	conjuncts := map[string]struct{}{}
+	for _, c := range splitConjuncts(newExpr) {
+		conjuncts[c] = struct{}{}
+	}
+	if _, ok := conjuncts[unparen(oldExpr)]; ok {
+		return true
+	}
+	oldConjuncts := splitConjuncts(oldExpr)
+	if len(oldConjuncts) < 2 {
+		return false
+	}
+	for _, c := range oldConjuncts {
+		if _, ok := conjuncts[c]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// splitConjuncts splits an expression on the && operators outside of parentheses and string literals. Conjuncts are
+// trimmed and stripped of enclosing parentheses. An expression with a top level || is returned as a single conjunct
+func splitConjuncts(expr string) []string {
+	var (
+		conjuncts []string
+		depth     int
+		quote     byte
+		start     int
+	)
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		case depth == 0 && strings.HasPrefix(expr[i:], "||"):
+			return []string{unparen(expr)}
+		case depth == 0 && strings.HasPrefix(expr[i:], "&&"):
+			conjuncts = append(conjuncts, unparen(expr[start:i]))
+			start = i + 2
+			i++
+		}
+	}
+	return append(conjuncts, unparen(expr[start:]))
+}
+
+// unparen trims an expression and removes parentheses enclosing the whole expression
+func unparen(expr string) string {
+	expr = strings.TrimSpace(expr)
+	for len(expr) >= 2 && expr[0] == '(' && expr[len(expr)-1] == ')' {
+		depth := 0
+		enclosed := true
+		for i := 0; i < len(expr)-1; i++ {
+			switch expr[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			}
+			if depth == 0 {
+				enclosed = false
+				break
+			}
+		}
+		if !enclosed {
+			break
+		}
+		expr = strings.TrimSpace(expr[1 : len(expr)-1])
+	}
+	return expr
+}
+
+func subtract(a, b []string) []string {
+	counts := map[string]int{}
+	for _, e := range b {
+		counts[e]++
+	}
+	var result []string
+	for _, e := range a {
+		if counts[e] > 0 {
+			counts[e]--
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+func expressions(rules *authorize.RuleSet) []string {
+	var exprs []string
+	for _, r := range rules.GetRules() {
+		exprs = append(exprs, r.GetExpression())
+	}
+	return exprs
+}
+
+func methods(policies ...Policy) []string {
+	seen := map[string]struct{}{}
+	var names []string
+	for _, p := range policies {
+		for k := range p {
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
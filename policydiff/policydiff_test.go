@@ -0,0 +1,259 @@
+package policydiff_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/autom8ter/proto/gen/authorize"
+
+	"github.com/storm-blue/protoc-gen-authorize/policydiff"
+)
+
+func method(name string, exprs ...string) *descriptorpb.MethodDescriptorProto {
+	m := &descriptorpb.MethodDescriptorProto{Name: proto.String(name)}
+	if len(exprs) == 0 {
+		return m
+	}
+	ruleSet := &authorize.RuleSet{}
+	for _, e := range exprs {
+		ruleSet.Rules = append(ruleSet.Rules, &authorize.Rule{Expression: e})
+	}
+	m.Options = &descriptorpb.MethodOptions{}
+	proto.SetExtension(m.Options, authorize.E_Rules, ruleSet)
+	return m
+}
+
+func descriptorSet(methods ...*descriptorpb.MethodDescriptorProto) *descriptorpb.FileDescriptorSet {
+	return &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			{
+				Name:    proto.String("example/example.proto"),
+				Package: proto.String("example"),
+				Service: []*descriptorpb.ServiceDescriptorProto{
+					{
+						Name:   proto.String("ExampleService"),
+						Method: methods,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestFromFileDescriptorSet(t *testing.T) {
+	policy, err := policydiff.FromFileDescriptorSet(descriptorSet(
+		method("Ping"),
+		method("RequestMatch", "user.IsSuperAdmin"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy) != 2 {
+		t.Fatalf("expected 2 methods, got %d", len(policy))
+	}
+	if rules, ok := policy["/example.ExampleService/Ping"]; !ok || rules != nil {
+		t.Fatalf("expected Ping to exist without rules, got %v", rules)
+	}
+	if got := policy["/example.ExampleService/RequestMatch"].GetRules()[0].GetExpression(); got != "user.IsSuperAdmin" {
+		t.Fatalf("unexpected expression: %s", got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type fixture struct {
+		name         string
+		old          policydiff.Policy
+		new          policydiff.Policy
+		expectKinds  []policydiff.ChangeKind
+		expectLooser bool
+	}
+	rules := func(exprs ...string) *authorize.RuleSet {
+		r := &authorize.RuleSet{}
+		for _, e := range exprs {
+			r.Rules = append(r.Rules, &authorize.Rule{Expression: e})
+		}
+		return r
+	}
+	fixtures := []fixture{
+		{
+			name: "no changes",
+			old:  policydiff.Policy{"/a.S/M": rules("x"), "/a.S/N": nil},
+			new:  policydiff.Policy{"/a.S/M": rules("x"), "/a.S/N": nil},
+		},
+		{
+			name:         "method added without rules",
+			old:          policydiff.Policy{"/a.S/M": rules("x")},
+			new:          policydiff.Policy{"/a.S/M": rules("x"), "/a.S/N": nil},
+			expectKinds:  []policydiff.ChangeKind{policydiff.ChangeMethodAdded},
+			expectLooser: true,
+		},
+		{
+			name:        "method added with rules",
+			old:         policydiff.Policy{},
+			new:         policydiff.Policy{"/a.S/M": rules("x")},
+			expectKinds: []policydiff.ChangeKind{policydiff.ChangeRulesAdded},
+		},
+		{
+			name:         "rules removed",
+			old:          policydiff.Policy{"/a.S/M": rules("x", "y")},
+			new:          policydiff.Policy{"/a.S/M": nil},
+			expectKinds:  []policydiff.ChangeKind{policydiff.ChangeRulesRemoved, policydiff.ChangeRulesRemoved},
+			expectLooser: true,
+		},
+		{
+			name:         "rule added",
+			old:          policydiff.Policy{"/a.S/M": rules("x")},
+			new:          policydiff.Policy{"/a.S/M": rules("x", "y")},
+			expectKinds:  []policydiff.ChangeKind{policydiff.ChangeRuleAdded},
+			expectLooser: true,
+		},
+		{
+			name:        "rule removed",
+			old:         policydiff.Policy{"/a.S/M": rules("x", "y")},
+			new:         policydiff.Policy{"/a.S/M": rules("y")},
+			expectKinds: []policydiff.ChangeKind{policydiff.ChangeRuleRemoved},
+		},
+		{
+			name:         "expression changed",
+			old:          policydiff.Policy{"/a.S/M": rules("x", "y")},
+			new:          policydiff.Policy{"/a.S/M": rules("x", "z")},
+			expectKinds:  []policydiff.ChangeKind{policydiff.ChangeExpressionChanged},
+			expectLooser: true,
+		},
+		{
+			name:         "expression changed to true",
+			old:          policydiff.Policy{"/a.S/M": rules("user.role == 'admin'")},
+			new:          policydiff.Policy{"/a.S/M": rules("true")},
+			expectKinds:  []policydiff.ChangeKind{policydiff.ChangeExpressionChanged},
+			expectLooser: true,
+		},
+		{
+			name:        "expression narrowed with a conjunct",
+			old:         policydiff.Policy{"/a.S/M": rules("user.role == 'admin' || user.super")},
+			new:         policydiff.Policy{"/a.S/M": rules("(user.role == 'admin' || user.super) && request.id != ''")},
+			expectKinds: []policydiff.ChangeKind{policydiff.ChangeExpressionChanged},
+		},
+		{
+			name:        "expression conjuncts reordered and extended",
+			old:         policydiff.Policy{"/a.S/M": rules("a && b")},
+			new:         policydiff.Policy{"/a.S/M": rules("b && (c || d) && a")},
+			expectKinds: []policydiff.ChangeKind{policydiff.ChangeExpressionChanged},
+		},
+		{
+			name:         "expression with a disjunction is not a conjunction",
+			old:          policydiff.Policy{"/a.S/M": rules("a")},
+			new:          policydiff.Policy{"/a.S/M": rules("a && b || c")},
+			expectKinds:  []policydiff.ChangeKind{policydiff.ChangeExpressionChanged},
+			expectLooser: true,
+		},
+		{
+			name:        "expression changed from allow all",
+			old:         policydiff.Policy{"/a.S/M": rules("*")},
+			new:         policydiff.Policy{"/a.S/M": rules("x")},
+			expectKinds: []policydiff.ChangeKind{policydiff.ChangeExpressionChanged},
+		},
+		{
+			name:         "expression changed to allow all",
+			old:          policydiff.Policy{"/a.S/M": rules("x")},
+			new:          policydiff.Policy{"/a.S/M": rules("*")},
+			expectKinds:  []policydiff.ChangeKind{policydiff.ChangeExpressionChanged},
+			expectLooser: true,
+		},
+		{
+			name:        "method removed",
+			old:         policydiff.Policy{"/a.S/M": rules("x")},
+			new:         policydiff.Policy{},
+			expectKinds: []policydiff.ChangeKind{policydiff.ChangeMethodRemoved},
+		},
+	}
+	for _, f := range fixtures {
+		t.Run(f.name, func(t *testing.T) {
+			changes := policydiff.Diff(f.old, f.new)
+			if len(changes) != len(f.expectKinds) {
+				t.Fatalf("expected %d changes, got %d: %v", len(f.expectKinds), len(changes), changes)
+			}
+			for i, c := range changes {
+				if c.Kind != f.expectKinds[i] {
+					t.Fatalf("expected change %d to be %s, got %s", i, f.expectKinds[i], c.Kind)
+				}
+			}
+			if policydiff.Looser(changes) != f.expectLooser {
+				t.Fatalf("expected looser=%v, got %v", f.expectLooser, !f.expectLooser)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	bits, err := proto.Marshal(descriptorSet(method("RequestMatch", "user.IsSuperAdmin")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptorPath := filepath.Join(dir, "old.binpb")
+	if err := os.WriteFile(descriptorPath, bits, 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(dir, "new.json")
+	manifest := `{
+  "/example.ExampleService/RequestMatch": {"rules": [{"expression": "user.IsSuperAdmin"}, {"expression": "*"}]},
+  "/example.ExampleService/Ping": null
+}`
+	if err := os.WriteFile(manifestPath, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	oldPolicy, err := policydiff.Load(descriptorPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPolicy, err := policydiff.Load(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := policydiff.Diff(oldPolicy, newPolicy)
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %d: %v", len(changes), changes)
+	}
+	if changes[0].Kind != policydiff.ChangeMethodAdded || changes[1].Kind != policydiff.ChangeRuleAdded {
+		t.Fatalf("unexpected changes: %v", changes)
+	}
+	if !policydiff.Looser(changes) {
+		t.Fatal("expected policy to be looser")
+	}
+}
+
+func TestLoad_Dir(t *testing.T) {
+	dir := t.TempDir()
+	manifests := map[string]string{
+		"a/a.pb.authorizer.json": `{"/a.S/M": {"rules": [{"expression": "x"}]}}`,
+		"b/b.pb.authorizer.json": `{"/b.S/M": null}`,
+		"b/other.json":           `not a manifest`,
+	}
+	for name, content := range manifests {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	policy, err := policydiff.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policy) != 2 || policy["/a.S/M"].GetRules()[0].GetExpression() != "x" {
+		t.Fatalf("unexpected policy: %v", policy)
+	}
+	// a method defined in two packages is an error
+	if err := os.WriteFile(filepath.Join(dir, "c.pb.authorizer.json"), []byte(`{"/a.S/M": null}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := policydiff.Load(dir); err == nil {
+		t.Fatal("expected an error for a duplicate method")
+	}
+}